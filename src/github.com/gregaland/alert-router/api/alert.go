@@ -1,14 +1,36 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/gorilla/mux"
+	"github.com/gregaland/alert-router/auth"
 	"github.com/gregaland/alert-router/config"
+	"github.com/gregaland/alert-router/metrics"
 	"github.com/gregaland/alert-router/routemgr"
 	"github.com/gregaland/alert-router/routers"
+	"github.com/gregaland/alert-router/wire"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"mime"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// fires listed when no limit is given
+	DEFAULT_FIRE_LIMIT int = 100
+
+	// how long a fire request waits for its deliveries
+	DEFAULT_WAIT_TIMEOUT time.Duration = 30 * time.Second
+	MAX_WAIT_TIMEOUT     time.Duration = 5 * time.Minute
 )
 
 // API payload
@@ -16,11 +38,28 @@ type Event struct {
 	Message string `json:"msg,omitempty"`
 }
 
+// Response to a fire
+type FireResponse struct {
+	FireId string `json:"fire_id"`
+}
+
+// Body of a router test: the message and, for email routers, who to send
+// it to
+type RouterTestRequest struct {
+	Message    string   `json:"msg,omitempty"`
+	EmailAddrs []string `json:"email_addrs,omitempty"`
+}
+
 // RigAlert
 type AlertApi struct {
-	config       *config.RigConfig
-	routeMgr     *routemgr.RouteMgr
-	router       *mux.Router
+	config   *config.RigConfig
+	routeMgr *routemgr.RouteMgr
+	router   *mux.Router
+	keys     apiKeys
+	verifier *auth.Verifier
+	tls      tlsFiles
+	server   *http.Server
+	metrics  http.Handler
 }
 
 // NewRigAlert returns a new instance
@@ -28,121 +67,796 @@ func NewAlertApi(config *config.RigConfig, mgr *routemgr.RouteMgr) *AlertApi {
 	alertApi := &AlertApi{}
 	alertApi.config = config
 	alertApi.routeMgr = mgr
+	alertApi.verifier = auth.NewVerifier(config.SignatureWindow())
+	alertApi.metrics = promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{})
 
 	alertApi.router = mux.NewRouter()
-	alertApi.router.HandleFunc("/v1/alerts/{id}/fire", alertApi.SendAlert).Methods("POST")
-	alertApi.router.HandleFunc("/v1/alerts/{id}", alertApi.AddAlert).Methods("POST")
-	alertApi.router.HandleFunc("/v1/alerts/{id}", alertApi.UpdateAlert).Methods("PUT")
-	alertApi.router.HandleFunc("/v1/alerts/{id}", alertApi.DeleteAlert).Methods("DELETE")
-	alertApi.router.HandleFunc("/v1/alerts", alertApi.ListAlerts).Methods("GET")
+	alertApi.router.Use(alertApi.authenticate)
+	alertApi.router.HandleFunc("/v1/alerts/{id}/fire", alertApi.signed(alertApi.allow("fire:{id}", alertApi.SendAlert))).Methods("POST")
+	alertApi.router.HandleFunc("/v1/alerts/{id}/simulate", alertApi.allow(auth.SCOPE_CONFIG_READ, alertApi.Simulate)).Methods("POST")
+	alertApi.router.HandleFunc("/v1/alerts/{id}/schedule/{schedule_id}", alertApi.allow(auth.SCOPE_CONFIG_WRITE, alertApi.PatchSchedule)).Methods("PATCH")
+	alertApi.router.HandleFunc("/v1/alerts/{id}", alertApi.allow(auth.SCOPE_CONFIG_READ, alertApi.GetAlert)).Methods("GET")
+	alertApi.router.HandleFunc("/v1/alerts/{id}", alertApi.allow(auth.SCOPE_CONFIG_WRITE, alertApi.AddAlert)).Methods("POST")
+	alertApi.router.HandleFunc("/v1/alerts/{id}", alertApi.allow(auth.SCOPE_CONFIG_WRITE, alertApi.UpdateAlert)).Methods("PUT")
+	alertApi.router.HandleFunc("/v1/alerts/{id}", alertApi.allow(auth.SCOPE_CONFIG_WRITE, alertApi.DeleteAlert)).Methods("DELETE")
+	alertApi.router.HandleFunc("/v1/alerts", alertApi.allow(auth.SCOPE_CONFIG_READ, alertApi.ListAlerts)).Methods("GET")
+	alertApi.router.HandleFunc("/v1/alerts:apply", alertApi.allow(auth.SCOPE_CONFIG_WRITE, alertApi.ApplyAlerts)).Methods("POST")
+	alertApi.router.HandleFunc("/v1/routers/{router_id}/test", alertApi.allow(auth.SCOPE_CONFIG_WRITE, alertApi.TestRouter)).Methods("POST")
+	alertApi.router.HandleFunc("/v1/routers/{router_id}", alertApi.allow(auth.SCOPE_CONFIG_READ, alertApi.GetRouter)).Methods("GET")
+	alertApi.router.HandleFunc("/v1/routers/{router_id}", alertApi.allow(auth.SCOPE_CONFIG_WRITE, alertApi.AddRouter)).Methods("POST")
+	alertApi.router.HandleFunc("/v1/routers/{router_id}", alertApi.allow(auth.SCOPE_CONFIG_WRITE, alertApi.UpdateRouter)).Methods("PUT")
+	alertApi.router.HandleFunc("/v1/routers/{router_id}", alertApi.allow(auth.SCOPE_CONFIG_WRITE, alertApi.DeleteRouter)).Methods("DELETE")
+	alertApi.router.HandleFunc("/v1/routers", alertApi.allow(auth.SCOPE_CONFIG_READ, alertApi.ListRouters)).Methods("GET")
+	alertApi.router.HandleFunc("/v1/fires/{fire_id}", alertApi.allow(auth.SCOPE_CONFIG_READ, alertApi.GetFire)).Methods("GET")
+	alertApi.router.HandleFunc("/v1/fires", alertApi.allow(auth.SCOPE_CONFIG_READ, alertApi.ListFires)).Methods("GET")
+	alertApi.router.HandleFunc("/v1/-/reload", alertApi.allow(auth.SCOPE_CONFIG_WRITE, alertApi.Reload)).Methods("POST")
+	alertApi.router.HandleFunc("/v1/-/scheduler", alertApi.allow(auth.SCOPE_CONFIG_READ, alertApi.ListJobs)).Methods("GET")
+	alertApi.router.HandleFunc("/v1/openapi.json", alertApi.OpenAPI).Methods("GET")
 	alertApi.router.HandleFunc("/v1/ekg", alertApi.Ekg).Methods("GET")
+	alertApi.router.HandleFunc("/metrics", alertApi.Metrics).Methods("GET")
+	alertApi.router.HandleFunc("/healthz", alertApi.Healthz).Methods("GET")
+	alertApi.router.HandleFunc("/readyz", alertApi.Readyz).Methods("GET")
+	alertApi.router.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		notFound(w, "no route for "+r.URL.Path)
+	})
+	alertApi.router.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusMethodNotAllowed, CODE_METHOD_NOT_ALLOWED, r.Method+" is not allowed on "+r.URL.Path, "")
+	})
+
+	alertApi.server = &http.Server{Addr: config.Listen, Handler: alertApi.router}
 
 	return alertApi
 }
 
-// Listen and Serve until process terminated
-func (aa *AlertApi) ListenAndServe() {
-	log.Fatal(http.ListenAndServe(aa.config.Listen, aa.router))
+// Listen and Serve until Shutdown is called.  With tls_cert_file set
+// the API is served over HTTPS.
+func (aa *AlertApi) ListenAndServe() error {
+	var err error
+	if aa.config.TLSEnabled() {
+		aa.server.TLSConfig = aa.tlsConfig()
+		err = aa.server.ListenAndServeTLS("", "")
+	} else {
+		err = aa.server.ListenAndServe()
+	}
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
 }
 
-// API Endpoint: /v1/alerts/{id}/fire
+// ServeHTTP serves the API without listening, for tests and embedding
+func (aa *AlertApi) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	aa.router.ServeHTTP(w, r)
+}
+
+// Shutdown stops accepting connections and waits until the requests in
+// progress are done or ctx is
+func (aa *AlertApi) Shutdown(ctx context.Context) error {
+	return aa.server.Shutdown(ctx)
+}
+
+// Fire an alert.  The response holds the id of the fire's record in the
+// history.
 //
+// With ?wait=true or "Prefer: wait" the response is sent once the
+// routers are done, up to ?timeout or the seconds in "Prefer: wait=N",
+// and is the fire with the outcome of each schedule.  It is 200 if every
+// delivery succeeded, 207 if some did, 502 if none did, 504 if none
+// finished in time and 422 if every schedule was skipped.
+// API Endpoint: /v1/alerts/{id}/fire
 func (aa *AlertApi) SendAlert(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	var event Event
-	_ = json.NewDecoder(r.Body).Decode(&event)
+	if err := decodeJSON(r, &event, false); err != nil {
+		badRequest(w, err)
+		return
+	}
 	alertId := params["id"]
+	routeEvent := &routers.Event{Id: alertId, Message: event.Message}
+
+	wait, timeout, err := waitParams(r)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	var fire *wire.Fire
+	var body []byte
+	if wait {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		fire, err = aa.routeMgr.RouteWait(ctx, routeEvent)
+		body, _ = json.Marshal(fire)
+		w.Header().Set("Preference-Applied", "wait")
+	} else {
+		fire, err = aa.routeMgr.Route(routeEvent)
+		body, _ = json.Marshal(FireResponse{FireId: fire.Id})
+	}
+	if errors.Cause(err) == routemgr.ErrUnknownAlert {
+		writeErrorResponse(w, http.StatusNotFound, &ErrorResponse{
+			Error:  ErrorDetail{Code: CODE_NOT_FOUND, Message: err.Error()},
+			FireId: fire.Id,
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case wait:
+		w.WriteHeader(fireStatus(fire))
+	case err != nil:
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	_, err = w.Write(body)
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// Whether a fire request waits for its deliveries, and for how long
+func waitParams(r *http.Request) (bool, time.Duration, error) {
+	wait, timeout := false, DEFAULT_WAIT_TIMEOUT
+	for _, prefer := range r.Header["Prefer"] {
+		for _, p := range strings.Split(prefer, ",") {
+			kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+			if kv[0] != "wait" {
+				continue
+			}
+			wait = true
+			if len(kv) == 2 {
+				secs, err := strconv.Atoi(kv[1])
+				if err != nil || secs <= 0 {
+					return false, 0, &fieldError{"Prefer", errors.Errorf("invalid preference %s", p)}
+				}
+				timeout = time.Duration(secs) * time.Second
+			}
+		}
+	}
+	query := r.URL.Query()
+	if v := query.Get("wait"); v != "" {
+		w, err := strconv.ParseBool(v)
+		if err != nil {
+			return false, 0, &fieldError{"wait", err}
+		}
+		wait = w
+	}
+	if v := query.Get("timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return false, 0, &fieldError{"timeout", errors.Errorf("invalid duration %s", v)}
+		}
+		timeout = d
+	}
+	if timeout > MAX_WAIT_TIMEOUT {
+		timeout = MAX_WAIT_TIMEOUT
+	}
+	return wait, timeout, nil
+}
+
+// The status of a fire that was waited for
+func fireStatus(fire *wire.Fire) int {
+	counts := make(map[string]int)
+	for _, s := range fire.Schedules {
+		counts[s.Outcome]++
+	}
+	delivered, failed, pending := counts[wire.OUTCOME_DELIVERED], counts[wire.OUTCOME_FAILED], counts[wire.OUTCOME_PENDING]
+	switch {
+	case delivered == 0 && failed == 0 && pending == 0:
+		// nobody was notified; the schedules say why
+		return http.StatusUnprocessableEntity
+	case failed == 0 && pending == 0:
+		return http.StatusOK
+	case delivered > 0:
+		return http.StatusMultiStatus
+	case failed > 0:
+		return http.StatusBadGateway
+	}
+	return http.StatusGatewayTimeout
+}
+
+// Show which schedules of an Alert ID would be enabled at an instant,
+// ?at in RFC 3339 (default now), and what their routers would send for
+// the message in the body.  Nothing is sent.
+// API Endpoint: POST /v1/alerts/{id}/simulate
+func (aa *AlertApi) Simulate(w http.ResponseWriter, r *http.Request) {
+	var event Event
+	if err := decodeJSON(r, &event, false); err != nil {
+		badRequest(w, err)
+		return
+	}
+	at := time.Now()
+	if v := r.URL.Query().Get("at"); v != "" {
+		var err error
+		if at, err = time.Parse(time.RFC3339, v); err != nil {
+			badRequest(w, &fieldError{"at", err})
+			return
+		}
+	}
+
+	alertId := mux.Vars(r)["id"]
+	sim, found, err := aa.routeMgr.Simulate(&routers.Event{Id: alertId, Message: event.Message}, at)
+	if !found {
+		notFound(w, "alert "+alertId+" not found")
+		return
+	}
+	var body []byte
+	if err == nil {
+		body, err = json.Marshal(sim)
+	}
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(body)
+	if err != nil {
+		log.Error(err)
+	}
+}
 
-	err := aa.routeMgr.Route(&routers.Event{Id: alertId, Message: event.Message})
+// Send a test message through a router and wait for the result, up to
+// ?timeout.  The status is 200 if it was delivered, 502 if it failed
+// and 504 if the router didn't finish in time.
+// API Endpoint: POST /v1/routers/{router_id}/test
+func (aa *AlertApi) TestRouter(w http.ResponseWriter, r *http.Request) {
+	var req RouterTestRequest
+	if err := decodeJSON(r, &req, true); err != nil {
+		badRequest(w, err)
+		return
+	}
+	_, timeout, err := waitParams(r)
 	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
+		badRequest(w, err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	routerId := mux.Vars(r)["router_id"]
+	log.Infof("testing router: %s", routerId)
+	result, found, err := aa.routeMgr.TestRouter(ctx, routerId, req.Message, config.RouterParms{EmailAddrs: req.EmailAddrs})
+	switch {
+	case !found:
+		notFound(w, "router "+routerId+" not found")
+		return
+	case err != nil:
+		badRequest(w, err)
+		return
+	}
+
+	body, _ := json.Marshal(result)
+	w.Header().Set("Content-Type", "application/json")
+	switch result.Outcome {
+	case wire.OUTCOME_FAILED:
+		w.WriteHeader(http.StatusBadGateway)
+	case wire.OUTCOME_PENDING:
+		w.WriteHeader(http.StatusGatewayTimeout)
+	}
+	_, err = w.Write(body)
+	if err != nil {
+		log.Error(err)
 	}
 }
 
+// List the routers with their redacted config and health, those of the
+// main config first
+// API Endpoint: GET /v1/routers
+func (aa *AlertApi) ListRouters(w http.ResponseWriter, r *http.Request) {
+	body, err := json.Marshal(aa.routeMgr.ListRouters())
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(body)
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// Get a router.  The ETag is the revision of routers added through the
+// api.
+// API Endpoint: GET /v1/routers/{router_id}
+func (aa *AlertApi) GetRouter(w http.ResponseWriter, r *http.Request) {
+	routerId := mux.Vars(r)["router_id"]
+	info, ok := aa.routeMgr.GetRouter(routerId)
+	if !ok {
+		notFound(w, "router "+routerId+" not found")
+		return
+	}
+	body, err := json.Marshal(info)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	setETag(w, info.Revision)
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(body)
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// Add a router.  The body is a router entry as in the main config, in
+// JSON or YAML.  It is kept in the store.
+// API Endpoint: POST /v1/routers/{router_id}
+func (aa *AlertApi) AddRouter(w http.ResponseWriter, r *http.Request) {
+	routerId := mux.Vars(r)["router_id"]
+	log.Infof("adding router: %s", routerId)
+	rev, err := aa.routeMgr.AddRouter(routerId, r)
+	writeChange(w, true, rev, err)
+}
 
+// Update a router added through the api.  With If-Match the router must
+// still be at that revision.
+// API Endpoint: PUT /v1/routers/{router_id}
+func (aa *AlertApi) UpdateRouter(w http.ResponseWriter, r *http.Request) {
+	routerId := mux.Vars(r)["router_id"]
+	log.Infof("updating router: %s", routerId)
+	found, rev, err := aa.routeMgr.UpdateRouter(routerId, r, ifMatch(r))
+	writeChange(w, found, rev, err)
+}
+
+// Delete a router added through the api.  Routers that alerts are routed
+// to can't be deleted.  With If-Match the router must still be at that
+// revision.
+// API Endpoint: DELETE /v1/routers/{router_id}
+func (aa *AlertApi) DeleteRouter(w http.ResponseWriter, r *http.Request) {
+	routerId := mux.Vars(r)["router_id"]
+	log.Infof("deleting router: %s", routerId)
+	found, err := aa.routeMgr.DeleteRouter(routerId, ifMatch(r))
+	writeChange(w, found, "", err)
+}
 
-// Add an Alert id
+// List fires from the history, newest first.
 //
-// API Endpoint: POST /v1/alerts/{id}
+// Query parameters:
+//
+//	alert  only fires of this alert
+//	since  RFC 3339 time or a duration before now, such as 24h
+//	limit  most fires returned, default 100
+//
+// API Endpoint: GET /v1/fires
+func (aa *AlertApi) ListFires(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := &routemgr.FireFilter{AlertId: query.Get("alert"), Limit: DEFAULT_FIRE_LIMIT}
+	if v := query.Get("since"); v != "" {
+		since, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			d, derr := time.ParseDuration(v)
+			if derr != nil {
+				badRequest(w, &fieldError{"since", errors.Errorf("not a time or duration: %s", v)})
+				return
+			}
+			since = time.Now().Add(-d)
+		}
+		filter.Since = since
+	}
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 0 {
+			badRequest(w, &fieldError{"limit", errors.Errorf("not a count: %s", v)})
+			return
+		}
+		filter.Limit = limit
+	}
+
+	fires, err := aa.routeMgr.ListFires(filter)
+	if err != nil {
+		log.Error(err)
+		writeError(w, http.StatusServiceUnavailable, CODE_UNAVAILABLE, "fire history is unavailable", "")
+		return
+	}
+	body, err := json.Marshal(fires)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(body)
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// Get a fire with the outcome of each schedule
+// API Endpoint: GET /v1/fires/{fire_id}
+func (aa *AlertApi) GetFire(w http.ResponseWriter, r *http.Request) {
+	fireId := mux.Vars(r)["fire_id"]
+	fire, ok := aa.routeMgr.GetFire(fireId)
+	if !ok {
+		notFound(w, "fire "+fireId+" not found")
+		return
+	}
+	body, err := json.Marshal(fire)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(body)
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// Add an Alert id.  An alert with the id must not exist yet.
 //
+// API Endpoint: POST /v1/alerts/{id}
 func (aa *AlertApi) AddAlert(w http.ResponseWriter, r *http.Request) {
 	alertId := mux.Vars(r)["id"]
 	log.Infof("adding alert: %s", alertId)
-    found, err := aa.routeMgr.AddAlert(alertId, r)
-    if err != nil {
+	rev, err := aa.routeMgr.AddAlert(alertId, r)
+	writeChange(w, true, rev, err)
+}
+
+// Get an Alert ID with the live state of its schedules.  The ETag is the
+// revision of the alert's config.
+// API Endpoint: GET /v1/alerts/{id}
+func (aa *AlertApi) GetAlert(w http.ResponseWriter, r *http.Request) {
+	alertId := mux.Vars(r)["id"]
+	as, ok := aa.routeMgr.GetAlertStatus(alertId)
+	if !ok {
+		notFound(w, "alert "+alertId+" not found")
+		return
+	}
+	body, err := json.Marshal(as)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	setETag(w, as.Revision)
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(body)
+	if err != nil {
 		log.Error(err)
-		w.WriteHeader(http.StatusBadRequest)
-	} else if !found {
-		w.WriteHeader(http.StatusNotFound)
 	}
 }
 
-// Update an Alert ID
+// Update an Alert ID.  The new config replaces the old one only if it is
+// valid.  With If-Match the alert must still be at that revision.
 // API Endpoint: PUT /v1/alerts/{id}
-//
 func (aa *AlertApi) UpdateAlert(w http.ResponseWriter, r *http.Request) {
 
 	alertId := mux.Vars(r)["id"]
 	log.Infof("updating alert: %s", alertId)
-	found, err := aa.routeMgr.DeleteAlert(alertId)
+	found, rev, err := aa.routeMgr.UpdateAlert(alertId, r, ifMatch(r))
+	writeChange(w, found, rev, err)
+}
+
+// Update one schedule of an Alert ID with a JSON merge patch
+// API Endpoint: PATCH /v1/alerts/{id}/schedule/{schedule_id}
+func (aa *AlertApi) PatchSchedule(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	log.Infof("patching alert: %s schedule: %s", params["id"], params["schedule_id"])
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/merge-patch+json" && mediaType != "application/json" {
+		writeError(w, http.StatusUnsupportedMediaType, CODE_UNSUPPORTED_MEDIA_TYPE,
+			"patches must be application/merge-patch+json", "Content-Type")
+		return
+	}
+	patch, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		log.Error(err)
-		w.WriteHeader(http.StatusInternalServerError)
-	} else if !found {
-		w.WriteHeader(http.StatusNotFound)
-	} else {
-		_, err = aa.routeMgr.AddAlert(alertId, r)
-		if err != nil {
-			log.Error(err)
-			w.WriteHeader(http.StatusInternalServerError)
-		}
+		badRequest(w, err)
+		return
 	}
+	found, rev, err := aa.routeMgr.PatchSchedule(params["id"], params["schedule_id"], patch, ifMatch(r))
+	writeChange(w, found, rev, err)
 }
 
-// Delete an Alert ID
+// Delete an Alert ID.  With If-Match the alert must still be at that
+// revision.
 // API Endpoint: DELETE /v1/alerts/{id}
-//
 func (aa *AlertApi) DeleteAlert(w http.ResponseWriter, r *http.Request) {
 
 	alertId := mux.Vars(r)["id"]
 	log.Infof("deleting alert: %s", alertId)
 
-	found, err := aa.routeMgr.DeleteAlert(alertId)
+	found, err := aa.routeMgr.DeleteAlert(alertId, ifMatch(r))
+	writeChange(w, found, "", err)
+}
+
+// Make the alerts match the set of alert configs in the body, YAML
+// documents or a JSON array, and return the change to each alert.
+//
+// Query parameters:
+//
+//	dry_run  true to only return the changes
+//	prune    true to delete the alerts that are not in the set
+//
+// API Endpoint: POST /v1/alerts:apply
+func (aa *AlertApi) ApplyAlerts(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	dryRun, err := boolParam(query, "dry_run")
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	prune, err := boolParam(query, "prune")
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	alertConfigs, err := config.ParseAlertConfigs(data)
+	if err != nil {
+		log.Errorf("failed to parse alert configs: %v", err)
+		writeError(w, http.StatusBadRequest, CODE_INVALID_ALERT, err.Error(), "")
+		return
+	}
+
+	log.Infof("applying %d alert configs, dry run: %t, prune: %t", len(alertConfigs), dryRun, prune)
+	result, err := aa.routeMgr.Apply(alertConfigs, prune, dryRun)
+	if err != nil {
+		writeChange(w, true, "", err)
+		return
+	}
+	body, err := json.Marshal(result)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(body)
 	if err != nil {
 		log.Error(err)
-		w.WriteHeader(http.StatusInternalServerError)
-	} else if !found {
-		w.WriteHeader(http.StatusNotFound)
 	}
 }
 
-// Delete an Alert ID
-// API Endpoint: DELETE /v1/alerts/{id}
+// The revision in the If-Match header.  "*" matches any revision.
+func ifMatch(r *http.Request) string {
+	rev := strings.TrimSpace(r.Header.Get("If-Match"))
+	if rev == "*" {
+		return ""
+	}
+	return strings.Trim(rev, `"`)
+}
+
+func setETag(w http.ResponseWriter, rev string) {
+	if rev != "" {
+		w.Header().Set("ETag", `"`+rev+`"`)
+	}
+}
+
+// List Alerts with the live state of their schedules.
+//
+// Query parameters:
 //
+//	router_id  only alerts with a schedule routed to it
+//	label      key or key=value, may be repeated
+//	enabled    true or false: alerts with or without a schedule enabled now
+//	sort       alert (default) or next_transition, "-" prefix to reverse
+//	limit      page size
+//	offset     alerts to skip
+//
+// The total before paging is in X-Total-Count and the next page in Link.
+// API Endpoint: GET /v1/alerts
 func (aa *AlertApi) ListAlerts(w http.ResponseWriter, r *http.Request) {
-	ac := make([]config.AlertConfig, 0)
-
-	alerts := aa.routeMgr.GetAlerts()
-	for k, v := range alerts {
-		rp := make([]config.RouterParms, 0)
-		for _, sa := range v {
-			rp = append(rp, sa.Config)
+	query := r.URL.Query()
+	filter := &routemgr.AlertFilter{RouterId: query.Get("router_id")}
+	for _, label := range query["label"] {
+		if filter.Labels == nil {
+			filter.Labels = make(map[string]string)
 		}
-		ac = append(ac, config.AlertConfig{AlertId: k, Schedule: rp})
+		kv := strings.SplitN(label, "=", 2)
+		filter.Labels[kv[0]] = ""
+		if len(kv) == 2 {
+			filter.Labels[kv[0]] = kv[1]
+		}
+	}
+	if query.Get("enabled") != "" {
+		enabled, err := boolParam(query, "enabled")
+		if err != nil {
+			badRequest(w, err)
+			return
+		}
+		filter.Enabled = &enabled
+	}
+	offset, limit, err := pageParams(query)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	ac := aa.routeMgr.ListAlertStatus(filter)
+	if !sortAlerts(ac, query.Get("sort")) {
+		badRequest(w, &fieldError{"sort", errors.Errorf("unknown sort key %s", query.Get("sort"))})
+		return
+	}
+	w.Header().Set("X-Total-Count", strconv.Itoa(len(ac)))
+	if offset > len(ac) {
+		offset = len(ac)
 	}
+	ac = ac[offset:]
+	if limit > 0 && limit < len(ac) {
+		ac = ac[:limit]
+		next := *r.URL
+		query.Set("offset", strconv.Itoa(offset+limit))
+		next.RawQuery = query.Encode()
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next.RequestURI()))
+	}
+
 	body, err := json.Marshal(ac)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		internalError(w, err)
 	} else {
+		w.Header().Set("Content-Type", "application/json")
 		_, err = w.Write(body)
 	}
 }
 
+// A true or false query parameter, false if it is missing
+func boolParam(query url.Values, name string) (bool, error) {
+	v := query.Get(name)
+	if v == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, &fieldError{name, errors.Errorf("not true or false: %s", v)}
+	}
+	return b, nil
+}
+
+// The offset and limit query parameters
+func pageParams(query url.Values) (int, int, error) {
+	var offset, limit int
+	var err error
+	if v := query.Get("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			return 0, 0, &fieldError{"offset", errors.Errorf("not a count: %s", v)}
+		}
+	}
+	if v := query.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 0 {
+			return 0, 0, &fieldError{"limit", errors.Errorf("not a count: %s", v)}
+		}
+	}
+	return offset, limit, nil
+}
+
+// Sort alerts, which are ordered by id, by key.  Alerts without a next
+// transition sort last.  Reports whether the key is known.
+func sortAlerts(ac []*wire.AlertStatus, key string) bool {
+	desc := strings.HasPrefix(key, "-")
+	key = strings.TrimPrefix(key, "-")
+	switch key {
+	case "", "alert":
+	case "next_transition":
+		next := func(as *wire.AlertStatus) time.Time {
+			var t time.Time
+			for _, s := range as.Status {
+				if s.NextTransition != nil && (t.IsZero() || s.NextTransition.Before(t)) {
+					t = *s.NextTransition
+				}
+			}
+			return t
+		}
+		sort.SliceStable(ac, func(i, j int) bool {
+			ti, tj := next(ac[i]), next(ac[j])
+			if ti.IsZero() || tj.IsZero() {
+				return !ti.IsZero() && tj.IsZero()
+			}
+			return ti.Before(tj)
+		})
+	default:
+		return false
+	}
+	if desc {
+		for i, j := 0, len(ac)-1; i < j; i, j = i+1, j-1 {
+			ac[i], ac[j] = ac[j], ac[i]
+		}
+	}
+	return true
+}
+
+// Reload the main config and the alerts directory.  A failed reload keeps
+// the current configuration and returns 500 with the reload status.
+// API Endpoint: POST /v1/-/reload
+func (aa *AlertApi) Reload(w http.ResponseWriter, r *http.Request) {
+	log.Info("reload requested")
+	status, err := aa.routeMgr.Reload()
+	if err == routemgr.ErrClosed {
+		writeError(w, http.StatusServiceUnavailable, CODE_UNAVAILABLE, err.Error(), "")
+		return
+	}
+	if err != nil {
+		writeErrorResponse(w, http.StatusInternalServerError, &ErrorResponse{
+			Error:   ErrorDetail{Code: CODE_RELOAD_FAILED, Message: err.Error()},
+			Reloads: &status,
+		})
+		return
+	}
+	body, _ := json.Marshal(status)
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(body)
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// List the scheduler's cron jobs and their next run times
+// API Endpoint: GET /v1/-/scheduler
+func (aa *AlertApi) ListJobs(w http.ResponseWriter, r *http.Request) {
+	body, err := json.Marshal(aa.routeMgr.GetJobs())
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(body)
+	if err != nil {
+		log.Error(err)
+	}
+}
+
 // API Endpoint: /ekg
-//
 func (aa *AlertApi) Ekg(w http.ResponseWriter, r *http.Request) {
 	_, err := fmt.Fprintf(w, "OK")
 	if err != nil {
 		log.Error(err)
 	}
 }
+
+// Prometheus metrics
+// API Endpoint: GET /metrics
+func (aa *AlertApi) Metrics(w http.ResponseWriter, r *http.Request) {
+	aa.routeMgr.UpdateMetrics()
+	aa.metrics.ServeHTTP(w, r)
+}
+
+// Liveness: the server answers and the route manager isn't stuck
+// API Endpoint: GET /healthz
+func (aa *AlertApi) Healthz(w http.ResponseWriter, r *http.Request) {
+	aa.routeMgr.Config()
+	_, err := fmt.Fprintf(w, "OK")
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// Readiness: 200 if alerts can be routed, otherwise 503 with an error
+// naming the failed checks.  With ?detail=true the checks and the health of each router
+// are returned as JSON, which needs config:read.
+// API Endpoint: GET /readyz
+func (aa *AlertApi) Readyz(w http.ResponseWriter, r *http.Request) {
+	if detail, _ := strconv.ParseBool(r.URL.Query().Get("detail")); detail {
+		aa.allow(auth.SCOPE_CONFIG_READ, aa.readyDetail)(w, r)
+		return
+	}
+	ready := aa.routeMgr.Ready()
+	if !ready.Ready {
+		failed := make([]string, 0)
+		for _, check := range ready.Checks {
+			if !check.Ok {
+				failed = append(failed, check.Name)
+			}
+		}
+		writeError(w, http.StatusServiceUnavailable, CODE_UNAVAILABLE, "failed checks: "+strings.Join(failed, ", "), "")
+		return
+	}
+	_, err := fmt.Fprintf(w, "OK")
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+func (aa *AlertApi) readyDetail(w http.ResponseWriter, r *http.Request) {
+	ready := aa.routeMgr.Ready()
+	body, err := json.Marshal(ready)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !ready.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_, err = w.Write(body)
+	if err != nil {
+		log.Error(err)
+	}
+}
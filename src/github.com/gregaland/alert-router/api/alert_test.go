@@ -0,0 +1,1216 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/gregaland/alert-router/auth"
+	"github.com/gregaland/alert-router/config"
+	"github.com/gregaland/alert-router/routemgr"
+	"github.com/gregaland/alert-router/routers"
+	"github.com/gregaland/alert-router/store"
+	"github.com/gregaland/alert-router/wire"
+	log "github.com/sirupsen/logrus"
+	"gotest.tools/assert"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var rigData = `
+listen: :8000
+routers:
+ - id: gmail
+   type: email
+   enabled: true
+   smtphost: localhost
+   smtpport: 25
+   smtpauthuser: svc-alerts
+   smtpauthpass: hunter2
+ - id: slack-alerts
+   type: webhook
+   enabled: true
+   url: https://hooks.slack.com/services/T000/B000/XXXX
+`
+
+// Returns an api backed by a temporary alerts directory
+func newTestApi(t *testing.T) (*AlertApi, string) {
+	dir, err := ioutil.TempDir("", "alerts")
+	assert.NilError(t, err)
+	rigConfig, err := config.NewRigConfig(strings.NewReader(rigData))
+	assert.NilError(t, err)
+	rigConfig.AlertsPath = dir
+	rigConfig.Store = &store.Config{Path: filepath.Join(dir, ".state")}
+	return NewAlertApi(rigConfig, routemgr.NewRouteMgr(rigConfig)), dir
+}
+
+func doRequest(aa *AlertApi, method, url, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	aa.router.ServeHTTP(w, httptest.NewRequest(method, url, strings.NewReader(body)))
+	return w
+}
+
+func TestAlertApi_RedactSecrets(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	log.SetLevel(log.DebugLevel)
+	defer log.SetOutput(os.Stderr)
+	defer log.SetLevel(log.InfoLevel)
+
+	aa, dir := newTestApi(t)
+	defer os.RemoveAll(dir)
+
+	w := doRequest(aa, "POST", "/v1/alerts/dbfail",
+		`{"alert": "dbfail", "schedule": [{"id": "all_day", "router_id": "slack-alerts", "password": "s3cret"}]}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = doRequest(aa, "GET", "/v1/alerts", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	assert.Assert(t, strings.Contains(body, `"password":"`+config.REDACTED+`"`), body)
+
+	for _, out := range []string{body, buf.String()} {
+		for _, secret := range []string{"hunter2", "svc-alerts", "XXXX", "s3cret"} {
+			assert.Assert(t, !strings.Contains(out, secret), "%s found in: %s", secret, out)
+		}
+	}
+
+	// the alert file keeps the real value
+	data, err := ioutil.ReadFile(filepath.Join(dir, "dbfail.yml"))
+	assert.NilError(t, err)
+	assert.Assert(t, strings.Contains(string(data), "s3cret"))
+}
+
+func TestAlertApi_KeepSecrets(t *testing.T) {
+	aa, dir := newTestApi(t)
+	defer os.RemoveAll(dir)
+	defer aa.routeMgr.Close()
+
+	w := doRequest(aa, "POST", "/v1/alerts/dbfail",
+		`{"alert": "dbfail", "schedule": [{"id": "all_day", "router_id": "slack-alerts", "password": "s3cret", "query_parms": ["token=abc"]}]}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// an alert read and written back keeps its secrets
+	w = doRequest(aa, "GET", "/v1/alerts/dbfail", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var as wire.AlertStatus
+	assert.NilError(t, json.Unmarshal(w.Body.Bytes(), &as))
+	ac := as.Config
+	assert.Equal(t, config.Secret(config.REDACTED), ac.Schedule[0].Password)
+	ac.Schedule[0].RouterId = "gmail"
+	body, err := json.Marshal(ac)
+	assert.NilError(t, err)
+	req := httptest.NewRequest("PUT", "/v1/alerts/dbfail", bytes.NewReader(body))
+	req.Header.Set("If-Match", w.Header().Get("ETag"))
+	w = httptest.NewRecorder()
+	aa.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	current, ok := aa.routeMgr.GetAlert("dbfail")
+	assert.Assert(t, ok)
+	assert.Equal(t, "gmail", current.Schedule[0].RouterId)
+	assert.Equal(t, "s3cret", current.Schedule[0].Password.Reveal())
+	assert.DeepEqual(t, []config.Secret{"token=abc"}, current.Schedule[0].QueryParms)
+	data, err := ioutil.ReadFile(filepath.Join(dir, "dbfail.yml"))
+	assert.NilError(t, err)
+	assert.Assert(t, strings.Contains(string(data), "s3cret"), string(data))
+
+	// a redacted secret with no value to keep is refused
+	w = doRequest(aa, "PUT", "/v1/alerts/dbfail",
+		`{"alert": "dbfail", "schedule": [{"id": "night", "router_id": "gmail", "password": "******"}]}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, CODE_INVALID_ALERT, apiError(t, w).Code)
+}
+
+func TestAlertApi_ContentTypes(t *testing.T) {
+	aa, dir := newTestApi(t)
+	defer os.RemoveAll(dir)
+
+	req := httptest.NewRequest("POST", "/v1/alerts/dbfail",
+		strings.NewReader("alert: dbfail\nschedule:\n  - id: all_day\n    router_id: slack-alerts\n"))
+	req.Header.Set("Content-Type", "application/yaml")
+	w := httptest.NewRecorder()
+	aa.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 1, len(aa.routeMgr.GetAlerts()["dbfail"]))
+
+	// json is the default
+	w = doRequest(aa, "POST", "/v1/alerts/dbslow", `{"alert": "dbslow", "schedule": [{"id": "all_day", "router_id": "gmail"}]}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = doRequest(aa, "POST", "/v1/alerts/cpu", "alert: cpu\n")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// alerts sharing a file can't be deleted through the api
+	assert.NilError(t, ioutil.WriteFile(filepath.Join(dir, "team.yaml"),
+		[]byte("alert: disk\nschedule: []\n---\nalert: web\nschedule: []\n"), 0644))
+	_, err := aa.routeMgr.Reload()
+	assert.NilError(t, err)
+	w = doRequest(aa, "DELETE", "/v1/alerts/web", "")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, 4, len(aa.routeMgr.GetAlerts()))
+
+	w = doRequest(aa, "DELETE", "/v1/alerts/dbfail", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	_, err = os.Stat(filepath.Join(dir, "dbfail.yml"))
+	assert.Assert(t, os.IsNotExist(err))
+}
+
+func TestAlertApi_SchedulerJobs(t *testing.T) {
+	aa, dir := newTestApi(t)
+	defer os.RemoveAll(dir)
+
+	body := `{"alert": "dbfail", "schedule": [{"id": "after_hours", "router_id": "gmail", "start": "0 17 * * 1-5", "end": "0 8 * * 1-5"}]}`
+	w := doRequest(aa, "POST", "/v1/alerts/dbfail", body)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// updates replace the jobs rather than adding to them
+	for i := 0; i < 3; i++ {
+		w = doRequest(aa, "PUT", "/v1/alerts/dbfail", body)
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+	var jobs []routemgr.Job
+	w = doRequest(aa, "GET", "/v1/-/scheduler", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NilError(t, json.Unmarshal(w.Body.Bytes(), &jobs))
+	assert.Equal(t, 2, len(jobs))
+	for _, job := range jobs {
+		assert.Equal(t, "dbfail", job.AlertId)
+		assert.Equal(t, "after_hours", job.ScheduleId)
+		assert.Assert(t, job.Next.After(time.Now()))
+	}
+
+	w = doRequest(aa, "DELETE", "/v1/alerts/dbfail", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = doRequest(aa, "GET", "/v1/-/scheduler", "")
+	assert.Equal(t, "[]", w.Body.String())
+}
+
+func TestAlertApi_UpdateRevisions(t *testing.T) {
+	aa, dir := newTestApi(t)
+	defer os.RemoveAll(dir)
+
+	withHeader := func(method, url, body, key, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set(key, value)
+		w := httptest.NewRecorder()
+		aa.router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := doRequest(aa, "POST", "/v1/alerts/dbfail",
+		`{"alert": "dbfail", "schedule": [{"id": "all_day", "router_id": "slack-alerts", "password": "s3cret"}]}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = doRequest(aa, "POST", "/v1/alerts/dbfail", `{"alert": "dbfail", "schedule": []}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	w = doRequest(aa, "GET", "/v1/alerts/dbfail", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	etag := w.Header().Get("ETag")
+	assert.Assert(t, etag != "")
+	w = doRequest(aa, "GET", "/v1/alerts/dbslow", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// a bad update leaves the alert alone
+	w = doRequest(aa, "PUT", "/v1/alerts/dbfail", `{"alert": "dbfail", "schedule": [{"id": "all_day", "router_id": "pager"}]}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = doRequest(aa, "GET", "/v1/alerts/dbfail", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, etag, w.Header().Get("ETag"))
+
+	update := `{"alert": "dbfail", "schedule": [{"id": "all_day", "router_id": "gmail", "password": "s3cret"}]}`
+	w = withHeader("PUT", "/v1/alerts/dbfail", update, "If-Match", `"0123456789abcdef"`)
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+	w = withHeader("PUT", "/v1/alerts/dbfail", update, "If-Match", etag)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Assert(t, w.Header().Get("ETag") != etag)
+	assert.Equal(t, "gmail", aa.routeMgr.GetAlerts()["dbfail"][0].Config.RouterId)
+
+	// the old revision is stale now
+	w = withHeader("DELETE", "/v1/alerts/dbfail", "", "If-Match", etag)
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+
+	// merge patch of one schedule keeps the fields it doesn't name
+	w = withHeader("PATCH", "/v1/alerts/dbfail/schedule/all_day", `{"router_id": "slack-alerts"}`,
+		"Content-Type", "application/merge-patch+json")
+	assert.Equal(t, http.StatusOK, w.Code)
+	etag = w.Header().Get("ETag")
+	ac, ok := aa.routeMgr.GetAlert("dbfail")
+	assert.Assert(t, ok)
+	assert.Equal(t, "slack-alerts", ac.Schedule[0].RouterId)
+	assert.Equal(t, "s3cret", ac.Schedule[0].Password.Reveal())
+	assert.Equal(t, etag, `"`+ac.Revision+`"`)
+
+	w = withHeader("PATCH", "/v1/alerts/dbfail/schedule/all_day", `{"id": "night"}`,
+		"Content-Type", "application/merge-patch+json")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = withHeader("PATCH", "/v1/alerts/dbfail/schedule/night", `{"router_id": "gmail"}`,
+		"Content-Type", "application/merge-patch+json")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = withHeader("PATCH", "/v1/alerts/dbfail/schedule/all_day", `router_id: gmail`,
+		"Content-Type", "application/yaml")
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+
+	w = withHeader("DELETE", "/v1/alerts/dbfail", "", "If-Match", etag)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+// Changes from pairs of alert id and action
+func changes(kv ...string) []wire.Change {
+	result := make([]wire.Change, 0, len(kv)/2)
+	for i := 0; i+1 < len(kv); i += 2 {
+		result = append(result, wire.Change{AlertId: kv[i], Action: kv[i+1]})
+	}
+	return result
+}
+
+func TestAlertApi_Apply(t *testing.T) {
+	aa, dir := newTestApi(t)
+	defer os.RemoveAll(dir)
+
+	apply := func(query, body string) (int, *wire.ApplyResult) {
+		w := doRequest(aa, "POST", "/v1/alerts:apply"+query, body)
+		result := &wire.ApplyResult{}
+		if w.Code == http.StatusOK {
+			assert.NilError(t, json.Unmarshal(w.Body.Bytes(), result))
+		}
+		return w.Code, result
+	}
+	dbfail := "alert: dbfail\nschedule:\n  - id: all_day\n    router_id: slack-alerts\n"
+	web := "alert: web\nschedule: []\n"
+
+	code, result := apply("?dry_run=true", dbfail+"---\n"+web)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Assert(t, result.DryRun)
+	assert.DeepEqual(t, changes("dbfail", wire.ACTION_CREATE, "web", wire.ACTION_CREATE), result.Changes)
+	assert.Equal(t, 0, len(aa.routeMgr.GetAlerts()))
+
+	code, result = apply("", dbfail+"---\n"+web)
+	assert.Equal(t, http.StatusOK, code)
+	assert.DeepEqual(t, changes("dbfail", wire.ACTION_CREATE, "web", wire.ACTION_CREATE), result.Changes)
+	assert.Equal(t, 2, len(aa.routeMgr.GetAlerts()))
+
+	// JSON arrays work too, and alerts missing from the set stay unless pruned
+	code, result = apply("", `[{"alert": "dbfail", "schedule": [{"id": "all_day", "router_id": "gmail"}]}]`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.DeepEqual(t, changes("dbfail", wire.ACTION_UPDATE), result.Changes)
+	assert.Equal(t, "gmail", aa.routeMgr.GetAlerts()["dbfail"][0].Config.RouterId)
+
+	code, result = apply("?prune=true", web)
+	assert.Equal(t, http.StatusOK, code)
+	assert.DeepEqual(t, changes("web", wire.ACTION_UNCHANGED, "dbfail", wire.ACTION_DELETE), result.Changes)
+	_, ok := aa.routeMgr.GetAlert("dbfail")
+	assert.Assert(t, !ok)
+
+	// a bad alert, duplicate id or parameter changes nothing
+	code, _ = apply("", dbfail+"---\nalert: web\nschedule:\n  - id: all_day\n    router_id: pager\n")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = apply("", dbfail+"---\n"+dbfail)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = apply("?prune=maybe", dbfail)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = apply("", "alert: [")
+	assert.Equal(t, http.StatusBadRequest, code)
+	_, ok = aa.routeMgr.GetAlert("dbfail")
+	assert.Assert(t, !ok)
+
+	// alerts that share a file can't be changed through the api
+	assert.NilError(t, ioutil.WriteFile(filepath.Join(dir, "team.yml"),
+		[]byte("alert: disk\nschedule: []\n---\nalert: cpu\nschedule: []\n"), 0644))
+	w := doRequest(aa, "POST", "/v1/-/reload", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	code, _ = apply("?prune=true", web)
+	assert.Equal(t, http.StatusConflict, code)
+	code, result = apply("", "alert: disk\nschedule: []\n")
+	assert.Equal(t, http.StatusOK, code)
+	assert.DeepEqual(t, changes("disk", wire.ACTION_UNCHANGED), result.Changes)
+}
+
+func TestAlertApi_ListAlerts(t *testing.T) {
+	aa, dir := newTestApi(t)
+	defer os.RemoveAll(dir)
+
+	for id, body := range map[string]string{
+		"dbfail": `{"alert": "dbfail", "labels": {"team": "db"}, "schedule": [{"id": "all_day", "router_id": "gmail"}]}`,
+		"dbslow": `{"alert": "dbslow", "labels": {"team": "db", "tier": "2"}, "schedule": [{"id": "all_day", "router_id": "slack-alerts"}]}`,
+		"cpu":    `{"alert": "cpu", "schedule": [{"id": "after_hours", "router_id": "gmail", "start": "0 17 * * 1-5", "end": "0 8 * * 1-5"}]}`,
+	} {
+		w := doRequest(aa, "POST", "/v1/alerts/"+id, body)
+		assert.Equal(t, http.StatusOK, w.Code, id)
+	}
+
+	list := func(query string) ([]string, *httptest.ResponseRecorder) {
+		w := doRequest(aa, "GET", "/v1/alerts"+query, "")
+		assert.Equal(t, http.StatusOK, w.Code, query)
+		var alerts []wire.AlertStatus
+		assert.NilError(t, json.Unmarshal(w.Body.Bytes(), &alerts))
+		ids := make([]string, 0)
+		for _, as := range alerts {
+			ids = append(ids, as.AlertId)
+		}
+		return ids, w
+	}
+
+	ids, _ := list("")
+	assert.DeepEqual(t, []string{"cpu", "dbfail", "dbslow"}, ids)
+	ids, _ = list("?sort=-alert")
+	assert.DeepEqual(t, []string{"dbslow", "dbfail", "cpu"}, ids)
+	ids, _ = list("?sort=next_transition")
+	assert.DeepEqual(t, []string{"cpu", "dbfail", "dbslow"}, ids)
+	ids, _ = list("?router_id=gmail")
+	assert.DeepEqual(t, []string{"cpu", "dbfail"}, ids)
+	ids, _ = list("?label=team=db&label=tier")
+	assert.DeepEqual(t, []string{"dbslow"}, ids)
+	ids, _ = list("?enabled=false")
+	assert.DeepEqual(t, []string{"cpu"}, ids)
+
+	ids, w := list("?enabled=true&limit=1")
+	assert.DeepEqual(t, []string{"dbfail"}, ids)
+	assert.Equal(t, "2", w.Header().Get("X-Total-Count"))
+	assert.Equal(t, `</v1/alerts?enabled=true&limit=1&offset=1>; rel="next"`, w.Header().Get("Link"))
+	ids, w = list("?enabled=true&limit=1&offset=1")
+	assert.DeepEqual(t, []string{"dbslow"}, ids)
+	assert.Equal(t, "", w.Header().Get("Link"))
+
+	for _, query := range []string{"?enabled=maybe", "?limit=-1", "?sort=router"} {
+		w = doRequest(aa, "GET", "/v1/alerts"+query, "")
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+
+	// the live state of each schedule
+	w = doRequest(aa, "GET", "/v1/alerts/cpu", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var as wire.AlertStatus
+	assert.NilError(t, json.Unmarshal(w.Body.Bytes(), &as))
+	assert.Equal(t, 1, len(as.Status))
+	assert.Equal(t, "after_hours", as.Status[0].Id)
+	assert.Equal(t, config.EMAIL_RP, as.Status[0].RouterType)
+	assert.Assert(t, !as.Status[0].EnabledNow)
+	assert.Assert(t, as.Status[0].NextTransition.After(time.Now()))
+	assert.Equal(t, 17, as.Status[0].NextTransition.Hour())
+	assert.Equal(t, "0 17 * * 1-5", as.Config.Schedule[0].ScheduleStart)
+
+	// the config part is sent back as is
+	var resp struct {
+		Config json.RawMessage `json:"config"`
+	}
+	assert.NilError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	req := httptest.NewRequest("PUT", "/v1/alerts/cpu", bytes.NewReader(resp.Config))
+	req.Header.Set("If-Match", w.Header().Get("ETag"))
+	w = httptest.NewRecorder()
+	aa.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+}
+
+func TestAlertApi_ApiKeys(t *testing.T) {
+	aa, dir := newTestApi(t)
+	defer os.RemoveAll(dir)
+	aa.routeMgr.Config().ApiKeys = []*auth.Key{
+		{Name: "monitor", Hash: auth.HashKey("monitor-key"), Scopes: []string{"fire:db*"}},
+		{Name: "ci", Hash: auth.HashKey("ci-key"), Scopes: []string{auth.SCOPE_CONFIG_WRITE}},
+	}
+
+	withKey := func(method, url, body, key string) int {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		w := httptest.NewRecorder()
+		aa.router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	body := `{"alert": "dbfail", "schedule": [{"id": "all_day", "router_id": "gmail"}]}`
+	assert.Equal(t, http.StatusUnauthorized, withKey("POST", "/v1/alerts/dbfail", body, ""))
+	assert.Equal(t, http.StatusUnauthorized, withKey("POST", "/v1/alerts/dbfail", body, "wrong-key"))
+	assert.Equal(t, http.StatusForbidden, withKey("POST", "/v1/alerts/dbfail", body, "monitor-key"))
+	assert.Equal(t, http.StatusOK, withKey("POST", "/v1/alerts/dbfail", body, "ci-key"))
+
+	// monitoring agents can only fire
+	assert.Equal(t, http.StatusOK, withKey("POST", "/v1/alerts/dbfail/fire", `{"msg": "db is down"}`, "monitor-key"))
+	assert.Equal(t, http.StatusForbidden, withKey("POST", "/v1/alerts/cpu/fire", `{"msg": "cpu"}`, "monitor-key"))
+	assert.Equal(t, http.StatusForbidden, withKey("GET", "/v1/alerts", "", "monitor-key"))
+	assert.Equal(t, http.StatusForbidden, withKey("DELETE", "/v1/alerts/dbfail", "", "monitor-key"))
+	assert.Equal(t, http.StatusOK, withKey("GET", "/v1/alerts", "", "ci-key"))
+
+	req := httptest.NewRequest("GET", "/v1/alerts/dbfail", nil)
+	req.Header.Set("X-Api-Key", "ci-key")
+	w := httptest.NewRecorder()
+	aa.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	assert.Equal(t, http.StatusOK, withKey("GET", "/v1/ekg", "", ""))
+}
+
+func TestAlertApi_SignedFire(t *testing.T) {
+	aa, dir := newTestApi(t)
+	defer os.RemoveAll(dir)
+	aa.routeMgr.Config().SigningKeys = []*config.SigningKey{
+		{Name: "grafana", Secret: "grafana-secret", Alerts: []string{"db*"}},
+	}
+	for _, id := range []string{"dbfail", "cpu"} {
+		w := doRequest(aa, "POST", "/v1/alerts/"+id, `{"alert": "`+id+`", "signing_secret": "`+id+`-secret", "schedule": []}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+
+	fire := func(id, secret string, ts time.Time) int {
+		body := `{"msg": "down"}`
+		req := httptest.NewRequest("POST", "/v1/alerts/"+id+"/fire", strings.NewReader(body))
+		if secret != "" {
+			stamp := strconv.FormatInt(ts.Unix(), 10)
+			req.Header.Set(auth.TIMESTAMP_HEADER, stamp)
+			req.Header.Set(auth.SIGNATURE_HEADER, auth.Sign([]byte(secret), stamp, []byte(body)))
+		}
+		w := httptest.NewRecorder()
+		aa.router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	now := time.Now()
+	assert.Equal(t, http.StatusOK, fire("dbfail", "grafana-secret", now))
+	assert.Equal(t, http.StatusOK, fire("dbfail", "dbfail-secret", now))
+	assert.Equal(t, http.StatusOK, fire("cpu", "cpu-secret", now))
+	// a producer key only signs its alerts
+	assert.Equal(t, http.StatusUnauthorized, fire("cpu", "grafana-secret", now))
+	assert.Equal(t, http.StatusUnauthorized, fire("cpu", "dbfail-secret", now))
+	assert.Equal(t, http.StatusUnauthorized, fire("dbfail", "grafana-secret", now.Add(-time.Hour)))
+	// replayed
+	assert.Equal(t, http.StatusUnauthorized, fire("dbfail", "grafana-secret", now))
+	// alerts with a secret must be signed
+	assert.Equal(t, http.StatusUnauthorized, fire("dbfail", "", now))
+
+	// the secret is never shown
+	w := doRequest(aa, "GET", "/v1/alerts/dbfail", "")
+	assert.Assert(t, !strings.Contains(w.Body.String(), "dbfail-secret"), w.Body.String())
+}
+
+// Writes a certificate for name signed by ca, or self signed if ca is nil,
+// and its key to dir and returns the certificate
+func writeCert(t *testing.T, dir, name string, serial int64, ca *tls.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NilError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	parent, signer := tmpl, interface{}(key)
+	if ca == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid = true, true
+	} else {
+		parent, signer = ca.Leaf, ca.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, signer)
+	assert.NilError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NilError(t, err)
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	assert.NilError(t, ioutil.WriteFile(filepath.Join(dir, name+".crt"), certPem, 0600))
+	assert.NilError(t, ioutil.WriteFile(filepath.Join(dir, name+".key"), keyPem, 0600))
+	cert, err := tls.X509KeyPair(certPem, keyPem)
+	assert.NilError(t, err)
+	cert.Leaf, err = x509.ParseCertificate(der)
+	assert.NilError(t, err)
+	return cert
+}
+
+func TestAlertApi_ClientCerts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+	ca := writeCert(t, dir, "ca", 1, nil)
+	writeCert(t, dir, "localhost", 2, &ca)
+	monitor := writeCert(t, dir, "monitor.example.com", 3, &ca)
+	dashboard := writeCert(t, dir, "dashboard", 4, &ca)
+
+	alertsPath := filepath.Join(dir, "alerts.d")
+	assert.NilError(t, os.Mkdir(alertsPath, 0755))
+	configFile := filepath.Join(dir, "alert-router.yml")
+	assert.NilError(t, ioutil.WriteFile(configFile, []byte(rigData+`
+alerts_path: `+alertsPath+`
+store:
+  path: `+filepath.Join(dir, "state")+`
+tls_cert_file: `+filepath.Join(dir, "localhost.crt")+`
+tls_key_file: `+filepath.Join(dir, "localhost.key")+`
+client_ca_file: `+filepath.Join(dir, "ca.crt")+`
+client_certs:
+  - subject: "*.example.com"
+    scopes: ["fire:*"]
+  - subject: dashboard
+    scopes: ["config:read"]
+`), 0644))
+	rigConfig, err := config.LoadRigConfig(configFile)
+	assert.NilError(t, err)
+	aa := NewAlertApi(rigConfig, routemgr.NewRouteMgr(rigConfig))
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	server := &http.Server{Handler: aa.router}
+	go server.Serve(tls.NewListener(l, aa.tlsConfig()))
+	defer server.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+	get := func(client *tls.Certificate) *http.Response {
+		tlsConfig := &tls.Config{RootCAs: pool, ServerName: "localhost"}
+		if client != nil {
+			tlsConfig.Certificates = []tls.Certificate{*client}
+		}
+		c := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig, DisableKeepAlives: true}}
+		resp, err := c.Get("https://" + l.Addr().String() + "/v1/alerts")
+		assert.NilError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	assert.Equal(t, http.StatusOK, get(&dashboard).StatusCode)
+	assert.Equal(t, http.StatusForbidden, get(&monitor).StatusCode)
+	resp := get(nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, int64(2), resp.TLS.PeerCertificates[0].SerialNumber.Int64())
+
+	// a renewed certificate is picked up on reload
+	writeCert(t, dir, "localhost", 5, &ca)
+	_, err = aa.routeMgr.Reload()
+	assert.NilError(t, err)
+	assert.Equal(t, int64(5), get(nil).TLS.PeerCertificates[0].SerialNumber.Int64())
+
+	// and a broken one is not
+	assert.NilError(t, ioutil.WriteFile(filepath.Join(dir, "localhost.key"), []byte("garbage"), 0600))
+	_, err = aa.routeMgr.Reload()
+	assert.ErrorContains(t, err, "tls_cert_file")
+	assert.Equal(t, int64(5), get(nil).TLS.PeerCertificates[0].SerialNumber.Int64())
+}
+
+// The error in an error response
+func apiError(t *testing.T, w *httptest.ResponseRecorder) ErrorDetail {
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	var resp ErrorResponse
+	assert.NilError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Assert(t, resp.Error.Code != "" && resp.Error.Message != "", w.Body.String())
+	return resp.Error
+}
+
+func TestAlertApi_Errors(t *testing.T) {
+	aa, dir := newTestApi(t)
+	defer os.RemoveAll(dir)
+	defer aa.routeMgr.Close()
+
+	w := doRequest(aa, "POST", "/v1/alerts/dbfail", `{"alert": "dbfail", "schedule": []}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	for _, c := range []struct {
+		method, url, body string
+		status            int
+		code, field       string
+	}{
+		{"GET", "/v1/nothing", "", http.StatusNotFound, CODE_NOT_FOUND, ""},
+		{"PATCH", "/v1/alerts", "", http.StatusMethodNotAllowed, CODE_METHOD_NOT_ALLOWED, ""},
+		{"GET", "/v1/alerts/web", "", http.StatusNotFound, CODE_NOT_FOUND, ""},
+		{"POST", "/v1/alerts/dbfail", `{"alert": "dbfail", "schedule": []}`, http.StatusConflict, CODE_ALERT_EXISTS, ""},
+		{"POST", "/v1/alerts/web", `{"alert": "dbfail", "schedule": []}`, http.StatusBadRequest, CODE_INVALID_ALERT, "alert"},
+		{"POST", "/v1/alerts/Web", `{"schedule": []}`, http.StatusBadRequest, CODE_INVALID_ALERT, "id"},
+		{"POST", "/v1/alerts/..web", `{"schedule": []}`, http.StatusBadRequest, CODE_INVALID_ALERT, "id"},
+		{"POST", "/v1/alerts/web", `{"schedule": [{"id": "day", "router_id": "gmail"}, {"id": "day", "router_id": "gmail"}]}`, http.StatusBadRequest, CODE_INVALID_ALERT, ""},
+		{"POST", "/v1/routers/Pager", `{"type": "webhook", "url": "http://localhost/"}`, http.StatusBadRequest, CODE_INVALID_ROUTER, "router_id"},
+		{"PUT", "/v1/alerts/dbfail", `{"alert": "web", "schedule": []}`, http.StatusBadRequest, CODE_INVALID_ALERT, "alert"},
+		{"PUT", "/v1/alerts/dbfail", `{"alert": "dbfail", "schedule": {}}`, http.StatusBadRequest, CODE_INVALID_ALERT, "schedule"},
+		{"POST", "/v1/alerts/dbfail/fire", `{"msg": `, http.StatusBadRequest, CODE_BAD_REQUEST, ""},
+		{"POST", "/v1/alerts/dbfail/fire", `{"msg": 5}`, http.StatusBadRequest, CODE_BAD_REQUEST, "msg"},
+		{"POST", "/v1/alerts/dbfail/fire?wait=soon", "", http.StatusBadRequest, CODE_BAD_REQUEST, "wait"},
+		{"POST", "/v1/alerts/dbfail/simulate?at=christmas", "", http.StatusBadRequest, CODE_BAD_REQUEST, "at"},
+		{"POST", "/v1/routers/gmail/test", `{"email": "oncall@example.com"}`, http.StatusBadRequest, CODE_BAD_REQUEST, "email"},
+		{"POST", "/v1/routers/gmail/test", `{"email_addrs": ["oncall@example.com"], "smtphost": "relay"}`, http.StatusBadRequest, CODE_BAD_REQUEST, "smtphost"},
+		{"GET", "/v1/alerts?enabled=maybe", "", http.StatusBadRequest, CODE_BAD_REQUEST, "enabled"},
+		{"GET", "/v1/alerts?sort=color", "", http.StatusBadRequest, CODE_BAD_REQUEST, "sort"},
+		{"GET", "/v1/fires?limit=-1", "", http.StatusBadRequest, CODE_BAD_REQUEST, "limit"},
+		{"POST", "/v1/alerts:apply?dry_run=maybe", "", http.StatusBadRequest, CODE_BAD_REQUEST, "dry_run"},
+		{"PATCH", "/v1/alerts/dbfail/schedule/all_day", `{}`, http.StatusUnsupportedMediaType, CODE_UNSUPPORTED_MEDIA_TYPE, "Content-Type"},
+		{"GET", "/v1/routers/pager", "", http.StatusNotFound, CODE_NOT_FOUND, ""},
+		{"PUT", "/v1/routers/gmail", `{"type": "email"}`, http.StatusConflict, CODE_CONFIG_ROUTER, ""},
+	} {
+		w := doRequest(aa, c.method, c.url, c.body)
+		assert.Equal(t, c.status, w.Code, "%s %s", c.method, c.url)
+		e := apiError(t, w)
+		assert.Equal(t, c.code, e.Code, "%s %s", c.method, c.url)
+		assert.Equal(t, c.field, e.Field, "%s %s", c.method, c.url)
+	}
+
+	// a fire of an unknown alert is still recorded
+	w = doRequest(aa, "POST", "/v1/alerts/web/fire", `{"msg": "5xx"}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	var resp ErrorResponse
+	assert.NilError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Assert(t, resp.FireId != "")
+	w = doRequest(aa, "GET", "/v1/fires/"+resp.FireId, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+// Property names of a schema, with those of the schemas it is made of
+func schemaProperties(t *testing.T, schemas map[string]interface{}, name string) []string {
+	schema, ok := schemas[name].(map[string]interface{})
+	assert.Assert(t, ok, "no schema %s", name)
+	parts, _ := schema["allOf"].([]interface{})
+	props := make([]string, 0)
+	for _, part := range append(parts, schema) {
+		part := part.(map[string]interface{})
+		if ref, ok := part["$ref"].(string); ok {
+			props = append(props, schemaProperties(t, schemas, strings.TrimPrefix(ref, "#/components/schemas/"))...)
+		}
+		properties, _ := part["properties"].(map[string]interface{})
+		for p := range properties {
+			props = append(props, p)
+		}
+	}
+	sort.Strings(props)
+	return props
+}
+
+// Field names of a struct type under tag, with those of embedded structs
+func tagNames(typ reflect.Type, tag string) []string {
+	names := make([]string, 0)
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		name := strings.Split(f.Tag.Get(tag), ",")[0]
+		switch {
+		case f.Anonymous && name == "", strings.Contains(f.Tag.Get(tag), ",inline"):
+			names = append(names, tagNames(f.Type, tag)...)
+		case name != "" && name != "-":
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestAlertApi_OpenAPI(t *testing.T) {
+	aa, dir := newTestApi(t)
+	defer os.RemoveAll(dir)
+	defer aa.routeMgr.Close()
+
+	w := doRequest(aa, "GET", "/v1/openapi.json", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var spec struct {
+		Paths      map[string]map[string]interface{} `json:"paths"`
+		Components struct {
+			Schemas map[string]interface{} `json:"schemas"`
+		} `json:"components"`
+	}
+	assert.NilError(t, json.Unmarshal(w.Body.Bytes(), &spec))
+
+	// every route is described, and nothing else
+	documented := 0
+	for _, ops := range spec.Paths {
+		for op := range ops {
+			if op != "parameters" {
+				documented++
+			}
+		}
+	}
+	routed := 0
+	err := aa.router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		assert.NilError(t, err)
+		methods, err := route.GetMethods()
+		assert.NilError(t, err)
+		for _, m := range methods {
+			_, ok := spec.Paths[path][strings.ToLower(m)]
+			assert.Assert(t, ok, "%s %s is not in the OpenAPI document", m, path)
+			routed++
+		}
+		return nil
+	})
+	assert.NilError(t, err)
+	assert.Equal(t, routed, documented)
+
+	// schemas have the fields of the types the handlers read and write
+	for name, v := range map[string]interface{}{
+		"Error":             ErrorResponse{},
+		"Event":             Event{},
+		"FireResponse":      FireResponse{},
+		"RouterTestRequest": RouterTestRequest{},
+		"AlertConfig":       config.AlertConfig{},
+		"Schedule":          config.RouterParms{},
+		"AlertStatus":       wire.AlertStatus{},
+		"ScheduleStatus":    wire.ScheduleStatus{},
+		"ApplyResult":       wire.ApplyResult{},
+		"Fire":              wire.Fire{},
+		"FireSchedule":      wire.FireSchedule{},
+		"Simulation":        wire.Simulation{},
+		"SimulatedSchedule": wire.SimulatedSchedule{},
+		"Notification":      wire.Notification{},
+		"RouterInfo":        wire.RouterInfo{},
+		"RouterHealth":      wire.RouterHealth{},
+		"RouterTest":        wire.RouterTest{},
+		"ReloadStatus":      routemgr.ReloadStatus{},
+		"Readiness":         routemgr.Readiness{},
+		"Job":               routemgr.Job{},
+	} {
+		assert.DeepEqual(t, tagNames(reflect.TypeOf(v), "json"), schemaProperties(t, spec.Components.Schemas, name))
+	}
+	assert.DeepEqual(t, tagNames(reflect.TypeOf(config.Routers{}), "yaml"), schemaProperties(t, spec.Components.Schemas, "RouterEntry"))
+}
+
+func TestAlertApi_Metrics(t *testing.T) {
+	aa, dir := newTestApi(t)
+	defer os.RemoveAll(dir)
+	defer aa.routeMgr.Close()
+	aa.config.ApiKeys = []*auth.Key{{Name: "ops", Hash: auth.HashKey("k"), Scopes: []string{auth.SCOPE_CONFIG_WRITE}}}
+
+	add := httptest.NewRequest("POST", "/v1/alerts/nightly",
+		strings.NewReader(`{"alert": "nightly", "schedule": [{"id": "night", "start": "0 22 * * *", "end": "0 6 * * *", "router_id": "gmail"}]}`))
+	add.Header.Set("X-Api-Key", "k")
+	w := httptest.NewRecorder()
+	aa.router.ServeHTTP(w, add)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// the schedule starts disabled, so the fire is suppressed
+	_, _ = aa.routeMgr.Route(&routers.Event{Id: "nightly", Message: "backup failed"})
+	_, _ = aa.routeMgr.Route(&routers.Event{Id: "nosuchalert"})
+
+	// scraping needs no key
+	w = doRequest(aa, "GET", "/metrics", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	for _, line := range []string{
+		`alert_router_fires_total{alert="nightly"} 1`,
+		`alert_router_routing_decisions_total{decision="disabled_schedule"}`,
+		`alert_router_routing_decisions_total{decision="suppressed"}`,
+		`alert_router_routing_decisions_total{decision="unknown_alert"}`,
+		`alert_router_schedules_enabled{router_id="gmail"} 0`,
+		`alert_router_deliveries_in_progress 0`,
+		`alert_router_reloads_total{result="failure"}`,
+	} {
+		assert.Assert(t, strings.Contains(body, line), line)
+	}
+	assert.Assert(t, !strings.Contains(body, "nosuchalert"))
+}
+
+func TestAlertApi_Health(t *testing.T) {
+	aa, dir := newTestApi(t)
+	defer os.RemoveAll(dir)
+	defer aa.routeMgr.Close()
+	aa.config.ApiKeys = []*auth.Key{{Name: "ops", Hash: auth.HashKey("k"), Scopes: []string{auth.SCOPE_CONFIG_READ}}}
+
+	w := doRequest(aa, "GET", "/healthz", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = doRequest(aa, "GET", "/readyz", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "OK", w.Body.String())
+
+	// the detail needs a key
+	w = doRequest(aa, "GET", "/readyz?detail=true", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	r := httptest.NewRequest("GET", "/readyz?detail=true", nil)
+	r.Header.Set("X-Api-Key", "k")
+	w = httptest.NewRecorder()
+	aa.router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var ready routemgr.Readiness
+	assert.NilError(t, json.Unmarshal(w.Body.Bytes(), &ready))
+	assert.Assert(t, ready.Ready)
+	assert.Equal(t, 2, len(ready.Routers))
+
+	// not ready once the store can't be written
+	assert.NilError(t, os.RemoveAll(dir))
+	w = doRequest(aa, "GET", "/readyz", "")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	var resp ErrorResponse
+	assert.NilError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, CODE_UNAVAILABLE, resp.Error.Code)
+	assert.Equal(t, "failed checks: store", resp.Error.Message)
+}
+
+func TestAlertApi_Reload(t *testing.T) {
+	aa, dir := newTestApi(t)
+	defer os.RemoveAll(dir)
+
+	w := doRequest(aa, "POST", "/v1/-/reload", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// a failed reload is an error that carries the reload status
+	assert.NilError(t, ioutil.WriteFile(filepath.Join(dir, "bad.yml"),
+		[]byte("alert: bad\nschedule:\n  - id: all_day\n    router_id: pager\n"), 0644))
+	w = doRequest(aa, "POST", "/v1/-/reload", "")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	var resp ErrorResponse
+	assert.NilError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, CODE_RELOAD_FAILED, resp.Error.Code)
+	assert.Assert(t, strings.Contains(resp.Error.Message, "unknown router_id pager"), resp.Error.Message)
+	assert.Equal(t, 2, resp.Reloads.Reloads)
+	assert.Equal(t, 1, resp.Reloads.Failures)
+
+	// and none is done once shut down
+	assert.NilError(t, aa.routeMgr.Shutdown(context.Background()))
+	w = doRequest(aa, "POST", "/v1/-/reload", "")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.NilError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, CODE_UNAVAILABLE, resp.Error.Code)
+}
+
+func TestAlertApi_Fires(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alerts")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+	rigConfig, err := config.NewRigConfig(strings.NewReader(rigData))
+	assert.NilError(t, err)
+	rigConfig.AlertsPath = dir
+	rigConfig.Store = &store.Config{Type: store.DIR_STORE, Path: filepath.Join(dir, ".state")}
+	aa := NewAlertApi(rigConfig, routemgr.NewRouteMgr(rigConfig))
+	defer aa.routeMgr.Close()
+
+	w := doRequest(aa, "POST", "/v1/alerts/nightly",
+		`{"alert": "nightly", "schedule": [{"id": "night", "start": "0 22 * * *", "end": "0 6 * * *", "router_id": "gmail"}]}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	fire := func(alertId string, status int) string {
+		w := doRequest(aa, "POST", "/v1/alerts/"+alertId+"/fire", `{"msg": "backup failed"}`)
+		assert.Equal(t, status, w.Code)
+		var resp FireResponse
+		assert.NilError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Assert(t, resp.FireId != "")
+		return resp.FireId
+	}
+	nightly := fire("nightly", http.StatusOK)
+	unknown := fire("nosuchalert", http.StatusNotFound)
+
+	w = doRequest(aa, "GET", "/v1/fires/"+nightly, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var f wire.Fire
+	assert.NilError(t, json.Unmarshal(w.Body.Bytes(), &f))
+	assert.Equal(t, "nightly", f.AlertId)
+	assert.Equal(t, "backup failed", f.Message)
+	assert.Equal(t, 1, len(f.Schedules))
+	assert.Equal(t, wire.OUTCOME_SKIPPED, f.Schedules[0].Outcome)
+	assert.Equal(t, "schedule disabled", f.Schedules[0].Reason)
+
+	var fires []*wire.Fire
+	w = doRequest(aa, "GET", "/v1/fires?since=1h", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NilError(t, json.Unmarshal(w.Body.Bytes(), &fires))
+	assert.Equal(t, 2, len(fires))
+	assert.Equal(t, unknown, fires[0].Id)
+	assert.Equal(t, "unknown alert", fires[0].Error)
+
+	w = doRequest(aa, "GET", "/v1/fires?alert=nightly", "")
+	assert.NilError(t, json.Unmarshal(w.Body.Bytes(), &fires))
+	assert.Equal(t, 1, len(fires))
+	assert.Equal(t, nightly, fires[0].Id)
+
+	w = doRequest(aa, "GET", "/v1/fires?since="+time.Now().Add(time.Minute).Format(time.RFC3339), "")
+	assert.NilError(t, json.Unmarshal(w.Body.Bytes(), &fires))
+	assert.Equal(t, 0, len(fires))
+
+	w = doRequest(aa, "GET", "/v1/fires?since=yesterday", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = doRequest(aa, "GET", "/v1/fires/0000", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+// Returns an api whose slack router posts to url/ok, with webhook
+// routers fail and slow posting to url/fail and url/slow
+func newWebhookApi(t *testing.T, url string) (*AlertApi, string) {
+	dir, err := ioutil.TempDir("", "alerts")
+	assert.NilError(t, err)
+	data := strings.Replace(rigData, "https://hooks.slack.com/services/T000/B000/XXXX", url+"/ok", 1)
+	for _, id := range []string{"fail", "slow"} {
+		data += fmt.Sprintf(" - id: %s\n   type: webhook\n   enabled: true\n   url: %s/%s\n", id, url, id)
+	}
+	rigConfig, err := config.NewRigConfig(strings.NewReader(data))
+	assert.NilError(t, err)
+	rigConfig.AlertsPath = dir
+	rigConfig.Store = &store.Config{Path: filepath.Join(dir, ".state")}
+	return NewAlertApi(rigConfig, routemgr.NewRouteMgr(rigConfig)), dir
+}
+
+func TestAlertApi_FireWait(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/fail":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/slow":
+			<-release
+		}
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	aa, dir := newWebhookApi(t, ts.URL)
+	defer os.RemoveAll(dir)
+	defer aa.routeMgr.Close()
+
+	alerts := map[string][]string{
+		"ok":      {"slack-alerts"},
+		"partial": {"slack-alerts", "fail"},
+		"failed":  {"fail"},
+		"slow":    {"slow"},
+	}
+	w := doRequest(aa, "POST", "/v1/alerts/skipped",
+		`{"alert": "skipped", "schedule": [{"id": "new_year", "router_id": "slack-alerts", "start": "0 0 1 1 *", "end": "0 1 1 1 *"}]}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	for id, routerIds := range alerts {
+		schedules := make([]string, 0)
+		for _, routerId := range routerIds {
+			schedules = append(schedules, fmt.Sprintf(`{"id": "%s", "router_id": "%s"}`, routerId, routerId))
+		}
+		w := doRequest(aa, "POST", "/v1/alerts/"+id,
+			fmt.Sprintf(`{"alert": "%s", "schedule": [%s]}`, id, strings.Join(schedules, ",")))
+		assert.Equal(t, http.StatusOK, w.Code, id)
+	}
+
+	fire := func(url string, prefer string) (int, *wire.Fire) {
+		r := httptest.NewRequest("POST", url, strings.NewReader(`{"msg": "smoke test"}`))
+		if prefer != "" {
+			r.Header.Set("Prefer", prefer)
+		}
+		w := httptest.NewRecorder()
+		aa.router.ServeHTTP(w, r)
+		var f wire.Fire
+		if w.Code < 400 || w.Code >= 500 || w.Code == http.StatusUnprocessableEntity {
+			assert.NilError(t, json.Unmarshal(w.Body.Bytes(), &f))
+		}
+		return w.Code, &f
+	}
+
+	code, f := fire("/v1/alerts/ok/fire?wait=true", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, wire.OUTCOME_DELIVERED, f.Schedules[0].Outcome)
+	assert.Assert(t, f.Schedules[0].Finished != nil)
+
+	code, f = fire("/v1/alerts/partial/fire", "wait")
+	assert.Equal(t, http.StatusMultiStatus, code)
+	assert.Equal(t, wire.OUTCOME_FAILED, f.Schedules[1].Outcome)
+	assert.Equal(t, "status code: 500", f.Schedules[1].Error)
+
+	code, _ = fire("/v1/alerts/failed/fire?wait=true", "")
+	assert.Equal(t, http.StatusBadGateway, code)
+
+	// nobody was notified
+	code, f = fire("/v1/alerts/skipped/fire?wait=true", "")
+	assert.Equal(t, http.StatusUnprocessableEntity, code)
+	assert.Equal(t, wire.OUTCOME_SKIPPED, f.Schedules[0].Outcome)
+	assert.Equal(t, "schedule disabled", f.Schedules[0].Reason)
+
+	code, f = fire("/v1/alerts/slow/fire?wait=true&timeout=100ms", "")
+	assert.Equal(t, http.StatusGatewayTimeout, code)
+	assert.Equal(t, wire.OUTCOME_PENDING, f.Schedules[0].Outcome)
+
+	// without waiting the fire is accepted
+	code, f = fire("/v1/alerts/failed/fire", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Assert(t, f.Id != "")
+	assert.Equal(t, 0, len(f.Schedules))
+
+	code, _ = fire("/v1/alerts/nosuchalert/fire?wait=true", "")
+	assert.Equal(t, http.StatusNotFound, code)
+	w = doRequest(aa, "POST", "/v1/alerts/ok/fire?timeout=soon", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestAlertApi_Simulate(t *testing.T) {
+	aa, dir := newTestApi(t)
+	defer os.RemoveAll(dir)
+	defer aa.routeMgr.Close()
+
+	w := doRequest(aa, "POST", "/v1/alerts/dbfail",
+		`{"alert": "dbfail", "schedule": [{"id": "after_hours", "start": "0 17 * * *", "end": "0 6 * * *", "router_id": "gmail", "email_addrs": ["oncall@example.com"]}]}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	simulate := func(at time.Time) *wire.Simulation {
+		w := doRequest(aa, "POST", "/v1/alerts/dbfail/simulate?at="+url.QueryEscape(at.Format(time.RFC3339)), `{"msg": "db is down"}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var sim wire.Simulation
+		assert.NilError(t, json.Unmarshal(w.Body.Bytes(), &sim))
+		return &sim
+	}
+	sim := simulate(time.Date(2026, 12, 25, 3, 0, 0, 0, time.Local))
+	s := sim.Schedules[0]
+	assert.Assert(t, s.Enabled)
+	assert.Equal(t, config.EMAIL_RP, s.RouterType)
+	assert.DeepEqual(t, []string{"oncall@example.com"}, s.Notification.Recipients)
+	assert.Assert(t, strings.HasSuffix(s.Notification.Message, "dbfail\r\ndb is down"), s.Notification.Message)
+	assert.Equal(t, 6, s.NextTransition.Hour())
+
+	sim = simulate(time.Date(2026, 12, 25, 12, 0, 0, 0, time.Local))
+	assert.Assert(t, !sim.Schedules[0].Enabled)
+	assert.Assert(t, sim.Schedules[0].Notification == nil)
+
+	w = doRequest(aa, "POST", "/v1/alerts/dbfail/simulate?at=christmas", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = doRequest(aa, "POST", "/v1/alerts/nosuchalert/simulate", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestAlertApi_TestRouter(t *testing.T) {
+	posted, release := make(chan string, 1), make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+			body, _ := ioutil.ReadAll(r.Body)
+			posted <- string(body)
+		case "/fail":
+			w.WriteHeader(http.StatusNotFound)
+		case "/slow":
+			<-release
+		}
+	}))
+	defer ts.Close()
+	defer close(release)
+	aa, dir := newWebhookApi(t, ts.URL)
+	defer os.RemoveAll(dir)
+	defer aa.routeMgr.Close()
+
+	test := func(routerId, body string, status int) *wire.RouterTest {
+		w := doRequest(aa, "POST", "/v1/routers/"+routerId+"/test?timeout=200ms", body)
+		assert.Equal(t, status, w.Code, routerId)
+		var result wire.RouterTest
+		if status < 400 || status >= 500 {
+			assert.NilError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		}
+		return &result
+	}
+
+	result := test("slack-alerts", "", http.StatusOK)
+	assert.Equal(t, wire.OUTCOME_DELIVERED, result.Outcome)
+	assert.Equal(t, `{"text":"alert-router-test: Test message from alert-router"}`, result.Notification.Message)
+	assert.Equal(t, result.Notification.Message, <-posted)
+	test("slack-alerts", `{"msg": "checking the new webhook"}`, http.StatusOK)
+	assert.Equal(t, `{"text":"alert-router-test: checking the new webhook"}`, <-posted)
+
+	result = test("fail", "", http.StatusBadGateway)
+	assert.Equal(t, "status code: 404", result.Error)
+	result = test("slow", "", http.StatusGatewayTimeout)
+	assert.Equal(t, wire.OUTCOME_PENDING, result.Outcome)
+
+	// email needs recipients
+	test("gmail", `{"msg": "hi"}`, http.StatusBadRequest)
+	test("pager", "", http.StatusNotFound)
+	test("slack-alerts", "{", http.StatusBadRequest)
+}
+
+func TestAlertApi_Routers(t *testing.T) {
+	posted := make(chan string, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posted <- r.URL.Path
+	}))
+	defer ts.Close()
+	dir, err := ioutil.TempDir("", "alerts")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+	rigConfig, err := config.NewRigConfig(strings.NewReader(rigData))
+	assert.NilError(t, err)
+	rigConfig.AlertsPath = dir
+	rigConfig.Store = &store.Config{Type: store.DIR_STORE, Path: filepath.Join(dir, ".state")}
+	aa := NewAlertApi(rigConfig, routemgr.NewRouteMgr(rigConfig))
+	defer aa.routeMgr.Close()
+
+	list := func() []*wire.RouterInfo {
+		w := doRequest(aa, "GET", "/v1/routers", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Assert(t, !strings.Contains(w.Body.String(), "hunter2"), w.Body.String())
+		var routers []*wire.RouterInfo
+		assert.NilError(t, json.Unmarshal(w.Body.Bytes(), &routers))
+		return routers
+	}
+	routers := list()
+	assert.Equal(t, 2, len(routers))
+	assert.Equal(t, "gmail", routers[0].RouterId)
+	assert.Equal(t, routemgr.ORIGIN_CONFIG, routers[0].Origin)
+	assert.Assert(t, routers[0].Enabled)
+
+	w := doRequest(aa, "POST", "/v1/routers/ops", fmt.Sprintf(`{"type": "webhook", "url": "%s/ops"}`, ts.URL))
+	assert.Equal(t, http.StatusOK, w.Code)
+	rev := w.Header().Get("ETag")
+	assert.Assert(t, rev != "")
+	w = doRequest(aa, "POST", "/v1/routers/ops", fmt.Sprintf(`{"type": "webhook", "url": "%s/ops"}`, ts.URL))
+	assert.Equal(t, http.StatusConflict, w.Code)
+	w = doRequest(aa, "POST", "/v1/routers/pager", `{"type": "webhook"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = doRequest(aa, "POST", "/v1/routers/pager", `{"id": "ops", "type": "webhook", "url": "https://example.com"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = doRequest(aa, "GET", "/v1/routers/ops", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, rev, w.Header().Get("ETag"))
+	var info wire.RouterInfo
+	assert.NilError(t, json.Unmarshal(w.Body.Bytes(), &info))
+	assert.Equal(t, routemgr.ORIGIN_API, info.Origin)
+	assert.Equal(t, config.WEBHOOK_RP, info.Type)
+	assert.Assert(t, !strings.Contains(w.Body.String(), ts.URL), w.Body.String())
+
+	// the new router takes alerts, and is kept over a reload
+	w = doRequest(aa, "POST", "/v1/alerts/deploy", `{"alert": "deploy", "schedule": [{"id": "all_day", "router_id": "ops"}]}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	_, err = aa.routeMgr.Reload()
+	assert.NilError(t, err)
+	assert.Equal(t, 3, len(list()))
+	w = doRequest(aa, "POST", "/v1/alerts/deploy/fire", `{"msg": "v2 is out"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "/ops", <-posted)
+
+	// disabled routers are skipped, and a redacted url keeps its value
+	req := httptest.NewRequest("PUT", "/v1/routers/ops", strings.NewReader(
+		"type: webhook\nenabled: false\nurl: \""+config.REDACTED+"\"\n"))
+	req.Header.Set("Content-Type", "application/yaml")
+	req.Header.Set("If-Match", rev)
+	w = httptest.NewRecorder()
+	aa.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Assert(t, w.Header().Get("ETag") != rev)
+	w = doRequest(aa, "POST", "/v1/alerts/deploy/fire?wait=true", `{"msg": "v3 is out"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	var f wire.Fire
+	assert.NilError(t, json.Unmarshal(w.Body.Bytes(), &f))
+	assert.Equal(t, wire.OUTCOME_SKIPPED, f.Schedules[0].Outcome)
+	assert.Equal(t, "router disabled", f.Schedules[0].Reason)
+	for _, r := range aa.routeMgr.Config().Routers {
+		if r.Parms.Id == "ops" {
+			assert.Equal(t, ts.URL+"/ops", r.Parms.Url.Reveal())
+		}
+	}
+
+	req = httptest.NewRequest("DELETE", "/v1/routers/ops", nil)
+	req.Header.Set("If-Match", rev)
+	w = httptest.NewRecorder()
+	aa.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+	w = doRequest(aa, "DELETE", "/v1/routers/ops", "")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	w = doRequest(aa, "DELETE", "/v1/alerts/deploy", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = doRequest(aa, "DELETE", "/v1/routers/ops", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 2, len(list()))
+
+	// routers of the main config are changed by editing it
+	w = doRequest(aa, "PUT", "/v1/routers/gmail", `{"type": "email", "smtphost": "localhost", "smtpport": 25}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	w = doRequest(aa, "DELETE", "/v1/routers/gmail", "")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	w = doRequest(aa, "GET", "/v1/routers/ops", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"bytes"
+	"github.com/gorilla/mux"
+	"github.com/gregaland/alert-router/auth"
+	"github.com/gregaland/alert-router/config"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Largest fire request body that is read to check its signature
+const MAX_BODY_SIZE int64 = 1 << 20
+
+// API keys of the running config, rebuilt when a reload replaces it
+type apiKeys struct {
+	lock   sync.Mutex
+	config *config.RigConfig
+	keys   *auth.Keys
+}
+
+func (aa *AlertApi) apiKeys() *auth.Keys {
+	rigConfig := aa.routeMgr.Config()
+	aa.keys.lock.Lock()
+	defer aa.keys.lock.Unlock()
+	if aa.keys.config != rigConfig {
+		aa.keys.config = rigConfig
+		aa.keys.keys = auth.NewKeys(rigConfig.ApiKeys, rigConfig.ClientCerts)
+	}
+	return aa.keys.keys
+}
+
+// The API key of a request, from "Authorization: Bearer" or X-Api-Key
+func requestKey(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		return strings.TrimSpace(h[len("Bearer "):])
+	}
+	return r.Header.Get("X-Api-Key")
+}
+
+// Middleware that identifies the caller by API key or, without one, by
+// a verified client certificate.  An unknown key is refused here; whether
+// a request needs an identity at all is up to allow.
+func (aa *AlertApi) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := requestKey(r)
+		if key == "" {
+			if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+				if id := aa.apiKeys().AuthenticateCert(r.TLS.PeerCertificates[0]); id != nil {
+					r = r.WithContext(auth.NewContext(r.Context(), id))
+				}
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		id := aa.apiKeys().Authenticate(key)
+		if id == nil {
+			log.WithFields(log.Fields{"remote": r.RemoteAddr, "path": r.URL.Path}).Warn("unknown api key")
+			unauthorized(w)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(auth.NewContext(r.Context(), id)))
+	})
+}
+
+// Wraps h so the caller needs scope, in which {id} is replaced by the
+// alert id of the request.  With no API keys configured everyone is
+// allowed.
+func (aa *AlertApi) allow(scope string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if aa.apiKeys().Empty() {
+			h(w, r)
+			return
+		}
+		id := auth.FromContext(r.Context())
+		if id == nil {
+			unauthorized(w)
+			return
+		}
+		need := strings.Replace(scope, "{id}", mux.Vars(r)["id"], 1)
+		if !id.Allowed(need) {
+			log.WithFields(log.Fields{"key": id.Name, "scope": need, "path": r.URL.Path}).Warn("forbidden")
+			writeError(w, http.StatusForbidden, CODE_FORBIDDEN, "api key "+id.Name+" lacks scope "+need, "")
+			return
+		}
+		h(w, r)
+	}
+}
+
+func unauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="alert-router"`)
+	writeError(w, http.StatusUnauthorized, CODE_UNAUTHORIZED, "missing or invalid credentials", "")
+}
+
+// Wraps a fire handler to check signed requests.  A request with a
+// signature must be signed by a secret that applies to the alert and is
+// then allowed to fire it.  Alerts that a secret applies to can only be
+// fired unsigned with an API key.
+func (aa *AlertApi) signed(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		alertId := mux.Vars(r)["id"]
+		rigConfig := aa.routeMgr.Config()
+		ac, _ := aa.routeMgr.GetAlert(alertId)
+		signers := rigConfig.SigningSecrets(alertId, ac)
+
+		signature := r.Header.Get(auth.SIGNATURE_HEADER)
+		if signature == "" {
+			if len(signers) > 0 && auth.FromContext(r.Context()) == nil {
+				log.WithFields(log.Fields{"alert_id": alertId, "remote": r.RemoteAddr}).Warn("unsigned fire request")
+				unauthorized(w)
+				return
+			}
+			h(w, r)
+			return
+		}
+
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, MAX_BODY_SIZE))
+		if err != nil {
+			badRequest(w, err)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		keys := make([][]byte, len(signers))
+		for i, s := range signers {
+			keys[i] = []byte(s.Secret.Reveal())
+		}
+		aa.verifier.SetWindow(rigConfig.SignatureWindow())
+		i, err := aa.verifier.Verify(keys, r.Header.Get(auth.TIMESTAMP_HEADER), signature, body, time.Now())
+		if err != nil {
+			log.WithFields(log.Fields{"alert_id": alertId, "remote": r.RemoteAddr}).Warnf("signed fire request refused: %v", err)
+			unauthorized(w)
+			return
+		}
+		id := &auth.Identity{Name: signers[i].Name, Scopes: []string{auth.SCOPE_FIRE + alertId}}
+		h(w, r.WithContext(auth.NewContext(r.Context(), id)))
+	}
+}
@@ -0,0 +1,159 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/gregaland/alert-router/routemgr"
+	"github.com/gregaland/alert-router/store"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Codes of error responses
+const (
+	CODE_BAD_REQUEST            string = "bad_request"
+	CODE_INVALID_ALERT          string = "invalid_alert"
+	CODE_INVALID_ROUTER         string = "invalid_router"
+	CODE_UNAUTHORIZED           string = "unauthorized"
+	CODE_FORBIDDEN              string = "forbidden"
+	CODE_NOT_FOUND              string = "not_found"
+	CODE_METHOD_NOT_ALLOWED     string = "method_not_allowed"
+	CODE_ALERT_EXISTS           string = "alert_exists"
+	CODE_SHARED_FILE            string = "shared_file"
+	CODE_ROUTER_EXISTS          string = "router_exists"
+	CODE_CONFIG_ROUTER          string = "config_router"
+	CODE_ROUTER_IN_USE          string = "router_in_use"
+	CODE_PRECONDITION_FAILED    string = "precondition_failed"
+	CODE_UNSUPPORTED_MEDIA_TYPE string = "unsupported_media_type"
+	CODE_INTERNAL               string = "internal"
+	CODE_UNAVAILABLE            string = "unavailable"
+	CODE_RELOAD_FAILED          string = "reload_failed"
+)
+
+// ErrorResponse is the body of every error response.  A fire of an
+// unknown alert is still recorded, and FireId is its record.  A failed
+// reload carries the outcome of the reloads so far.
+type ErrorResponse struct {
+	Error   ErrorDetail            `json:"error"`
+	FireId  string                 `json:"fire_id,omitempty"`
+	Reloads *routemgr.ReloadStatus `json:"reloads,omitempty"`
+}
+
+// ErrorDetail says what went wrong.  Field names the query parameter,
+// header or body field at fault, if there is one.
+type ErrorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Field   string `json:"field,omitempty"`
+}
+
+func writeError(w http.ResponseWriter, status int, code, message, field string) {
+	writeErrorResponse(w, status, &ErrorResponse{Error: ErrorDetail{Code: code, Message: message, Field: field}})
+}
+
+func writeErrorResponse(w http.ResponseWriter, status int, resp *ErrorResponse) {
+	body, _ := json.Marshal(resp)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, err := w.Write(body)
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// A bad query parameter, header or body
+func badRequest(w http.ResponseWriter, err error) {
+	writeError(w, http.StatusBadRequest, CODE_BAD_REQUEST, err.Error(), errorField(err))
+}
+
+func notFound(w http.ResponseWriter, message string) {
+	writeError(w, http.StatusNotFound, CODE_NOT_FOUND, message, "")
+}
+
+// An error the caller can't fix.  The details are only logged.
+func internalError(w http.ResponseWriter, err error) {
+	log.Error(err)
+	writeError(w, http.StatusInternalServerError, CODE_INTERNAL, http.StatusText(http.StatusInternalServerError), "")
+}
+
+// The body field an error is about, if it knows
+func errorField(err error) string {
+	if fe, ok := errors.Cause(err).(interface{ Field() string }); ok {
+		return fe.Field()
+	}
+	return ""
+}
+
+// Error in a query parameter, header or body field
+type fieldError struct {
+	field string
+	err   error
+}
+
+func (e *fieldError) Error() string {
+	return e.field + ": " + e.err.Error()
+}
+
+func (e *fieldError) Field() string {
+	return e.field
+}
+
+// Decodes a JSON body, which may be empty, into v.  With strict set fields
+// v doesn't have are refused.  Errors name the field at fault when
+// encoding/json does.
+func decodeJSON(r *http.Request, v interface{}, strict bool) error {
+	dec := json.NewDecoder(r.Body)
+	if strict {
+		dec.DisallowUnknownFields()
+	}
+	err := dec.Decode(v)
+	switch e := err.(type) {
+	case nil:
+		return nil
+	case *json.UnmarshalTypeError:
+		if e.Field != "" {
+			return &fieldError{e.Field, errors.Errorf("must be %s, not %s", e.Type, e.Value)}
+		}
+	}
+	if err == io.EOF {
+		return nil
+	}
+	if strings.HasPrefix(err.Error(), "json: unknown field ") {
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		return &fieldError{field, errors.New("unknown field")}
+	}
+	return errors.Wrap(err, "invalid JSON body")
+}
+
+// Writes the status of a change to an alert or router
+func writeChange(w http.ResponseWriter, found bool, rev string, err error) {
+	if err != nil {
+		log.Error(err)
+	}
+	switch cause := errors.Cause(err); {
+	case routemgr.IsInvalidAlert(err):
+		writeError(w, http.StatusBadRequest, CODE_INVALID_ALERT, err.Error(), errorField(err))
+	case routemgr.IsInvalidRouter(err):
+		writeError(w, http.StatusBadRequest, CODE_INVALID_ROUTER, err.Error(), errorField(err))
+	case cause == routemgr.ErrAlertExists:
+		writeError(w, http.StatusConflict, CODE_ALERT_EXISTS, err.Error(), "")
+	case cause == routemgr.ErrSharedFile:
+		writeError(w, http.StatusConflict, CODE_SHARED_FILE, err.Error(), "")
+	case cause == routemgr.ErrRouterExists:
+		writeError(w, http.StatusConflict, CODE_ROUTER_EXISTS, err.Error(), "")
+	case cause == routemgr.ErrConfigRouter:
+		writeError(w, http.StatusConflict, CODE_CONFIG_ROUTER, err.Error(), "")
+	case cause == routemgr.ErrRouterInUse:
+		writeError(w, http.StatusConflict, CODE_ROUTER_IN_USE, err.Error(), "")
+	case cause == store.ErrConflict:
+		writeError(w, http.StatusPreconditionFailed, CODE_PRECONDITION_FAILED, err.Error(), "If-Match")
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, CODE_INTERNAL, http.StatusText(http.StatusInternalServerError), "")
+	case !found:
+		notFound(w, "not found")
+	default:
+		setETag(w, rev)
+	}
+}
@@ -0,0 +1,714 @@
+package api
+
+import (
+	"github.com/gregaland/alert-router/config"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+)
+
+// OpenAPI 3 description of every route of NewAlertApi.  The tests check
+// it against the routes and the types the handlers read and write.
+const openapiSpec = `
+openapi: 3.0.3
+info:
+  title: alert-router
+  description: Routes alerts to email and webhook routers according to each alert's schedules.
+  version: "1"
+security:
+  - bearer: []
+  - apiKey: []
+  - {}
+paths:
+  /v1/alerts:
+    get:
+      operationId: listAlerts
+      summary: List alerts with the live state of their schedules
+      description: Needs config:read.
+      parameters:
+        - {name: router_id, in: query, schema: {type: string}, description: Only alerts with a schedule routed to this router}
+        - {name: label, in: query, schema: {type: array, items: {type: string}}, description: "key or key=value, may be repeated"}
+        - {name: enabled, in: query, schema: {type: boolean}, description: Only alerts with, or without, a schedule enabled now}
+        - {name: sort, in: query, schema: {type: string, enum: [alert, -alert, next_transition, -next_transition]}}
+        - $ref: '#/components/parameters/offset'
+        - {name: limit, in: query, schema: {type: integer, minimum: 0}}
+      responses:
+        "200":
+          description: The alerts.  Link holds the next page.
+          headers:
+            X-Total-Count: {schema: {type: integer}, description: Alerts before paging}
+            Link: {schema: {type: string}}
+          content:
+            application/json:
+              schema: {type: array, items: {$ref: '#/components/schemas/AlertStatus'}}
+        "400": {$ref: '#/components/responses/Error'}
+        default: {$ref: '#/components/responses/Error'}
+  /v1/alerts:apply:
+    post:
+      operationId: applyAlerts
+      summary: Make the alerts match a set of alert configs
+      description: Needs config:write.  Every alert is validated before anything changes.
+      parameters:
+        - {name: dry_run, in: query, schema: {type: boolean, default: false}, description: Only return the changes}
+        - {name: prune, in: query, schema: {type: boolean, default: false}, description: Delete alerts missing from the set}
+      requestBody:
+        required: true
+        content:
+          application/yaml:
+            schema: {$ref: '#/components/schemas/AlertConfig'}
+          application/json:
+            schema: {type: array, items: {$ref: '#/components/schemas/AlertConfig'}}
+      responses:
+        "200":
+          description: The change to each alert
+          content:
+            application/json:
+              schema: {$ref: '#/components/schemas/ApplyResult'}
+        "400": {$ref: '#/components/responses/Error'}
+        "409": {$ref: '#/components/responses/Error'}
+        default: {$ref: '#/components/responses/Error'}
+  /v1/alerts/{id}:
+    parameters:
+      - $ref: '#/components/parameters/alertId'
+    get:
+      operationId: getAlert
+      summary: Get an alert with the live state of its schedules
+      description: Needs config:read.
+      responses:
+        "200":
+          description: The alert
+          headers:
+            ETag: {$ref: '#/components/headers/ETag'}
+          content:
+            application/json:
+              schema: {$ref: '#/components/schemas/AlertStatus'}
+        "404": {$ref: '#/components/responses/Error'}
+        default: {$ref: '#/components/responses/Error'}
+    post:
+      operationId: addAlert
+      summary: Add an alert
+      description: Needs config:write.  The body's alert id, if given, must be the one in the path.
+      requestBody: {$ref: '#/components/requestBodies/AlertConfig'}
+      responses:
+        "200": {$ref: '#/components/responses/Changed'}
+        "400": {$ref: '#/components/responses/Error'}
+        "409": {$ref: '#/components/responses/Error'}
+        default: {$ref: '#/components/responses/Error'}
+    put:
+      operationId: updateAlert
+      summary: Replace an alert
+      description: Needs config:write.  The body's alert id, if given, must be the one in the path.
+      parameters:
+        - $ref: '#/components/parameters/ifMatch'
+      requestBody: {$ref: '#/components/requestBodies/AlertConfig'}
+      responses:
+        "200": {$ref: '#/components/responses/Changed'}
+        "400": {$ref: '#/components/responses/Error'}
+        "404": {$ref: '#/components/responses/Error'}
+        "409": {$ref: '#/components/responses/Error'}
+        "412": {$ref: '#/components/responses/Error'}
+        default: {$ref: '#/components/responses/Error'}
+    delete:
+      operationId: deleteAlert
+      summary: Delete an alert
+      description: Needs config:write.
+      parameters:
+        - $ref: '#/components/parameters/ifMatch'
+      responses:
+        "200": {description: Deleted}
+        "404": {$ref: '#/components/responses/Error'}
+        "409": {$ref: '#/components/responses/Error'}
+        "412": {$ref: '#/components/responses/Error'}
+        default: {$ref: '#/components/responses/Error'}
+  /v1/alerts/{id}/fire:
+    parameters:
+      - $ref: '#/components/parameters/alertId'
+    post:
+      operationId: fireAlert
+      summary: Fire an alert
+      description: >
+        Needs fire:{id}, or a request signed with a signing secret that
+        applies to the alert.  With wait the response is sent once the
+        routers are done and holds the outcome of each schedule.
+      security:
+        - bearer: []
+        - apiKey: []
+        - signature: []
+          timestamp: []
+        - {}
+      parameters:
+        - {name: wait, in: query, schema: {type: boolean, default: false}}
+        - {name: timeout, in: query, schema: {type: string, default: 30s}, description: How long to wait, a Go duration up to 5m}
+        - {name: Prefer, in: header, schema: {type: string, example: wait=10}}
+      requestBody:
+        content:
+          application/json:
+            schema: {$ref: '#/components/schemas/Event'}
+      responses:
+        "200":
+          description: >
+            Without wait, the id of the fire.  With wait, the fire, and
+            every delivery succeeded.
+          content:
+            application/json:
+              schema:
+                oneOf:
+                  - {$ref: '#/components/schemas/FireResponse'}
+                  - {$ref: '#/components/schemas/Fire'}
+        "207": {$ref: '#/components/responses/WaitedFire'}
+        "400": {$ref: '#/components/responses/Error'}
+        "401": {$ref: '#/components/responses/Error'}
+        "404": {$ref: '#/components/responses/Error'}
+        "422":
+          description: With wait, every schedule was skipped, so nothing was delivered
+          content:
+            application/json:
+              schema: {$ref: '#/components/schemas/Fire'}
+        "502":
+          description: No delivery succeeded
+          content:
+            application/json:
+              schema:
+                oneOf:
+                  - {$ref: '#/components/schemas/FireResponse'}
+                  - {$ref: '#/components/schemas/Fire'}
+        "504": {$ref: '#/components/responses/WaitedFire'}
+        default: {$ref: '#/components/responses/Error'}
+  /v1/alerts/{id}/simulate:
+    parameters:
+      - $ref: '#/components/parameters/alertId'
+    post:
+      operationId: simulateAlert
+      summary: Show what firing an alert would do at an instant
+      description: Needs config:read.  Nothing is sent.
+      parameters:
+        - {name: at, in: query, schema: {type: string, format: date-time}, description: Default now}
+      requestBody:
+        content:
+          application/json:
+            schema: {$ref: '#/components/schemas/Event'}
+      responses:
+        "200":
+          description: The state of each schedule
+          content:
+            application/json:
+              schema: {$ref: '#/components/schemas/Simulation'}
+        "400": {$ref: '#/components/responses/Error'}
+        "404": {$ref: '#/components/responses/Error'}
+        default: {$ref: '#/components/responses/Error'}
+  /v1/alerts/{id}/schedule/{schedule_id}:
+    parameters:
+      - $ref: '#/components/parameters/alertId'
+      - {name: schedule_id, in: path, required: true, schema: {type: string, pattern: '^[a-z0-9_-][a-z0-9._-]*$', maxLength: 128}}
+    patch:
+      operationId: patchSchedule
+      summary: Change one schedule of an alert with a JSON merge patch
+      description: Needs config:write.
+      parameters:
+        - $ref: '#/components/parameters/ifMatch'
+      requestBody:
+        required: true
+        content:
+          application/merge-patch+json:
+            schema: {$ref: '#/components/schemas/Schedule'}
+      responses:
+        "200": {$ref: '#/components/responses/Changed'}
+        "400": {$ref: '#/components/responses/Error'}
+        "404": {$ref: '#/components/responses/Error'}
+        "409": {$ref: '#/components/responses/Error'}
+        "412": {$ref: '#/components/responses/Error'}
+        "415": {$ref: '#/components/responses/Error'}
+        default: {$ref: '#/components/responses/Error'}
+  /v1/routers:
+    get:
+      operationId: listRouters
+      summary: List the routers, those of the main config first
+      description: Needs config:read.
+      responses:
+        "200":
+          description: The routers
+          content:
+            application/json:
+              schema: {type: array, items: {$ref: '#/components/schemas/RouterInfo'}}
+        default: {$ref: '#/components/responses/Error'}
+  /v1/routers/{router_id}:
+    parameters:
+      - $ref: '#/components/parameters/routerId'
+    get:
+      operationId: getRouter
+      summary: Get a router
+      description: Needs config:read.
+      responses:
+        "200":
+          description: The router.  Routers added through the api have an ETag.
+          headers:
+            ETag: {$ref: '#/components/headers/ETag'}
+          content:
+            application/json:
+              schema: {$ref: '#/components/schemas/RouterInfo'}
+        "404": {$ref: '#/components/responses/Error'}
+        default: {$ref: '#/components/responses/Error'}
+    post:
+      operationId: addRouter
+      summary: Add a router
+      description: Needs config:write.
+      requestBody: {$ref: '#/components/requestBodies/RouterEntry'}
+      responses:
+        "200": {$ref: '#/components/responses/Changed'}
+        "400": {$ref: '#/components/responses/Error'}
+        "409": {$ref: '#/components/responses/Error'}
+        default: {$ref: '#/components/responses/Error'}
+    put:
+      operationId: updateRouter
+      summary: Replace a router added through the api
+      description: Needs config:write.
+      parameters:
+        - $ref: '#/components/parameters/ifMatch'
+      requestBody: {$ref: '#/components/requestBodies/RouterEntry'}
+      responses:
+        "200": {$ref: '#/components/responses/Changed'}
+        "400": {$ref: '#/components/responses/Error'}
+        "404": {$ref: '#/components/responses/Error'}
+        "409": {$ref: '#/components/responses/Error'}
+        "412": {$ref: '#/components/responses/Error'}
+        default: {$ref: '#/components/responses/Error'}
+    delete:
+      operationId: deleteRouter
+      summary: Delete a router added through the api that no alert is routed to
+      description: Needs config:write.
+      parameters:
+        - $ref: '#/components/parameters/ifMatch'
+      responses:
+        "200": {description: Deleted}
+        "404": {$ref: '#/components/responses/Error'}
+        "409": {$ref: '#/components/responses/Error'}
+        "412": {$ref: '#/components/responses/Error'}
+        default: {$ref: '#/components/responses/Error'}
+  /v1/routers/{router_id}/test:
+    parameters:
+      - $ref: '#/components/parameters/routerId'
+    post:
+      operationId: testRouter
+      summary: Send a test message through a router
+      description: Needs config:write.
+      parameters:
+        - {name: timeout, in: query, schema: {type: string, default: 30s}, description: How long to wait, a Go duration up to 5m}
+      requestBody:
+        content:
+          application/json:
+            schema: {$ref: '#/components/schemas/RouterTestRequest'}
+      responses:
+        "200": {$ref: '#/components/responses/RouterTest'}
+        "400": {$ref: '#/components/responses/Error'}
+        "404": {$ref: '#/components/responses/Error'}
+        "502": {$ref: '#/components/responses/RouterTest'}
+        "504": {$ref: '#/components/responses/RouterTest'}
+        default: {$ref: '#/components/responses/Error'}
+  /v1/fires:
+    get:
+      operationId: listFires
+      summary: List fires from the history, newest first
+      description: Needs config:read.
+      parameters:
+        - {name: alert, in: query, schema: {type: string}}
+        - {name: since, in: query, schema: {type: string}, description: RFC 3339 time or a duration before now, such as 24h}
+        - {name: limit, in: query, schema: {type: integer, minimum: 0, default: 100}}
+      responses:
+        "200":
+          description: The fires
+          content:
+            application/json:
+              schema: {type: array, items: {$ref: '#/components/schemas/Fire'}}
+        "400": {$ref: '#/components/responses/Error'}
+        "503": {$ref: '#/components/responses/Error'}
+        default: {$ref: '#/components/responses/Error'}
+  /v1/fires/{fire_id}:
+    get:
+      operationId: getFire
+      summary: Get a fire with the outcome of each schedule
+      description: Needs config:read.
+      parameters:
+        - {name: fire_id, in: path, required: true, schema: {type: string}}
+      responses:
+        "200":
+          description: The fire
+          content:
+            application/json:
+              schema: {$ref: '#/components/schemas/Fire'}
+        "404": {$ref: '#/components/responses/Error'}
+        default: {$ref: '#/components/responses/Error'}
+  /v1/-/reload:
+    post:
+      operationId: reload
+      summary: Reload the main config and the alerts
+      description: Needs config:write.
+      responses:
+        "200": {$ref: '#/components/responses/ReloadStatus'}
+        "500": {$ref: '#/components/responses/ReloadFailed'}
+        default: {$ref: '#/components/responses/Error'}
+  /v1/-/scheduler:
+    get:
+      operationId: listJobs
+      summary: List the scheduler's cron jobs
+      description: Needs config:read.
+      responses:
+        "200":
+          description: The jobs
+          content:
+            application/json:
+              schema: {type: array, items: {$ref: '#/components/schemas/Job'}}
+        default: {$ref: '#/components/responses/Error'}
+  /v1/openapi.json:
+    get:
+      operationId: getOpenAPI
+      summary: This document
+      security: [{}]
+      responses:
+        "200":
+          description: The OpenAPI document
+          content:
+            application/json:
+              schema: {type: object}
+  /v1/ekg:
+    get:
+      operationId: ekg
+      summary: Liveness
+      security: [{}]
+      responses:
+        "200": {$ref: '#/components/responses/OK'}
+  /healthz:
+    get:
+      operationId: healthz
+      summary: Liveness, checking the route manager isn't stuck
+      security: [{}]
+      responses:
+        "200": {$ref: '#/components/responses/OK'}
+  /readyz:
+    get:
+      operationId: readyz
+      summary: Readiness
+      description: With detail the checks are returned as JSON, which needs config:read.
+      security: [{}]
+      parameters:
+        - {name: detail, in: query, schema: {type: boolean, default: false}}
+      responses:
+        "200": {$ref: '#/components/responses/Readiness'}
+        "503": {$ref: '#/components/responses/NotReady'}
+  /metrics:
+    get:
+      operationId: metrics
+      summary: Prometheus metrics
+      security: [{}]
+      responses:
+        "200":
+          description: Metrics in the Prometheus text format
+          content:
+            text/plain: {schema: {type: string}}
+components:
+  securitySchemes:
+    bearer: {type: http, scheme: bearer, description: An API key}
+    apiKey: {type: apiKey, in: header, name: X-Api-Key}
+    signature:
+      type: apiKey
+      in: header
+      name: X-Alert-Router-Signature
+      description: HMAC-SHA256 of the timestamp and body with the alert's signing secret
+    timestamp: {type: apiKey, in: header, name: X-Alert-Router-Timestamp}
+  parameters:
+    alertId: {name: id, in: path, required: true, schema: {type: string, pattern: '^[a-z0-9_-][a-z0-9._-]*$', maxLength: 128}}
+    routerId: {name: router_id, in: path, required: true, schema: {type: string, pattern: '^[a-z0-9_-][a-z0-9._-]*$', maxLength: 128}}
+    offset: {name: offset, in: query, schema: {type: integer, minimum: 0}}
+    ifMatch:
+      name: If-Match
+      in: header
+      schema: {type: string}
+      description: The revision from the ETag of a GET.  The change fails with 412 if it is no longer current.
+  headers:
+    ETag: {schema: {type: string}, description: Revision of the config}
+  requestBodies:
+    AlertConfig:
+      required: true
+      content:
+        application/json:
+          schema: {$ref: '#/components/schemas/AlertConfig'}
+        application/yaml:
+          schema: {$ref: '#/components/schemas/AlertConfig'}
+    RouterEntry:
+      required: true
+      content:
+        application/json:
+          schema: {$ref: '#/components/schemas/RouterEntry'}
+        application/yaml:
+          schema: {$ref: '#/components/schemas/RouterEntry'}
+  responses:
+    OK:
+      description: OK
+      content:
+        text/plain: {schema: {type: string}}
+    Changed:
+      description: Changed
+      headers:
+        ETag: {$ref: '#/components/headers/ETag'}
+    Error:
+      description: The request failed
+      content:
+        application/json:
+          schema: {$ref: '#/components/schemas/Error'}
+    WaitedFire:
+      description: Some deliveries failed or didn't finish in time
+      content:
+        application/json:
+          schema: {$ref: '#/components/schemas/Fire'}
+    RouterTest:
+      description: The outcome of the test
+      content:
+        application/json:
+          schema: {$ref: '#/components/schemas/RouterTest'}
+    ReloadStatus:
+      description: The outcome of the reloads so far
+      content:
+        application/json:
+          schema: {$ref: '#/components/schemas/ReloadStatus'}
+    ReloadFailed:
+      description: The reload failed and the current configuration is kept; reloads has the reload status
+      content:
+        application/json:
+          schema: {$ref: '#/components/schemas/Error'}
+    Readiness:
+      description: OK, or with detail the checks
+      content:
+        text/plain: {schema: {type: string}}
+        application/json:
+          schema: {$ref: '#/components/schemas/Readiness'}
+    NotReady:
+      description: An error naming the failed checks, or with detail the checks
+      content:
+        application/json:
+          schema:
+            oneOf:
+              - {$ref: '#/components/schemas/Error'}
+              - {$ref: '#/components/schemas/Readiness'}
+  schemas:
+    Error:
+      type: object
+      required: [error]
+      properties:
+        error:
+          type: object
+          required: [code, message]
+          properties:
+            code:
+              type: string
+              enum: [bad_request, invalid_alert, invalid_router, unauthorized, forbidden, not_found,
+                method_not_allowed, alert_exists, shared_file, router_exists, config_router, router_in_use,
+                precondition_failed, unsupported_media_type, internal, unavailable, reload_failed]
+            message: {type: string}
+            field: {type: string, description: The parameter, header or body field at fault}
+        fire_id: {type: string, description: The record of a fire of an unknown alert}
+        reloads: {$ref: '#/components/schemas/ReloadStatus'}
+    Event:
+      type: object
+      properties:
+        msg: {type: string}
+    FireResponse:
+      type: object
+      properties:
+        fire_id: {type: string}
+    AlertConfig:
+      type: object
+      additionalProperties: false
+      required: [schedule]
+      properties:
+        alert: {type: string}
+        labels: {type: object, additionalProperties: {type: string}}
+        schedule: {type: array, items: {$ref: '#/components/schemas/Schedule'}}
+        signing_secret: {type: string, writeOnly: true}
+    Schedule:
+      type: object
+      additionalProperties: false
+      required: [id]
+      properties:
+        id: {type: string}
+        router_id: {type: string}
+        enabled: {type: boolean}
+        smtphost: {type: string}
+        smtpport: {type: integer}
+        smtpauthuser: {type: string}
+        smtpauthpass: {type: string}
+        email_addrs: {type: array, items: {type: string}}
+        url: {type: string}
+        username: {type: string}
+        password: {type: string}
+        query_parms: {type: array, items: {type: string}}
+        start: {type: string, description: Cron spec that enables the schedule}
+        end: {type: string, description: Cron spec that disables the schedule}
+    RouterEntry:
+      type: object
+      additionalProperties: false
+      required: [type]
+      properties:
+        type: {type: string, enum: [email, webhook]}
+        id: {type: string}
+        router_id: {type: string}
+        enabled: {type: boolean, default: true}
+        smtphost: {type: string}
+        smtpport: {type: integer}
+        smtpauthuser: {type: string}
+        smtpauthpass: {type: string}
+        email_addrs: {type: array, items: {type: string}}
+        url: {type: string}
+        username: {type: string}
+        password: {type: string}
+        query_parms: {type: array, items: {type: string}}
+        start: {type: string}
+        end: {type: string}
+    AlertStatus:
+      type: object
+      description: The config can be sent back as is to update the alert.
+      properties:
+        alert: {type: string}
+        config: {$ref: '#/components/schemas/AlertConfig'}
+        status: {type: array, items: {$ref: '#/components/schemas/ScheduleStatus'}}
+    ScheduleStatus:
+      type: object
+      properties:
+        id: {type: string}
+        router_id: {type: string}
+        router_type: {type: string}
+        enabled_now: {type: boolean}
+        next_transition: {type: string, format: date-time}
+    ApplyResult:
+      type: object
+      properties:
+        dry_run: {type: boolean}
+        changes:
+          type: array
+          items:
+            type: object
+            properties:
+              alert: {type: string}
+              action: {type: string, enum: [create, update, unchanged, delete]}
+    Fire:
+      type: object
+      properties:
+        fire_id: {type: string}
+        alert: {type: string}
+        msg: {type: string}
+        received: {type: string, format: date-time}
+        error: {type: string}
+        schedules: {type: array, items: {$ref: '#/components/schemas/FireSchedule'}}
+    FireSchedule:
+      type: object
+      properties:
+        schedule_id: {type: string}
+        router_id: {type: string}
+        outcome: {type: string, enum: [skipped, pending, delivered, failed]}
+        reason: {type: string}
+        error: {type: string}
+        latency_ms: {type: integer}
+        finished: {type: string, format: date-time}
+    Simulation:
+      type: object
+      properties:
+        alert: {type: string}
+        at: {type: string, format: date-time}
+        schedules: {type: array, items: {$ref: '#/components/schemas/SimulatedSchedule'}}
+    SimulatedSchedule:
+      type: object
+      properties:
+        schedule_id: {type: string}
+        router_id: {type: string}
+        router_type: {type: string}
+        enabled: {type: boolean}
+        reason: {type: string}
+        next_transition: {type: string, format: date-time}
+        notification: {$ref: '#/components/schemas/Notification'}
+        error: {type: string}
+    Notification:
+      type: object
+      properties:
+        recipients: {type: array, items: {type: string}}
+        message: {type: string}
+    RouterInfo:
+      type: object
+      properties:
+        router_id: {type: string}
+        type: {type: string}
+        enabled: {type: boolean}
+        origin: {type: string, enum: [config, api]}
+        config: {type: object, description: The router's config, secrets redacted}
+        health: {$ref: '#/components/schemas/RouterHealth'}
+    RouterHealth:
+      type: object
+      properties:
+        router_id: {type: string}
+        last_success: {type: string, format: date-time}
+        last_error: {type: string}
+        last_error_time: {type: string, format: date-time}
+        last_probe: {type: string, format: date-time}
+    RouterTestRequest:
+      type: object
+      additionalProperties: false
+      properties:
+        msg: {type: string}
+        email_addrs: {type: array, items: {type: string}}
+    RouterTest:
+      type: object
+      properties:
+        router_id: {type: string}
+        router_type: {type: string}
+        outcome: {type: string, enum: [pending, delivered, failed]}
+        error: {type: string}
+        latency_ms: {type: integer}
+        notification: {$ref: '#/components/schemas/Notification'}
+    ReloadStatus:
+      type: object
+      properties:
+        reloads: {type: integer}
+        failures: {type: integer}
+        last_reload: {type: string, format: date-time}
+        last_success: {type: string, format: date-time}
+        last_error: {type: string}
+    Readiness:
+      type: object
+      properties:
+        ready: {type: boolean}
+        checks:
+          type: array
+          items:
+            type: object
+            properties:
+              name: {type: string}
+              ok: {type: boolean}
+              error: {type: string}
+        routers: {type: array, items: {$ref: '#/components/schemas/RouterHealth'}}
+    Job:
+      type: object
+      properties:
+        id: {type: integer}
+        alert: {type: string}
+        schedule_id: {type: string}
+        router_id: {type: string}
+        action: {type: string}
+        spec: {type: string}
+        next: {type: string, format: date-time}
+`
+
+// The document as served, converted once
+var openapiJSON []byte
+
+func init() {
+	var err error
+	if openapiJSON, err = config.YAMLToJSON([]byte(openapiSpec)); err != nil {
+		panic(err)
+	}
+}
+
+// The OpenAPI document describing this API
+// API Endpoint: GET /v1/openapi.json
+func (aa *AlertApi) OpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, err := w.Write(openapiJSON)
+	if err != nil {
+		log.Error(err)
+	}
+}
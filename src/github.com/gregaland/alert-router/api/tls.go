@@ -0,0 +1,65 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/gregaland/alert-router/config"
+	log "github.com/sirupsen/logrus"
+	"sync"
+)
+
+// Certificates of the running config, reloaded when a reload replaces it.
+// If the new files fail to load the old ones are kept.
+type tlsFiles struct {
+	lock   sync.Mutex
+	config *config.RigConfig
+	cert   *tls.Certificate
+	pool   *x509.CertPool
+}
+
+// The TLS config the listener starts with.  Each handshake picks up the
+// certificates of the running config.
+func (aa *AlertApi) tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		GetCertificate:     aa.getCertificate,
+		GetConfigForClient: aa.getConfigForClient,
+	}
+}
+
+func (aa *AlertApi) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert, _, _ := aa.certificates()
+	return cert, nil
+}
+
+func (aa *AlertApi) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	_, pool, require := aa.certificates()
+	c := aa.tlsConfig()
+	c.GetConfigForClient = nil
+	if pool != nil {
+		c.ClientCAs = pool
+		c.ClientAuth = tls.VerifyClientCertIfGiven
+		if require {
+			c.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	return c, nil
+}
+
+// The server certificate, client CAs and whether a client certificate is
+// required
+func (aa *AlertApi) certificates() (*tls.Certificate, *x509.CertPool, bool) {
+	rigConfig := aa.routeMgr.Config()
+	aa.tls.lock.Lock()
+	defer aa.tls.lock.Unlock()
+	if aa.tls.config != rigConfig {
+		cert, pool, err := rigConfig.LoadTLS()
+		if err != nil {
+			log.Errorf("keeping the current certificates: %v", err)
+		} else {
+			aa.tls.cert, aa.tls.pool = cert, pool
+		}
+		aa.tls.config = rigConfig
+	}
+	return aa.tls.cert, aa.tls.pool, rigConfig.RequireClientCert
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/gregaland/alert-router/auth"
+	"gopkg.in/yaml.v2"
+	"os"
+	"strings"
+)
+
+// gen-api-key subcommand.  Prints a new API key and the api_keys entry,
+// holding only its hash, to add to the configuration.
+func genApiKey(args []string) int {
+	fs := flag.NewFlagSet("gen-api-key", flag.ExitOnError)
+	name := fs.String("n", "", "Name of the key")
+	scopes := fs.String("s", "", "Comma separated scopes: config:read, config:write, fire:<alert glob>")
+	_ = fs.Parse(args)
+
+	key, err := auth.NewKey()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	entry := &auth.Key{Name: *name, Hash: auth.HashKey(key)}
+	if *scopes != "" {
+		entry.Scopes = strings.Split(*scopes, ",")
+	}
+	if err = entry.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	out, err := yaml.Marshal([]*auth.Key{entry})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("# api key: %s\n%s", key, out)
+	return 0
+}
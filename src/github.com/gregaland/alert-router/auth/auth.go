@@ -0,0 +1,168 @@
+// Package auth identifies API callers by key, client certificate or
+// request signature and checks the scopes they are granted.  Only the
+// sha256 of a key is kept in the configuration.
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"path"
+	"strings"
+)
+
+const (
+	SCOPE_CONFIG_READ  string = "config:read"
+	SCOPE_CONFIG_WRITE string = "config:write"
+	SCOPE_FIRE         string = "fire:"
+
+	KEY_SIZE int = 32
+)
+
+// Key is an API key entry in the configuration
+type Key struct {
+	Name   string   `yaml:"name"`
+	Hash   string   `yaml:"key_hash"`
+	Scopes []string `yaml:"scopes"`
+}
+
+// Identity is the authenticated caller of a request
+type Identity struct {
+	Name   string
+	Scopes []string
+}
+
+// Allowed reports whether the identity has been granted scope.
+// fire:<alert id> is granted by a fire:<glob> scope matching the id, and
+// config:read by config:write.
+func (id *Identity) Allowed(scope string) bool {
+	for _, s := range id.Scopes {
+		switch {
+		case s == scope:
+			return true
+		case s == SCOPE_CONFIG_WRITE && scope == SCOPE_CONFIG_READ:
+			return true
+		case strings.HasPrefix(s, SCOPE_FIRE) && strings.HasPrefix(scope, SCOPE_FIRE):
+			if ok, _ := path.Match(s[len(SCOPE_FIRE):], scope[len(SCOPE_FIRE):]); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ValidScope checks that scope is one auth knows
+func ValidScope(scope string) error {
+	switch {
+	case scope == SCOPE_CONFIG_READ, scope == SCOPE_CONFIG_WRITE:
+		return nil
+	case strings.HasPrefix(scope, SCOPE_FIRE) && len(scope) > len(SCOPE_FIRE):
+		_, err := path.Match(scope[len(SCOPE_FIRE):], "")
+		return errors.Wrap(err, scope)
+	}
+	return errors.Errorf("unknown scope %s", scope)
+}
+
+// Validate checks a key entry
+func (k *Key) Validate() error {
+	if k.Name == "" {
+		return errors.New("name is required")
+	}
+	if h, err := hex.DecodeString(k.Hash); err != nil || len(h) != sha256.Size {
+		return errors.Errorf("key %s: key_hash must be a hex sha256", k.Name)
+	}
+	if len(k.Scopes) == 0 {
+		return errors.Errorf("key %s: no scopes", k.Name)
+	}
+	for _, s := range k.Scopes {
+		if err := ValidScope(s); err != nil {
+			return errors.Wrapf(err, "key %s", k.Name)
+		}
+	}
+	return nil
+}
+
+// NewKey returns a random API key
+func NewKey() (string, error) {
+	b := make([]byte, KEY_SIZE)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// HashKey returns the value of key_hash for key
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// LoadKeys reads a YAML list of keys from a file
+func LoadKeys(file string) ([]*Key, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]*Key, 0)
+	if err = yaml.UnmarshalStrict(data, &keys); err != nil {
+		return nil, errors.Wrap(err, file)
+	}
+	return keys, nil
+}
+
+// Keys looks up callers by API key or client certificate
+type Keys struct {
+	hashes     [][]byte
+	identities []*Identity
+	certs      []*CertIdentity
+}
+
+// NewKeys indexes key and client certificate entries, which must be valid
+func NewKeys(keys []*Key, certs []*CertIdentity) *Keys {
+	ks := &Keys{certs: certs}
+	for _, k := range keys {
+		h, err := hex.DecodeString(k.Hash)
+		if err != nil {
+			continue
+		}
+		ks.hashes = append(ks.hashes, h)
+		ks.identities = append(ks.identities, &Identity{Name: k.Name, Scopes: k.Scopes})
+	}
+	return ks
+}
+
+// Empty reports whether there are no keys or client certificate entries,
+// which turns authentication off
+func (ks *Keys) Empty() bool {
+	return len(ks.hashes) == 0 && len(ks.certs) == 0
+}
+
+// Authenticate returns the identity of key, or nil if it is unknown
+func (ks *Keys) Authenticate(key string) *Identity {
+	sum := sha256.Sum256([]byte(key))
+	var found *Identity
+	for i, h := range ks.hashes {
+		if subtle.ConstantTimeCompare(sum[:], h) == 1 && found == nil {
+			found = ks.identities[i]
+		}
+	}
+	return found
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying id
+func NewContext(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the identity in ctx, if any
+func FromContext(ctx context.Context) *Identity {
+	id, _ := ctx.Value(contextKey{}).(*Identity)
+	return id
+}
@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"context"
+	"gotest.tools/assert"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestIdentity_Allowed(t *testing.T) {
+	id := &Identity{Name: "agent", Scopes: []string{"fire:db*", "config:write"}}
+	assert.Assert(t, id.Allowed("fire:dbfail"))
+	assert.Assert(t, !id.Allowed("fire:cpu"))
+	assert.Assert(t, id.Allowed(SCOPE_CONFIG_READ))
+	assert.Assert(t, id.Allowed(SCOPE_CONFIG_WRITE))
+
+	id = &Identity{Name: "monitor", Scopes: []string{"fire:*"}}
+	assert.Assert(t, id.Allowed("fire:cpu"))
+	assert.Assert(t, !id.Allowed(SCOPE_CONFIG_READ))
+
+	id = &Identity{Name: "dashboard", Scopes: []string{SCOPE_CONFIG_READ}}
+	assert.Assert(t, !id.Allowed(SCOPE_CONFIG_WRITE))
+}
+
+func TestKey_Validate(t *testing.T) {
+	hash := HashKey("s3cret")
+	assert.NilError(t, (&Key{Name: "ci", Hash: hash, Scopes: []string{"fire:*", "config:read"}}).Validate())
+	assert.ErrorContains(t, (&Key{Hash: hash, Scopes: []string{"fire:*"}}).Validate(), "name is required")
+	assert.ErrorContains(t, (&Key{Name: "ci", Hash: "s3cret", Scopes: []string{"fire:*"}}).Validate(), "hex sha256")
+	assert.ErrorContains(t, (&Key{Name: "ci", Hash: hash}).Validate(), "no scopes")
+	assert.ErrorContains(t, (&Key{Name: "ci", Hash: hash, Scopes: []string{"admin"}}).Validate(), "unknown scope admin")
+	assert.ErrorContains(t, (&Key{Name: "ci", Hash: hash, Scopes: []string{"fire:["}}).Validate(), "syntax error")
+}
+
+func TestKeys_Authenticate(t *testing.T) {
+	f, err := ioutil.TempFile("", "keys")
+	assert.NilError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString("- name: monitor\n  key_hash: " + HashKey("s3cret") + "\n  scopes: [\"fire:*\"]\n")
+	assert.NilError(t, err)
+	assert.NilError(t, f.Close())
+
+	keys, err := LoadKeys(f.Name())
+	assert.NilError(t, err)
+	ks := NewKeys(keys, nil)
+	assert.Assert(t, !ks.Empty())
+	assert.Assert(t, NewKeys(nil, nil).Empty())
+
+	id := ks.Authenticate("s3cret")
+	assert.Assert(t, id != nil)
+	assert.Equal(t, "monitor", id.Name)
+	assert.Assert(t, ks.Authenticate("hunter2") == nil)
+
+	ctx := NewContext(context.Background(), id)
+	assert.Equal(t, id, FromContext(ctx))
+	assert.Assert(t, FromContext(context.Background()) == nil)
+}
+
+func TestVerifier(t *testing.T) {
+	secrets := [][]byte{[]byte("producer-a"), []byte("producer-b")}
+	body := []byte(`{"msg": "db is down"}`)
+	now := time.Now()
+	ts := strconv.FormatInt(now.Unix(), 10)
+	v := NewVerifier(0)
+
+	i, err := v.Verify(secrets, ts, Sign(secrets[1], ts, body), body, now)
+	assert.NilError(t, err)
+	assert.Equal(t, 1, i)
+	_, err = v.Verify(secrets, ts, Sign(secrets[1], ts, body), body, now)
+	assert.Equal(t, ErrReplay, err)
+
+	_, err = v.Verify(secrets, ts, Sign([]byte("other"), ts, body), body, now)
+	assert.Equal(t, ErrBadSignature, err)
+	_, err = v.Verify(secrets, ts, Sign(secrets[0], ts, body), []byte(`{"msg": "all clear"}`), now)
+	assert.Equal(t, ErrBadSignature, err)
+	_, err = v.Verify(secrets, "yesterday", Sign(secrets[0], ts, body), body, now)
+	assert.ErrorContains(t, err, "malformed timestamp")
+
+	old := strconv.FormatInt(now.Add(-DEFAULT_SIGNATURE_WINDOW-time.Second).Unix(), 10)
+	_, err = v.Verify(secrets, old, Sign(secrets[0], old, body), body, now)
+	assert.Equal(t, ErrStale, err)
+	v.SetWindow(time.Hour)
+	_, err = v.Verify(secrets, old, Sign(secrets[0], old, body), body, now)
+	assert.NilError(t, err)
+}
@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"crypto/x509"
+	"github.com/pkg/errors"
+	"path"
+)
+
+// CertIdentity grants scopes to clients whose verified certificate has
+// a common name or subject alternative name matching Subject, which may
+// be a glob
+type CertIdentity struct {
+	Subject string   `yaml:"subject"`
+	Scopes  []string `yaml:"scopes"`
+}
+
+// Validate checks a client certificate entry
+func (c *CertIdentity) Validate() error {
+	if c.Subject == "" {
+		return errors.New("subject is required")
+	}
+	if _, err := path.Match(c.Subject, ""); err != nil {
+		return errors.Wrapf(err, "client cert %s", c.Subject)
+	}
+	if len(c.Scopes) == 0 {
+		return errors.Errorf("client cert %s: no scopes", c.Subject)
+	}
+	for _, s := range c.Scopes {
+		if err := ValidScope(s); err != nil {
+			return errors.Wrapf(err, "client cert %s", c.Subject)
+		}
+	}
+	return nil
+}
+
+// CertNames returns the names a certificate identifies its holder by:
+// the common name and the DNS, email and URI alternative names
+func CertNames(cert *x509.Certificate) []string {
+	names := make([]string, 0)
+	if cert.Subject.CommonName != "" {
+		names = append(names, cert.Subject.CommonName)
+	}
+	names = append(names, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		names = append(names, u.String())
+	}
+	return names
+}
+
+// AuthenticateCert returns the identity of a verified client certificate,
+// or nil if no entry matches it
+func (ks *Keys) AuthenticateCert(cert *x509.Certificate) *Identity {
+	for _, c := range ks.certs {
+		for _, name := range CertNames(cert) {
+			if ok, _ := path.Match(c.Subject, name); ok {
+				return &Identity{Name: "cert:" + name, Scopes: c.Scopes}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	TIMESTAMP_HEADER string = "X-Alert-Router-Timestamp"
+	SIGNATURE_HEADER string = "X-Alert-Router-Signature"
+	SIGNATURE_PREFIX string = "sha256="
+
+	DEFAULT_SIGNATURE_WINDOW time.Duration = 5 * time.Minute
+)
+
+var (
+	ErrBadSignature = errors.New("bad signature")
+	ErrStale        = errors.New("timestamp outside the signature window")
+	ErrReplay       = errors.New("signature already used")
+)
+
+// Sign returns the signature header value for a request body sent at
+// timestamp, a unix time in seconds: the hex HMAC-SHA256 of
+// "<timestamp>.<body>" prefixed with "sha256=".
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return SIGNATURE_PREFIX + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verifier checks signed requests.  A signature is accepted once, and
+// only while its timestamp is within the window of now.
+type Verifier struct {
+	lock   sync.Mutex
+	window time.Duration
+	seen   map[string]time.Time
+}
+
+// NewVerifier returns a verifier with the given replay window
+func NewVerifier(window time.Duration) *Verifier {
+	v := &Verifier{seen: make(map[string]time.Time)}
+	v.SetWindow(window)
+	return v
+}
+
+// SetWindow changes the replay window.  Zero selects the default.
+func (v *Verifier) SetWindow(window time.Duration) {
+	if window <= 0 {
+		window = DEFAULT_SIGNATURE_WINDOW
+	}
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.window = window
+}
+
+// Verify checks that signature is the signature of body at timestamp by
+// one of secrets and returns the index of that secret.
+func (v *Verifier) Verify(secrets [][]byte, timestamp, signature string, body []byte, now time.Time) (int, error) {
+	v.lock.Lock()
+	window := v.window
+	v.lock.Unlock()
+
+	secs, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return -1, errors.Wrap(ErrBadSignature, "malformed timestamp")
+	}
+	if d := now.Sub(time.Unix(secs, 0)); d > window || d < -window {
+		return -1, ErrStale
+	}
+	if !strings.HasPrefix(signature, SIGNATURE_PREFIX) {
+		return -1, errors.Wrap(ErrBadSignature, "expected "+SIGNATURE_PREFIX)
+	}
+
+	found := -1
+	for i, secret := range secrets {
+		if hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature)) && found < 0 {
+			found = i
+		}
+	}
+	if found < 0 {
+		return -1, ErrBadSignature
+	}
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	for sig, expires := range v.seen {
+		if now.After(expires) {
+			delete(v.seen, sig)
+		}
+	}
+	if _, ok := v.seen[signature]; ok {
+		return -1, ErrReplay
+	}
+	v.seen[signature] = time.Unix(secs, 0).Add(window)
+	return found, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/gregaland/alert-router/config"
+	"github.com/gregaland/alert-router/routemgr"
+	"github.com/gregaland/alert-router/store"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"os"
+)
+
+// check-config subcommand.  Loads the main config, the routers added
+// through the api and every alert config in its store, alerts_path by
+// default, and reports all problems found.  Returns a non-zero exit code if
+// the configuration is invalid.  The store is only read; a bolt database
+// the server holds, or one not created yet, is skipped with a warning.
+func checkConfig(args []string) int {
+	fs := flag.NewFlagSet("check-config", flag.ExitOnError)
+	configFile := fs.String("c", "/etc/alert-router.yml", "Path to configuration file")
+	_ = fs.Parse(args)
+	log.SetLevel(log.WarnLevel)
+
+	rigConfig, err := config.LoadRigConfig(*configFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	var errs config.ValidationErrors
+	var alertConfigs []*config.AlertConfig
+	st, err := store.NewReadOnly(rigConfig.Store, rigConfig.AlertsPath)
+	if cause := errors.Cause(err); cause == store.ErrLocked || os.IsNotExist(cause) {
+		fmt.Fprintf(os.Stderr, "warning: %v, the alerts and routers in the store are not checked\n", err)
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	} else {
+		defer st.Close()
+		if withRouters, err := routemgr.WithStoredRouters(rigConfig, st); err != nil {
+			errs = append(errs, err)
+		} else {
+			rigConfig = withRouters
+		}
+		alertConfigs, err = config.LoadAlerts(st)
+		if ve, ok := err.(config.ValidationErrors); ok {
+			errs = append(errs, ve...)
+		} else if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err = rigConfig.Validate(alertConfigs); err != nil {
+		errs = append(errs, err.(config.ValidationErrors)...)
+	}
+
+	for _, err := range errs {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if len(errs) > 0 {
+		fmt.Fprintf(os.Stderr, "%s: %d error(s)\n", *configFile, len(errs))
+		return 1
+	}
+	fmt.Printf("%s: OK, %d router(s), %d alert(s)\n", *configFile, len(rigConfig.Routers), len(alertConfigs))
+	return 0
+}
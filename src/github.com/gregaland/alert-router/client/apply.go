@@ -0,0 +1,50 @@
+package client
+
+import (
+	"bytes"
+	"github.com/gregaland/alert-router/config"
+	"github.com/gregaland/alert-router/store"
+	"github.com/gregaland/alert-router/wire"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strconv"
+)
+
+// ReadAlertConfigs reads the alert configs in a file or, like the dir
+// store, in every .yml, .yaml and .json file below a directory
+func ReadAlertConfigs(path string) ([]*config.AlertConfig, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return config.LoadAlerts(store.NewDirStore(map[string]string{store.KIND_ALERTS: path}, ""))
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return config.ParseAlertConfigs(data)
+}
+
+// Apply makes the server's alerts match alertConfigs: missing alerts are
+// created and changed ones replaced.  With prune set, alerts that are not
+// in alertConfigs are deleted.  With dryRun set the server only reports
+// the changes.
+func (c *Client) Apply(alertConfigs []*config.AlertConfig, prune, dryRun bool) (*wire.ApplyResult, error) {
+	var body bytes.Buffer
+	for _, ac := range alertConfigs {
+		out, err := config.MarshalPlainYAML(ac)
+		if err != nil {
+			return nil, err
+		}
+		body.WriteString("---\n")
+		body.Write(out)
+	}
+	query := url.Values{"dry_run": {strconv.FormatBool(dryRun)}, "prune": {strconv.FormatBool(prune)}}
+	result := &wire.ApplyResult{}
+	_, err := c.do(&request{method: "POST", path: "/v1/alerts:apply", query: query,
+		body: body.Bytes(), contentType: "application/yaml"}, result)
+	return result, err
+}
@@ -0,0 +1,363 @@
+// Package client is a Go client for the alert-router REST API, used by
+// the ctl subcommands.
+package client
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"github.com/gregaland/alert-router/auth"
+	"github.com/gregaland/alert-router/config"
+	"github.com/gregaland/alert-router/wire"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DEFAULT_URL string = "http://localhost:8000"
+
+	// environment variables that override the client config file
+	ENV_URL            string = "ALERT_ROUTER_URL"
+	ENV_API_KEY        string = "ALERT_ROUTER_API_KEY"
+	ENV_SIGNING_SECRET string = "ALERT_ROUTER_SIGNING_SECRET"
+	ENV_CONFIG         string = "ALERT_ROUTER_CONFIG"
+
+	// requests other than waiting fires give up after this long
+	DEFAULT_TIMEOUT time.Duration = 30 * time.Second
+)
+
+// Config locates the server and holds the credentials to call it with
+type Config struct {
+	Url           string `yaml:"url"`
+	ApiKey        string `yaml:"api_key,omitempty"`
+	SigningSecret string `yaml:"signing_secret,omitempty"`
+	CaFile        string `yaml:"ca_file,omitempty"`
+}
+
+// DefaultConfigPath is where the client config is read from unless
+// ALERT_ROUTER_CONFIG names another file
+func DefaultConfigPath() string {
+	if p, ok := os.LookupEnv(ENV_CONFIG); ok {
+		return p
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".alert-router", "client.yml")
+}
+
+// LoadConfig reads the client config at path, if it exists, then applies
+// the environment variables, which take precedence
+func LoadConfig(path string) (*Config, error) {
+	c := &Config{}
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err = yaml.UnmarshalStrict(data, c); err != nil {
+			return nil, errors.Wrap(err, path)
+		}
+	}
+	if v, ok := os.LookupEnv(ENV_URL); ok {
+		c.Url = v
+	}
+	if v, ok := os.LookupEnv(ENV_API_KEY); ok {
+		c.ApiKey = v
+	}
+	if v, ok := os.LookupEnv(ENV_SIGNING_SECRET); ok {
+		c.SigningSecret = v
+	}
+	if c.Url == "" {
+		c.Url = DEFAULT_URL
+	}
+	return c, nil
+}
+
+// Error is a response with an error status.  Code and Field are those of
+// the server's error body, if it sent one.
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+	Field      string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return http.StatusText(e.StatusCode)
+	}
+	return fmt.Sprintf("%s: %s", http.StatusText(e.StatusCode), e.Message)
+}
+
+// Read the error body of a response, falling back to its text
+func responseError(statusCode int, data []byte) *Error {
+	var body struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+			Field   string `json:"field"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(data, &body); err == nil && body.Error.Code != "" {
+		return &Error{StatusCode: statusCode, Code: body.Error.Code, Message: body.Error.Message, Field: body.Error.Field}
+	}
+	return &Error{StatusCode: statusCode, Message: strings.TrimSpace(string(data))}
+}
+
+// IsNotFound reports whether err is a 404 response
+func IsNotFound(err error) bool {
+	e, ok := errors.Cause(err).(*Error)
+	return ok && e.StatusCode == http.StatusNotFound
+}
+
+// Client calls the alert-router API
+type Client struct {
+	base   *url.URL
+	config *Config
+	http   *http.Client
+}
+
+// New returns a client for the server in c
+func New(c *Config) (*Client, error) {
+	base, err := url.Parse(strings.TrimSuffix(c.Url, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, errors.Errorf("url must be http or https: %s", c.Url)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if c.CaFile != "" {
+		pem, err := ioutil.ReadFile(c.CaFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("no certificates in %s", c.CaFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	return &Client{base: base, config: c, http: &http.Client{Transport: transport}}, nil
+}
+
+// Response of a request: its status and revision
+type Response struct {
+	StatusCode int
+	ETag       string
+}
+
+// A request to path relative to the server url, with its query.  Path
+// segments taken from ids are escaped.
+type request struct {
+	method      string
+	path        string
+	query       url.Values
+	body        []byte
+	contentType string
+	ifMatch     string
+	timeout     time.Duration
+	// sign the body with the signing secret
+	signed bool
+	// statuses above 299 whose body is decoded rather than an error
+	accept []int
+}
+
+// Send a request and decode the JSON response into out, if not nil
+func (c *Client) do(req *request, out interface{}) (*Response, error) {
+	u := *c.base
+	reqPath, err := url.PathUnescape(req.path)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = c.base.Path + reqPath
+	u.RawPath = c.base.EscapedPath() + req.path
+	u.RawQuery = req.query.Encode()
+	var body io.Reader
+	if req.body != nil {
+		body = bytes.NewReader(req.body)
+	}
+	r, err := http.NewRequest(req.method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if req.body != nil {
+		contentType := req.contentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		r.Header.Set("Content-Type", contentType)
+	}
+	if c.config.ApiKey != "" {
+		r.Header.Set("Authorization", "Bearer "+c.config.ApiKey)
+	}
+	if req.ifMatch != "" {
+		r.Header.Set("If-Match", `"`+req.ifMatch+`"`)
+	}
+	if req.signed {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		r.Header.Set(auth.TIMESTAMP_HEADER, ts)
+		r.Header.Set(auth.SIGNATURE_HEADER, auth.Sign([]byte(c.config.SigningSecret), ts, req.body))
+	}
+	if req.timeout == 0 {
+		req.timeout = DEFAULT_TIMEOUT
+	}
+	client := *c.http
+	client.Timeout = req.timeout
+	resp, err := client.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	result := &Response{StatusCode: resp.StatusCode, ETag: strings.Trim(resp.Header.Get("ETag"), `"`)}
+
+	accepted := resp.StatusCode < 300
+	for _, status := range req.accept {
+		accepted = accepted || resp.StatusCode == status
+	}
+	if !accepted {
+		return result, responseError(resp.StatusCode, data)
+	}
+	if out != nil && len(data) > 0 {
+		if err = json.Unmarshal(data, out); err != nil {
+			return result, errors.Wrapf(err, "%s %s", req.method, req.path)
+		}
+	}
+	return result, nil
+}
+
+// Fire an alert.  With wait set the call returns once the routers are
+// done, up to timeout or 30s, and the fire has the outcome of each
+// schedule; otherwise only its id.  With a signing secret configured the
+// request is signed.
+func (c *Client) Fire(alertId, message string, wait bool, timeout time.Duration) (*wire.Fire, error) {
+	body, err := json.Marshal(map[string]string{"msg": message})
+	if err != nil {
+		return nil, err
+	}
+	req := &request{method: "POST", path: "/v1/alerts/" + url.PathEscape(alertId) + "/fire", query: url.Values{}, body: body}
+	if wait {
+		if timeout <= 0 {
+			timeout = DEFAULT_TIMEOUT
+		}
+		req.query.Set("wait", "true")
+		req.query.Set("timeout", timeout.String())
+		req.timeout = timeout + DEFAULT_TIMEOUT
+		req.accept = []int{http.StatusMultiStatus, http.StatusUnprocessableEntity, http.StatusBadGateway, http.StatusGatewayTimeout}
+	}
+	req.signed = c.config.SigningSecret != ""
+	fire := &wire.Fire{}
+	_, err = c.do(req, fire)
+	return fire, err
+}
+
+// ListAlerts returns the alerts that pass the filter in query, as taken
+// by GET /v1/alerts
+func (c *Client) ListAlerts(query url.Values) ([]*wire.AlertStatus, error) {
+	alerts := make([]*wire.AlertStatus, 0)
+	_, err := c.do(&request{method: "GET", path: "/v1/alerts", query: query}, &alerts)
+	return alerts, err
+}
+
+// GetAlert returns an alert with the live state of its schedules and its
+// revision
+func (c *Client) GetAlert(alertId string) (*wire.AlertStatus, string, error) {
+	as := &wire.AlertStatus{}
+	resp, err := c.do(&request{method: "GET", path: "/v1/alerts/" + url.PathEscape(alertId)}, as)
+	if err != nil {
+		return nil, "", err
+	}
+	return as, resp.ETag, nil
+}
+
+// CreateAlert adds an alert
+func (c *Client) CreateAlert(ac *config.AlertConfig) error {
+	body, err := config.MarshalPlainYAML(ac)
+	if err != nil {
+		return err
+	}
+	_, err = c.do(&request{method: "POST", path: "/v1/alerts/" + url.PathEscape(ac.AlertId),
+		body: body, contentType: "application/yaml"}, nil)
+	return err
+}
+
+// UpdateAlert replaces an alert.  A non empty revision must be the
+// alert's current one.  Returns the new revision.
+func (c *Client) UpdateAlert(ac *config.AlertConfig, revision string) (string, error) {
+	body, err := config.MarshalPlainYAML(ac)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.do(&request{method: "PUT", path: "/v1/alerts/" + url.PathEscape(ac.AlertId),
+		body: body, contentType: "application/yaml", ifMatch: revision}, nil)
+	if err != nil {
+		return "", err
+	}
+	return resp.ETag, nil
+}
+
+// DeleteAlert deletes an alert.  A non empty revision must be the alert's
+// current one.
+func (c *Client) DeleteAlert(alertId, revision string) error {
+	_, err := c.do(&request{method: "DELETE", path: "/v1/alerts/" + url.PathEscape(alertId), ifMatch: revision}, nil)
+	return err
+}
+
+// Simulate firing an alert at an instant; the zero time is now
+func (c *Client) Simulate(alertId, message string, at time.Time) (*wire.Simulation, error) {
+	body, err := json.Marshal(map[string]string{"msg": message})
+	if err != nil {
+		return nil, err
+	}
+	query := url.Values{}
+	if !at.IsZero() {
+		query.Set("at", at.Format(time.RFC3339))
+	}
+	sim := &wire.Simulation{}
+	_, err = c.do(&request{method: "POST", path: "/v1/alerts/" + url.PathEscape(alertId) + "/simulate",
+		query: query, body: body}, sim)
+	return sim, err
+}
+
+// ListRouters returns the configured routers
+func (c *Client) ListRouters() ([]*wire.RouterInfo, error) {
+	routers := make([]*wire.RouterInfo, 0)
+	_, err := c.do(&request{method: "GET", path: "/v1/routers"}, &routers)
+	return routers, err
+}
+
+// TestRouter sends a test message through a router and waits for the
+// outcome, up to timeout or 30s.  Email routers need the addresses to
+// send to.
+func (c *Client) TestRouter(routerId, message string, emailAddrs []string, timeout time.Duration) (*wire.RouterTest, error) {
+	body, err := json.Marshal(map[string]interface{}{"msg": message, "email_addrs": emailAddrs})
+	if err != nil {
+		return nil, err
+	}
+	if timeout <= 0 {
+		timeout = DEFAULT_TIMEOUT
+	}
+	query := url.Values{"timeout": {timeout.String()}}
+	result := &wire.RouterTest{}
+	_, err = c.do(&request{method: "POST", path: "/v1/routers/" + url.PathEscape(routerId) + "/test",
+		query: query, body: body, timeout: timeout + DEFAULT_TIMEOUT,
+		accept: []int{http.StatusBadGateway, http.StatusGatewayTimeout}}, result)
+	return result, err
+}
@@ -0,0 +1,202 @@
+package client
+
+import (
+	"fmt"
+	"github.com/gregaland/alert-router/api"
+	"github.com/gregaland/alert-router/auth"
+	"github.com/gregaland/alert-router/config"
+	"github.com/gregaland/alert-router/routemgr"
+	"github.com/gregaland/alert-router/store"
+	"github.com/gregaland/alert-router/wire"
+	"gotest.tools/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var rigData = `
+listen: :8000
+routers:
+ - id: slack-alerts
+   type: webhook
+   url: %s/hook
+`
+
+// Returns a client of a server whose slack-alerts router posts to
+// webhook, and the server's alerts directory
+func newTestClient(t *testing.T, webhook string, key string) (*Client, *routemgr.RouteMgr, string) {
+	dir, err := ioutil.TempDir("", "alerts")
+	assert.NilError(t, err)
+	rigConfig, err := config.NewRigConfig(strings.NewReader(fmt.Sprintf(rigData, webhook)))
+	assert.NilError(t, err)
+	rigConfig.AlertsPath = dir
+	rigConfig.Store = &store.Config{Path: filepath.Join(dir, ".state")}
+	if key != "" {
+		rigConfig.ApiKeys = []*auth.Key{{Name: "ci", Hash: auth.HashKey(key), Scopes: []string{"config:read", "config:write", "fire:*"}}}
+	}
+	rm := routemgr.NewRouteMgr(rigConfig)
+	ts := httptest.NewServer(api.NewAlertApi(rigConfig, rm))
+	t.Cleanup(ts.Close)
+
+	c, err := New(&Config{Url: ts.URL, ApiKey: key})
+	assert.NilError(t, err)
+	return c, rm, dir
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "client")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "client.yml")
+	assert.NilError(t, ioutil.WriteFile(path, []byte("url: https://alerts.example.com\napi_key: from-file\n"), 0600))
+
+	os.Setenv(ENV_API_KEY, "from-env")
+	defer os.Unsetenv(ENV_API_KEY)
+	c, err := LoadConfig(path)
+	assert.NilError(t, err)
+	assert.Equal(t, "https://alerts.example.com", c.Url)
+	assert.Equal(t, "from-env", c.ApiKey)
+
+	c, err = LoadConfig(filepath.Join(dir, "missing.yml"))
+	assert.NilError(t, err)
+	assert.Equal(t, DEFAULT_URL, c.Url)
+
+	assert.NilError(t, ioutil.WriteFile(path, []byte("uri: https://alerts.example.com\n"), 0600))
+	_, err = LoadConfig(path)
+	assert.ErrorContains(t, err, "not found")
+}
+
+// Changes from pairs of alert id and action
+func changes(kv ...string) []wire.Change {
+	result := make([]wire.Change, 0, len(kv)/2)
+	for i := 0; i+1 < len(kv); i += 2 {
+		result = append(result, wire.Change{AlertId: kv[i], Action: kv[i+1]})
+	}
+	return result
+}
+
+func TestClient_Apply(t *testing.T) {
+	c, rm, dir := newTestClient(t, "http://127.0.0.1:1", "s3cret")
+	defer os.RemoveAll(dir)
+	defer rm.Close()
+
+	src, err := ioutil.TempDir("", "src")
+	assert.NilError(t, err)
+	defer os.RemoveAll(src)
+	write := func(name, data string) {
+		assert.NilError(t, ioutil.WriteFile(filepath.Join(src, name), []byte(data), 0644))
+	}
+	write("dbfail.yml", "alert: dbfail\nschedule:\n  - id: all_day\n    router_id: slack-alerts\n")
+	write("team.yaml", "alert: disk\nschedule: []\n---\nalert: web\nschedule: []\n")
+	alertConfigs, err := ReadAlertConfigs(src)
+	assert.NilError(t, err)
+	assert.Equal(t, 3, len(alertConfigs))
+
+	result, err := c.Apply(alertConfigs, false, false)
+	assert.NilError(t, err)
+	created := changes("dbfail", wire.ACTION_CREATE, "disk", wire.ACTION_CREATE, "web", wire.ACTION_CREATE)
+	assert.DeepEqual(t, created, result.Changes)
+
+	alerts, err := c.ListAlerts(nil)
+	assert.NilError(t, err)
+	assert.Equal(t, 3, len(alerts))
+
+	assert.NilError(t, os.Remove(filepath.Join(src, "team.yaml")))
+	alertConfigs, err = ReadAlertConfigs(src)
+	assert.NilError(t, err)
+	pruned := changes("dbfail", wire.ACTION_UNCHANGED, "disk", wire.ACTION_DELETE, "web", wire.ACTION_DELETE)
+	result, err = c.Apply(alertConfigs, true, true)
+	assert.NilError(t, err)
+	assert.Assert(t, result.DryRun)
+	assert.DeepEqual(t, pruned, result.Changes)
+	alerts, err = c.ListAlerts(nil)
+	assert.NilError(t, err)
+	assert.Equal(t, 3, len(alerts))
+
+	result, err = c.Apply(alertConfigs, true, false)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, pruned, result.Changes)
+
+	as, rev, err := c.GetAlert("dbfail")
+	assert.NilError(t, err)
+	assert.Equal(t, "slack-alerts", as.Status[0].RouterId)
+	assert.Assert(t, rev != "")
+
+	_, _, err = c.GetAlert("disk")
+	assert.Assert(t, IsNotFound(err))
+
+	// errors carry the server's code and the field at fault
+	err = c.CreateAlert(&config.AlertConfig{AlertId: "dbfail", Schedule: []config.RouterParms{}})
+	assert.Equal(t, "alert_exists", err.(*Error).Code)
+	_, err = c.ListAlerts(url.Values{"enabled": {"maybe"}})
+	assert.Equal(t, "enabled", err.(*Error).Field)
+
+	// the api key is required
+	anon, err := New(&Config{Url: c.base.String()})
+	assert.NilError(t, err)
+	_, err = anon.ListAlerts(nil)
+	assert.Equal(t, http.StatusUnauthorized, err.(*Error).StatusCode)
+	assert.Equal(t, "unauthorized", err.(*Error).Code)
+}
+
+func TestClient_PathEscape(t *testing.T) {
+	paths := make(chan string, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths <- r.URL.EscapedPath()
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer ts.Close()
+	c, err := New(&Config{Url: ts.URL + "/base"})
+	assert.NilError(t, err)
+
+	_, _, err = c.GetAlert("db/fail?x")
+	assert.Equal(t, http.StatusNotFound, err.(*Error).StatusCode)
+	assert.Equal(t, "/base/v1/alerts/db%2Ffail%3Fx", <-paths)
+
+	_, err = c.TestRouter("../alerts", "", nil, time.Second)
+	assert.Equal(t, http.StatusNotFound, err.(*Error).StatusCode)
+	assert.Equal(t, "/base/v1/routers/..%2Falerts/test", <-paths)
+}
+
+func TestClient_Fire(t *testing.T) {
+	posted := make(chan string, 2)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		posted <- string(body)
+	}))
+	defer webhook.Close()
+	c, rm, dir := newTestClient(t, webhook.URL, "")
+	defer os.RemoveAll(dir)
+	defer rm.Close()
+
+	err := c.CreateAlert(&config.AlertConfig{AlertId: "dbfail",
+		Schedule: []config.RouterParms{{Id: "all_day", RouterId: "slack-alerts"}}})
+	assert.NilError(t, err)
+
+	fire, err := c.Fire("dbfail", "db is down", true, time.Second)
+	assert.NilError(t, err)
+	assert.Equal(t, wire.OUTCOME_DELIVERED, fire.Schedules[0].Outcome)
+	assert.Equal(t, `{"text":"dbfail: db is down"}`, <-posted)
+
+	sim, err := c.Simulate("dbfail", "db is down", time.Time{})
+	assert.NilError(t, err)
+	assert.Assert(t, sim.Schedules[0].Enabled)
+
+	result, err := c.TestRouter("slack-alerts", "", nil, time.Second)
+	assert.NilError(t, err)
+	assert.Equal(t, wire.OUTCOME_DELIVERED, result.Outcome)
+	<-posted
+
+	routers, err := c.ListRouters()
+	assert.NilError(t, err)
+	assert.Equal(t, 1, len(routers))
+
+	_, err = c.Fire("nosuchalert", "", false, 0)
+	assert.Assert(t, IsNotFound(err))
+}
@@ -0,0 +1,138 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gregaland/alert-router/store"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+	"io"
+	"mime"
+)
+
+// IsYAMLContentType reports whether a request Content-Type is YAML.
+// Anything else is treated as JSON.
+func IsYAMLContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch mediaType {
+	case "application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml":
+		return true
+	}
+	return false
+}
+
+// DecodeAlertConfig reads a single alert config from a request body,
+// as YAML or JSON depending on the Content-Type.
+func DecodeAlertConfig(r io.Reader, contentType string) (*AlertConfig, error) {
+	if IsYAMLContentType(contentType) {
+		return LoadAlertConfig(r)
+	}
+	alertConfig := &AlertConfig{}
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(alertConfig)
+	if err != nil {
+		return nil, err
+	}
+	return alertConfig, nil
+}
+
+// LoadAlertConfigs reads every alert config in a stream of YAML
+// documents.  Empty documents are skipped.
+func LoadAlertConfigs(r io.Reader) ([]*AlertConfig, error) {
+	alertConfigs := make([]*AlertConfig, 0)
+	dec := yaml.NewDecoder(r)
+	dec.SetStrict(true)
+	for {
+		alertConfig := &AlertConfig{}
+		err := dec.Decode(alertConfig)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "document %d", len(alertConfigs)+1)
+		}
+		if alertConfig.AlertId == "" && len(alertConfig.Schedule) == 0 {
+			continue
+		}
+		alertConfigs = append(alertConfigs, alertConfig)
+	}
+	return alertConfigs, nil
+}
+
+// Read a sequence of JSON alert objects, or arrays of them
+func loadJSONAlertConfigs(r io.Reader) ([]*AlertConfig, error) {
+	alertConfigs := make([]*AlertConfig, 0)
+	dec := json.NewDecoder(r)
+	for {
+		var raw json.RawMessage
+		err := dec.Decode(&raw)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var docs []json.RawMessage
+		if bytes.HasPrefix(bytes.TrimSpace(raw), []byte("[")) {
+			if err = json.Unmarshal(raw, &docs); err != nil {
+				return nil, err
+			}
+		} else {
+			docs = append(docs, raw)
+		}
+		for _, doc := range docs {
+			alertConfig, err := DecodeAlertConfig(bytes.NewReader(doc), "application/json")
+			if err != nil {
+				return nil, err
+			}
+			alertConfigs = append(alertConfigs, alertConfig)
+		}
+	}
+	return alertConfigs, nil
+}
+
+// ParseAlertConfigs reads the alert configs in a stored document.
+// Documents starting with '{' or '[' hold JSON alert objects or arrays of
+// them, anything else is read as YAML and may hold several documents.
+func ParseAlertConfigs(data []byte) ([]*AlertConfig, error) {
+	trimmed := bytes.TrimSpace(data)
+	if bytes.HasPrefix(trimmed, []byte("{")) || bytes.HasPrefix(trimmed, []byte("[")) {
+		return loadJSONAlertConfigs(bytes.NewReader(data))
+	}
+	return LoadAlertConfigs(bytes.NewReader(data))
+}
+
+// LoadAlerts reads every alert config in the store.  Every record is
+// read; the error lists each record that failed to parse.
+func LoadAlerts(st store.Store) ([]*AlertConfig, error) {
+	records, err := st.List(store.KIND_ALERTS)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs ValidationErrors
+	alertConfigs := make([]*AlertConfig, 0, len(records))
+	for _, record := range records {
+		loaded, err := ParseAlertConfigs(record.Data)
+		if err != nil {
+			errs = append(errs, errors.Wrap(err, record.Id))
+			continue
+		}
+		for _, alertConfig := range loaded {
+			alertConfig.Source = record.Id
+			alertConfig.Revision = record.Revision
+			log.WithFields(log.Fields{
+				"alert_id":   alertConfig.AlertId,
+				"source":     record.Id,
+				"parameters": alertConfig.Schedule,
+			}).Info("loaded alert config")
+		}
+		alertConfigs = append(alertConfigs, loaded...)
+	}
+	return alertConfigs, errs.Err()
+}
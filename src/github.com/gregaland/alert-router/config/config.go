@@ -1,12 +1,16 @@
 package config
 
 import (
+	"github.com/gregaland/alert-router/auth"
+	"github.com/gregaland/alert-router/secrets"
+	"github.com/gregaland/alert-router/store"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
 	"io"
 	"io/ioutil"
 	"os"
+	"time"
 )
 
 type RouteProcessor string
@@ -14,14 +18,26 @@ type RouteProcessor string
 const (
 	EMAIL_RP   RouteProcessor = "email"
 	WEBHOOK_RP RouteProcessor = "webhook"
+
+	DEFAULT_SHUTDOWN_TIMEOUT time.Duration = 30 * time.Second
+	DEFAULT_PROBE_INTERVAL   time.Duration = time.Minute
+	DEFAULT_FIRE_RETENTION   time.Duration = 7 * 24 * time.Hour
 )
 
 type Email RouteProcessor
 type Webhook RouteProcessor
 
 type AlertConfig struct {
-	AlertId  string        `yaml:"alert" json:"alert"`
-	Schedule []RouterParms `yaml:"schedule" json:"schedule"`
+	AlertId  string            `yaml:"alert" json:"alert"`
+	Labels   map[string]string `yaml:"labels,omitempty" json:"labels,omitempty"`
+	Schedule []RouterParms     `yaml:"schedule" json:"schedule"`
+
+	// shared secret producers sign fire requests with
+	SigningSecret Secret `yaml:"signing_secret,omitempty" json:"signing_secret,omitempty"`
+
+	// file the alert was loaded from, if any, and its revision
+	Source   string `yaml:"-" json:"-"`
+	Revision string `yaml:"-" json:"-"`
 }
 
 type RouterParms struct {
@@ -30,13 +46,13 @@ type RouterParms struct {
 	Enabled       bool     `yaml:"enabled" json:"enabled,omitempty"`
 	SmtpHost      string   `yaml:"smtphost,omitempty" json:"smtphost,omitempty"`
 	SmtpPort      int      `yaml:"smtpport,omitempty" json:"smtpport,omitempty"`
-	SmtpAuthUser  string   `yaml:"smtpauthuser,omitempty" json:"smtpauthuser,omitempty"`
-	SmtpAuthPass  string   `yaml:"smtpauthpass,omitempty" json:"smtpauthpass,omitempty"`
+	SmtpAuthUser  Secret   `yaml:"smtpauthuser,omitempty" json:"smtpauthuser,omitempty"`
+	SmtpAuthPass  Secret   `yaml:"smtpauthpass,omitempty" json:"smtpauthpass,omitempty"`
 	EmailAddrs    []string `yaml:"email_addrs,omitempty" json:"email_addrs,omitempty"`
-	Url           string   `yaml:"url,omitempty" json:"url,omitempty"`
-	Username      string   `yaml:"username,omitempty" json:"username,omitempty"`
-	Password      string   `yaml:"password,omitempty" json:"password,omitempty"`
-	QueryParms    []string `yaml:"query_parms,omitempty" json:"query_parms,omitempty"`
+	Url           Secret   `yaml:"url,omitempty" json:"url,omitempty"`
+	Username      Secret   `yaml:"username,omitempty" json:"username,omitempty"`
+	Password      Secret   `yaml:"password,omitempty" json:"password,omitempty"`
+	QueryParms    []Secret `yaml:"query_parms,omitempty" json:"query_parms,omitempty"`
 	ScheduleStart string   `yaml:"start,omitempty" json:"start,omitempty"`
 	ScheduleEnd   string   `yaml:"end,omitempty" json:"end,omitempty"`
 }
@@ -44,47 +60,139 @@ type RouterParms struct {
 type Routers struct {
 	Type  RouteProcessor `yaml:"type"`
 	Parms RouterParms    `yaml:",inline"`
+
+	// record the router was added in through the api, if any, and its
+	// revision.  Routers of the main config have no source.
+	Source   string `yaml:"-"`
+	Revision string `yaml:"-"`
+}
+
+// Routers are enabled unless they set enabled: false
+func (r *Routers) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain Routers
+	p := plain{Parms: RouterParms{Enabled: true}}
+	if err := unmarshal(&p); err != nil {
+		return err
+	}
+	*r = Routers(p)
+	return nil
 }
 
 type RigConfig struct {
-	Listen       string     `yaml:"listen"`
-	Routers      []*Routers `yaml:"routers"`
-	AlertsPath   string     `yaml:"alerts_path"`
-	LogLevelStr  string     `yaml:"log_level"`
-	LogFormatStr string     `yaml:"log_format"`
+	Listen       string          `yaml:"listen"`
+	Routers      []*Routers      `yaml:"routers"`
+	AlertsPath   string          `yaml:"alerts_path"`
+	LogLevelStr  string          `yaml:"log_level"`
+	LogFormatStr string          `yaml:"log_format"`
+	Secrets      *secrets.Config `yaml:"secrets,omitempty"`
+	WatchAlerts  bool            `yaml:"watch_alerts,omitempty"`
+	Store        *store.Config   `yaml:"store,omitempty"`
+	ApiKeys      []*auth.Key     `yaml:"api_keys,omitempty"`
+	ApiKeysFile  string          `yaml:"api_keys_file,omitempty"`
+	SigningKeys  []*SigningKey   `yaml:"signing_keys,omitempty"`
+	TlsCertFile  string          `yaml:"tls_cert_file,omitempty"`
+	TlsKeyFile   string          `yaml:"tls_key_file,omitempty"`
+	ClientCaFile string          `yaml:"client_ca_file,omitempty"`
+
+	// refuse clients without a certificate signed by client_ca_file
+	RequireClientCert bool `yaml:"require_client_cert,omitempty"`
+	// scopes granted to client certificates
+	ClientCerts []*auth.CertIdentity `yaml:"client_certs,omitempty"`
+
+	SignatureWindowStr string `yaml:"signature_window,omitempty"`
+	ShutdownTimeoutStr string `yaml:"shutdown_timeout,omitempty"`
+
+	// check routers are reachable for readiness, at most once per
+	// probe_interval
+	ProbeRouters     bool   `yaml:"probe_routers,omitempty"`
+	ProbeIntervalStr string `yaml:"probe_interval,omitempty"`
+
+	// how long fire records are kept
+	FireRetentionStr string `yaml:"fire_retention,omitempty"`
+
+	// file the config was loaded from, if any
+	path string
 }
 
-func (rc *RigConfig) loadEnvVars() (map[string]string, error) {
+// Environment variables that supply default SMTP credentials to email
+// routers that do not set smtpauthuser/smtpauthpass.
+func (rc *RigConfig) loadEnvVars() map[string]string {
 
-	var err error
 	var envs = make(map[string]string)
 	if au, ok := os.LookupEnv("SMTP_AUTH_USER"); ok {
-		log.Debugf("SMTP_AUTH_USER: %s", au)
+		log.Debugf("SMTP_AUTH_USER: %s", Secret(au))
 		envs["SMTP_AUTH_USER"] = au
-	} else {
-		err = errors.New("SMTP_AUTH_USER must be set")
 	}
 	if ap, ok := os.LookupEnv("SMTP_AUTH_PASS"); ok {
-		log.Debugf("SMTP_AUTH_USER: %s", ap)
+		log.Debugf("SMTP_AUTH_PASS: %s", Secret(ap))
 		envs["SMTP_AUTH_PASS"] = ap
-	} else {
-		err = errors.New("SMTP_AUTH_USER must be set")
 	}
 
-	return envs, err
+	return envs
+
+}
+
+// Give email routers without credentials the ones from the environment
+func setDefaultCredentials(envs map[string]string, rs []*Routers) {
+	for _, c := range rs {
+		if EMAIL_RP == c.Type {
+			if c.Parms.SmtpAuthUser == "" {
+				c.Parms.SmtpAuthUser = Secret(envs["SMTP_AUTH_USER"])
+			}
+			if c.Parms.SmtpAuthPass == "" {
+				c.Parms.SmtpAuthPass = Secret(envs["SMTP_AUTH_PASS"])
+			}
+		}
+	}
+}
+
+// Replace secret references in the router parameters with their values
+func (rc *RigConfig) resolveSecrets() error {
+	provider, err := secrets.NewProvider(rc.Secrets)
+	if err != nil {
+		return err
+	}
+	for _, c := range rc.Routers {
+		if err = resolveParms(provider, &c.Parms); err != nil {
+			return errors.Wrapf(err, "router %s", c.Parms.Id)
+		}
+	}
+	for _, k := range rc.SigningKeys {
+		v, err := secrets.Resolve(provider, k.Secret.Reveal())
+		if err != nil {
+			return errors.Wrapf(err, "signing key %s", k.Name)
+		}
+		k.Secret = Secret(v)
+	}
+	return nil
+}
 
+func resolveParms(p secrets.Provider, rp *RouterParms) error {
+	fields := []*Secret{&rp.SmtpAuthUser, &rp.SmtpAuthPass, &rp.Url, &rp.Username, &rp.Password}
+	for i := range rp.QueryParms {
+		fields = append(fields, &rp.QueryParms[i])
+	}
+	for _, f := range fields {
+		v, err := secrets.Resolve(p, f.Reveal())
+		if err != nil {
+			return err
+		}
+		*f = Secret(v)
+	}
+	return nil
 }
+
 func LoadAlertConfig(r io.Reader) (*AlertConfig, error) {
 	data, err := ioutil.ReadAll(r)
 	if err != nil {
 		return nil, err
 	}
-	log.Debugf("received: %s", string(data))
 	alertConfig := &AlertConfig{}
-	err = yaml.Unmarshal(data, &alertConfig)
+	err = yaml.UnmarshalStrict(data, &alertConfig)
 	if err != nil {
 		return nil, err
 	}
+	log.Debugf("received: %+v", *alertConfig)
 	return alertConfig, err
 
 }
@@ -98,21 +206,48 @@ func NewRigConfig(r io.Reader) (*RigConfig, error) {
 
 	rigConfig := &RigConfig{}
 
-	err = yaml.Unmarshal(data, &rigConfig)
+	err = yaml.UnmarshalStrict(data, &rigConfig)
 	if err != nil {
 		return nil, err
 	}
-	envs, err := rigConfig.loadEnvVars()
-	if err == nil {
-		for _, c := range rigConfig.Routers {
-			if EMAIL_RP == c.Type {
-				c.Parms.SmtpAuthUser = envs["SMTP_AUTH_USER"]
-				c.Parms.SmtpAuthPass = envs["SMTP_AUTH_PASS"]
-			}
+	setDefaultCredentials(rigConfig.loadEnvVars(), rigConfig.Routers)
+
+	err = rigConfig.resolveSecrets()
+	if err != nil {
+		return nil, err
+	}
+
+	if rigConfig.ApiKeysFile != "" {
+		keys, err := auth.LoadKeys(rigConfig.ApiKeysFile)
+		if err != nil {
+			return nil, err
 		}
+		rigConfig.ApiKeys = append(rigConfig.ApiKeys, keys...)
+	}
+
+	return rigConfig, nil
+}
+
+// LoadRigConfig reads a RigConfig from a file.  The path is kept so the
+// config can be reloaded.
+func LoadRigConfig(path string) (*RigConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rigConfig, err := NewRigConfig(f)
+	if err != nil {
+		return nil, errors.Wrap(err, path)
 	}
+	rigConfig.path = path
+	return rigConfig, nil
+}
 
-	return rigConfig, err
+// Path returns the file the config was loaded from, or "" if it was not
+// loaded from a file.
+func (rc *RigConfig) Path() string {
+	return rc.path
 }
 
 func (rc *RigConfig) LogLevel() log.Level {
@@ -160,3 +295,31 @@ func (rc *RigConfig) LogFormat() log.Formatter {
 	}
 	return format
 }
+
+// ShutdownTimeout returns how long shutdown waits for deliveries in
+// progress
+func (rc *RigConfig) ShutdownTimeout() time.Duration {
+	d, err := time.ParseDuration(rc.ShutdownTimeoutStr)
+	if err != nil || d <= 0 {
+		return DEFAULT_SHUTDOWN_TIMEOUT
+	}
+	return d
+}
+
+// FireRetention returns how long fire records are kept
+func (rc *RigConfig) FireRetention() time.Duration {
+	d, err := time.ParseDuration(rc.FireRetentionStr)
+	if err != nil || d <= 0 {
+		return DEFAULT_FIRE_RETENTION
+	}
+	return d
+}
+
+// ProbeInterval returns how long router probe results are reused
+func (rc *RigConfig) ProbeInterval() time.Duration {
+	d, err := time.ParseDuration(rc.ProbeIntervalStr)
+	if err != nil || d <= 0 {
+		return DEFAULT_PROBE_INTERVAL
+	}
+	return d
+}
@@ -1,7 +1,12 @@
 package config
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v2"
 	"gotest.tools/assert"
+	"os"
 	"strings"
 	"testing"
 )
@@ -43,13 +48,13 @@ schedule:
 
 func checkRouterResult(t *testing.T, actual []*Routers) {
 	idx1Expected := Routers{Type: EMAIL_RP,
-		Parms: RouterParms{Id: "gmail", Enabled:true, SmtpHost: "smtp.gmail.com",
+		Parms: RouterParms{Id: "gmail", Enabled: true, SmtpHost: "smtp.gmail.com",
 			SmtpPort: 587}}
 	idx2Expected := Routers{Type: WEBHOOK_RP,
-		Parms: RouterParms{Id: "elastic", Enabled:true, Url: "https://elastic.rig.gregland.dev:9200",
-			Username: "elastic", Password: "rigadmin", QueryParms: []string{"token=\"foobar\""} }}
-	assert.DeepEqual(t, idx1Expected, actual[0])
-	assert.DeepEqual(t, idx2Expected, actual[1])
+		Parms: RouterParms{Id: "elastic", Enabled: true, Url: "https://elastic.rig.gregland.dev:9200",
+			Username: "elastic", Password: "rigadmin", QueryParms: []Secret{"token=\"foobar\""}}}
+	assert.DeepEqual(t, idx1Expected, *actual[0])
+	assert.DeepEqual(t, idx2Expected, *actual[1])
 }
 
 func TestNewRigConfig(t *testing.T) {
@@ -70,7 +75,7 @@ func TestRigConfig_LoadAlertConfig(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	schedule := make([]RouterParms,0)
+	schedule := make([]RouterParms, 0)
 	schedule = append(schedule, RouterParms{Id: "all_day",
 		ScheduleStart: "", ScheduleEnd: "", RouterId: "gmail",
 		EmailAddrs: []string{"9999999999@tmomail.net"}})
@@ -79,6 +84,212 @@ func TestRigConfig_LoadAlertConfig(t *testing.T) {
 		ScheduleStart: "0 17 * * *", ScheduleEnd: "0 6 * * *",
 		RouterId: "gmail", EmailAddrs: []string{"john.doe@foobar.net"}})
 
-	expected := AlertConfig{AlertId:"greg", Schedule: schedule}
+	expected := AlertConfig{AlertId: "greg", Schedule: schedule}
 	assert.DeepEqual(t, expected, *actual)
 }
+
+func TestNewRigConfig_Secrets(t *testing.T) {
+	os.Setenv("SMTP_GMAIL_PASSWORD", "hunter2")
+	os.Setenv("SLACK_ALERTS_URL", "https://hooks.slack.com/services/T000/B000/XXXX")
+	defer os.Unsetenv("SMTP_GMAIL_PASSWORD")
+	defer os.Unsetenv("SLACK_ALERTS_URL")
+
+	config, err := NewRigConfig(strings.NewReader(`
+routers:
+ - id: gmail
+   type: email
+   smtphost: smtp.gmail.com
+   smtpport: 587
+   smtpauthuser: alerts@gregland.dev
+   smtpauthpass: secret://smtp/gmail#password
+ - id: slack-alerts
+   type: webhook
+   url: secret://slack/alerts#url
+`))
+	assert.NilError(t, err)
+	assert.Equal(t, "alerts@gregland.dev", config.Routers[0].Parms.SmtpAuthUser.Reveal())
+	assert.Equal(t, "hunter2", config.Routers[0].Parms.SmtpAuthPass.Reveal())
+	assert.Equal(t, "https://hooks.slack.com/services/T000/B000/XXXX", config.Routers[1].Parms.Url.Reveal())
+
+	_, err = NewRigConfig(strings.NewReader(`
+routers:
+ - id: slack-alerts
+   type: webhook
+   url: secret://slack/missing#url
+`))
+	assert.ErrorContains(t, err, "SLACK_MISSING_URL is not set")
+}
+
+func TestSecret_Redacted(t *testing.T) {
+	rp := RouterParms{Id: "gmail", SmtpAuthPass: "hunter2", Url: "secret://slack/alerts#url"}
+
+	assert.Equal(t, REDACTED, rp.SmtpAuthPass.String())
+	assert.Equal(t, "hunter2", rp.SmtpAuthPass.Reveal())
+	for _, out := range []string{fmt.Sprintf("%v", rp), fmt.Sprintf("%+v", rp), fmt.Sprintf("%#v", rp)} {
+		assert.Assert(t, !strings.Contains(out, "hunter2"), out)
+	}
+
+	out, err := json.Marshal(rp)
+	assert.NilError(t, err)
+	assert.Equal(t, `{"id":"gmail","smtpauthpass":"******","url":"secret://slack/alerts#url"}`, string(out))
+
+	out, err = yaml.Marshal(rp)
+	assert.NilError(t, err)
+	assert.Assert(t, !strings.Contains(string(out), "hunter2"))
+	assert.Assert(t, strings.Contains(string(out), "smtpauthpass: '******'"))
+
+	// persisted configuration keeps the value
+	out, err = MarshalPlainYAML(AlertConfig{AlertId: "greg", Schedule: []RouterParms{rp}})
+	assert.NilError(t, err)
+	actual, err := LoadAlertConfig(bytes.NewReader(out))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, AlertConfig{AlertId: "greg", Schedule: []RouterParms{rp}}, *actual)
+}
+
+func TestRigConfig_Validate(t *testing.T) {
+	_, err := NewRigConfig(strings.NewReader("listen: :8000\nalert_path: etc/alerts.d\n"))
+	assert.ErrorContains(t, err, "field alert_path not found")
+	_, err = LoadAlertConfig(strings.NewReader("alert: greg\nschedule:\n  - id: all_day\n    routerid: gmail\n"))
+	assert.ErrorContains(t, err, "field routerid not found")
+
+	config, err := NewRigConfig(strings.NewReader(`
+routers:
+ - id: gmail
+   type: email
+   smtphost: smtp.gmail.com
+ - id: gmail
+   type: webhook
+ - id: pager
+   type: sms
+`))
+	assert.NilError(t, err)
+	alerts := []*AlertConfig{
+		{AlertId: "greg", Source: "greg.yml", Schedule: []RouterParms{
+			{Id: "all_day", RouterId: "gmial"},
+			{Id: "after_hours", RouterId: "gmail", ScheduleStart: "0 17 * *", ScheduleEnd: "0 6 * * * *"},
+		}},
+		{AlertId: "greg", Source: "greg2.yml", Schedule: []RouterParms{{Id: "all_day", RouterId: "gmail"}}},
+	}
+	err = config.Validate(alerts)
+	errs, ok := err.(ValidationErrors)
+	assert.Assert(t, ok)
+	assert.DeepEqual(t, []string{
+		"router gmail: smtpport is required for type email",
+		"router gmail: duplicate router id",
+		"router gmail: url is required for type webhook",
+		"router pager: unknown router type sms",
+		"alert greg (greg.yml) schedule all_day: unknown router_id gmial",
+		"alert greg (greg.yml) schedule after_hours: invalid start: expected 5 fields, found 4: 0 17 * *",
+		"alert greg (greg.yml) schedule after_hours: invalid end: expected 5 fields, found 6: 0 6 * * * *",
+		"alert greg: duplicate alert id in greg.yml and greg2.yml",
+	}, errStrings(errs))
+
+	assert.NilError(t, config.ValidateAlert(alerts[1]))
+	assert.Assert(t, ValidateCron("0 25 * * *") != nil)
+
+	err = config.ValidateAlert(&AlertConfig{AlertId: "Greg", Schedule: []RouterParms{
+		{Id: "all_day", RouterId: "gmail"},
+		{Id: "all_day", RouterId: "gmail"},
+		{Id: "../night", RouterId: "gmail"},
+	}})
+	assert.DeepEqual(t, []string{
+		"alert Greg: invalid alert id",
+		"alert Greg schedule all_day: duplicate schedule id",
+		"alert Greg schedule ../night: invalid schedule id",
+	}, errStrings(err.(ValidationErrors)))
+
+	err = config.ValidateAlert(&AlertConfig{AlertId: "greg", SigningSecret: "secret://webhooks/greg#secret",
+		Schedule: []RouterParms{{Id: "all_day", RouterId: "gmail"}}})
+	assert.Error(t, err, "alert greg: signing_secret can't be a secret reference, use signing_keys")
+
+	for _, id := range []string{"dbfail", "after_hours", "web-0.check", "_x", strings.Repeat("a", MAX_ID_LEN)} {
+		assert.Assert(t, ValidId(id), id)
+	}
+	for _, id := range []string{"", ".", "..", ".hidden", "a/b", "Greg", "a b", strings.Repeat("a", MAX_ID_LEN+1)} {
+		assert.Assert(t, !ValidId(id), id)
+	}
+}
+
+func errStrings(errs []error) []string {
+	s := make([]string, 0, len(errs))
+	for _, err := range errs {
+		s = append(s, err.Error())
+	}
+	return s
+}
+
+func TestParseAlertConfigs(t *testing.T) {
+	ids := func(alerts []*AlertConfig) []string {
+		s := make([]string, 0, len(alerts))
+		for _, ac := range alerts {
+			s = append(s, ac.AlertId)
+		}
+		return s
+	}
+
+	alerts, err := ParseAlertConfigs([]byte("alert: dbfail\nschedule:\n  - id: all_day\n    router_id: gmail\n---\nalert: dbslow\n---\n"))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, []string{"dbfail", "dbslow"}, ids(alerts))
+
+	alerts, err = ParseAlertConfigs([]byte(`[{"alert": "diskfull", "schedule": [{"id": "all_day", "router_id": "gmail"}]}, {"alert": "cpu"}]`))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, []string{"diskfull", "cpu"}, ids(alerts))
+
+	alerts, err = ParseAlertConfigs([]byte(` {"alert": "web5xx"}`))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, []string{"web5xx"}, ids(alerts))
+
+	_, err = ParseAlertConfigs([]byte(`{"alert": "x", "schedul": []}`))
+	assert.ErrorContains(t, err, "unknown field")
+	_, err = ParseAlertConfigs([]byte("alert: x\n---\nalert: [\n"))
+	assert.ErrorContains(t, err, "document 2")
+}
+
+func TestDecodeAlertConfig(t *testing.T) {
+	ac, err := DecodeAlertConfig(strings.NewReader(alertData), "application/x-yaml; charset=utf-8")
+	assert.NilError(t, err)
+	assert.Equal(t, "greg", ac.AlertId)
+
+	ac, err = DecodeAlertConfig(strings.NewReader(`{"alert": "greg"}`), "application/x-www-form-urlencoded")
+	assert.NilError(t, err)
+	assert.Equal(t, "greg", ac.AlertId)
+
+	_, err = DecodeAlertConfig(strings.NewReader(alertData), "application/json")
+	assert.Assert(t, err != nil)
+}
+
+func TestDecodeRouter(t *testing.T) {
+	r, err := DecodeRouter(strings.NewReader(`{"type": "webhook", "url": "https://hooks.example.com/T1"}`))
+	assert.NilError(t, err)
+	assert.Equal(t, WEBHOOK_RP, r.Type)
+	assert.Equal(t, "https://hooks.example.com/T1", r.Parms.Url.Reveal())
+	// routers are enabled unless they say otherwise
+	assert.Assert(t, r.Parms.Enabled)
+
+	r, err = DecodeRouter(strings.NewReader("type: email\nenabled: false\nsmtphost: localhost\nsmtpport: 25\n"))
+	assert.NilError(t, err)
+	assert.Assert(t, !r.Parms.Enabled)
+
+	_, err = DecodeRouter(strings.NewReader(`{"type": "webhook", "uri": "https://hooks.example.com/T1"}`))
+	assert.ErrorContains(t, err, "not found")
+}
+
+func TestRigConfig_WithRouters(t *testing.T) {
+	os.Setenv("SMTP_AUTH_USER", "env-user")
+	defer os.Unsetenv("SMTP_AUTH_USER")
+	config, err := NewRigConfig(strings.NewReader(data))
+	assert.NilError(t, err)
+
+	same, err := config.WithRouters(nil)
+	assert.NilError(t, err)
+	assert.Assert(t, same == config)
+
+	added := &Routers{Type: EMAIL_RP, Parms: RouterParms{Id: "relay", SmtpHost: "relay", SmtpPort: 25}}
+	merged, err := config.WithRouters([]*Routers{added})
+	assert.NilError(t, err)
+	assert.Equal(t, len(config.Routers)+1, len(merged.Routers))
+	assert.Equal(t, "relay", merged.Routers[len(config.Routers)].Parms.Id)
+	assert.Equal(t, "env-user", merged.Routers[len(config.Routers)].Parms.SmtpAuthUser.Reveal())
+	// the router passed in is not changed
+	assert.Equal(t, Secret(""), added.Parms.SmtpAuthUser)
+}
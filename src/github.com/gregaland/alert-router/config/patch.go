@@ -0,0 +1,90 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// PatchRouterParms applies a JSON merge patch (RFC 7396) to rp.  Secrets
+// the patch leaves alone keep their values.
+func PatchRouterParms(rp RouterParms, patch []byte) (RouterParms, error) {
+	var p interface{}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return rp, err
+	}
+	if _, ok := p.(map[string]interface{}); !ok {
+		return rp, errors.New("merge patch must be a JSON object")
+	}
+
+	// the document is patched in its plain form; the JSON form masks
+	// secrets
+	data, err := MarshalPlainYAML(rp)
+	if err != nil {
+		return rp, err
+	}
+	var target interface{}
+	if err = yaml.Unmarshal(data, &target); err != nil {
+		return rp, err
+	}
+	data, err = json.Marshal(mergePatch(jsonValue(target), p))
+	if err != nil {
+		return rp, err
+	}
+
+	patched := RouterParms{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err = dec.Decode(&patched); err != nil {
+		return rp, err
+	}
+	return patched, nil
+}
+
+// Merge patch into target as described in RFC 7396
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = make(map[string]interface{})
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+		} else {
+			t[k] = mergePatch(t[k], v)
+		}
+	}
+	return t
+}
+
+// YAMLToJSON converts a YAML document to JSON
+func YAMLToJSON(data []byte) ([]byte, error) {
+	var v interface{}
+	if err := yaml.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonValue(v))
+}
+
+// Convert generic yaml values to ones encoding/json can marshal
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = jsonValue(e)
+		}
+		return m
+	case []interface{}:
+		for i, e := range v {
+			v[i] = jsonValue(e)
+		}
+	}
+	return v
+}
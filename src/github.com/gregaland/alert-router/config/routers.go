@@ -0,0 +1,55 @@
+package config
+
+import (
+	"github.com/gregaland/alert-router/secrets"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+	"io"
+	"io/ioutil"
+)
+
+// DecodeRouter reads a single router entry from a request body.  JSON
+// bodies are read as YAML, which they also are, so the router's
+// parameters sit next to its type as in the main config.
+func DecodeRouter(r io.Reader) (*Routers, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	router := &Routers{}
+	if err = yaml.UnmarshalStrict(data, router); err != nil {
+		return nil, err
+	}
+	return router, nil
+}
+
+// WithRouters returns a copy of the config that also has the routers in
+// extra, such as those added through the api.  The extra routers are
+// copied, then given default credentials and their secrets resolved like
+// the routers of the main config.  Returns rc itself if extra is empty.
+func (rc *RigConfig) WithRouters(extra []*Routers) (*RigConfig, error) {
+	if len(extra) == 0 {
+		return rc, nil
+	}
+	provider, err := secrets.NewProvider(rc.Secrets)
+	if err != nil {
+		return nil, err
+	}
+	added := make([]*Routers, 0, len(extra))
+	for _, r := range extra {
+		c := *r
+		c.Parms.EmailAddrs = append([]string(nil), r.Parms.EmailAddrs...)
+		c.Parms.QueryParms = append([]Secret(nil), r.Parms.QueryParms...)
+		added = append(added, &c)
+	}
+	setDefaultCredentials(rc.loadEnvVars(), added)
+	for _, c := range added {
+		if err = resolveParms(provider, &c.Parms); err != nil {
+			return nil, errors.Wrapf(err, "router %s", c.Parms.Id)
+		}
+	}
+
+	merged := *rc
+	merged.Routers = append(append(make([]*Routers, 0, len(rc.Routers)+len(added)), rc.Routers...), added...)
+	return &merged, nil
+}
@@ -0,0 +1,226 @@
+package config
+
+import (
+	"encoding/json"
+	"github.com/gregaland/alert-router/secrets"
+	"gopkg.in/yaml.v2"
+	"reflect"
+	"strings"
+)
+
+const REDACTED string = "******"
+
+// Secret holds a credential.  It masks itself when formatted, logged or
+// marshaled to JSON or YAML.  Secret references (secret://...) are not
+// sensitive and are shown as is.
+type Secret string
+
+var secretType = reflect.TypeOf(Secret(""))
+
+// Reveal returns the secret value
+func (s Secret) Reveal() string {
+	return string(s)
+}
+
+func (s Secret) String() string {
+	if s == "" || secrets.IsRef(string(s)) {
+		return string(s)
+	}
+	return REDACTED
+}
+
+// Redacted reports whether s is the masked form of a secret, as sent back
+// by a client that read it
+func (s Secret) Redacted() bool {
+	return string(s) == REDACTED
+}
+
+func (s Secret) GoString() string {
+	return `"` + s.String() + `"`
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+func (s Secret) MarshalYAML() (interface{}, error) {
+	return s.String(), nil
+}
+
+// MarshalPlainYAML marshals v like yaml.Marshal but writes Secret values
+// in the clear.  Only use it to persist configuration.
+func MarshalPlainYAML(v interface{}) ([]byte, error) {
+	return yaml.Marshal(plain(reflect.ValueOf(v)))
+}
+
+// Convert v to generic yaml values with secrets revealed
+func plain(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	if v.Type() == secretType {
+		return v.String()
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return plain(v.Elem())
+	case reflect.Struct:
+		if _, ok := v.Interface().(yaml.Marshaler); ok {
+			return v.Interface()
+		}
+		ms := yaml.MapSlice{}
+		plainFields(v, &ms)
+		return ms
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+		out := make([]interface{}, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			out[i] = plain(v.Index(i))
+		}
+		return out
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		out := make(map[interface{}]interface{}, v.Len())
+		for _, k := range v.MapKeys() {
+			out[k.Interface()] = plain(v.MapIndex(k))
+		}
+		return out
+	}
+	return v.Interface()
+}
+
+// Append the fields of struct v to ms honoring the yaml tag options
+func plainFields(v reflect.Value, ms *yaml.MapSlice) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		tag := f.Tag.Get("yaml")
+		if tag == "-" {
+			continue
+		}
+		opts := strings.Split(tag, ",")
+		name := opts[0]
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		omitEmpty, inline := false, false
+		for _, o := range opts[1:] {
+			switch o {
+			case "omitempty":
+				omitEmpty = true
+			case "inline":
+				inline = true
+			}
+		}
+		fv := v.Field(i)
+		if inline {
+			plainFields(reflect.Indirect(fv), ms)
+			continue
+		}
+		if omitEmpty && isEmpty(fv) {
+			continue
+		}
+		*ms = append(*ms, yaml.MapItem{Key: name, Value: plain(fv)})
+	}
+}
+
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	case reflect.Struct:
+		return reflect.DeepEqual(v.Interface(), reflect.Zero(v.Type()).Interface())
+	}
+	return false
+}
+
+// KeepSecrets puts back the values of the secrets in ac that were sent as
+// REDACTED, as they are when an alert is read and written back.  Schedules
+// are matched to those of current by id.
+func (ac *AlertConfig) KeepSecrets(current *AlertConfig) {
+	if ac.SigningSecret.Redacted() {
+		ac.SigningSecret = current.SigningSecret
+	}
+	for i := range ac.Schedule {
+		for j := range current.Schedule {
+			if ac.Schedule[i].Id == current.Schedule[j].Id {
+				ac.Schedule[i].KeepSecrets(&current.Schedule[j])
+				break
+			}
+		}
+	}
+}
+
+// KeepSecrets puts back the values of the secrets in r that were sent as
+// REDACTED
+func (r *Routers) KeepSecrets(current *Routers) {
+	r.Parms.KeepSecrets(&current.Parms)
+}
+
+// KeepSecrets puts back the values of the secrets in rp that were sent as
+// REDACTED.  Query parameters are matched by position.
+func (rp *RouterParms) KeepSecrets(current *RouterParms) {
+	keepSecrets(reflect.ValueOf(rp).Elem(), reflect.ValueOf(current).Elem())
+}
+
+func keepSecrets(v, current reflect.Value) {
+	for i := 0; i < v.NumField(); i++ {
+		f, cf := v.Field(i), current.Field(i)
+		switch {
+		case f.Type() == secretType:
+			if f.String() == REDACTED {
+				f.Set(cf)
+			}
+		case f.Kind() == reflect.Slice && f.Type().Elem() == secretType:
+			for j := 0; j < f.Len() && j < cf.Len(); j++ {
+				if f.Index(j).String() == REDACTED {
+					f.Index(j).Set(cf.Index(j))
+				}
+			}
+		}
+	}
+}
+
+// The yaml names of the secrets in struct v that are REDACTED
+func redactedFields(v interface{}) []string {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	fields := make([]string, 0)
+	for i := 0; i < rv.NumField(); i++ {
+		f := rv.Field(i)
+		name := strings.Split(rv.Type().Field(i).Tag.Get("yaml"), ",")[0]
+		switch {
+		case f.Type() == secretType:
+			if f.String() == REDACTED {
+				fields = append(fields, name)
+			}
+		case f.Kind() == reflect.Slice && f.Type().Elem() == secretType:
+			for j := 0; j < f.Len(); j++ {
+				if f.Index(j).String() == REDACTED {
+					fields = append(fields, name)
+					break
+				}
+			}
+		}
+	}
+	return fields
+}
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"path"
+	"time"
+)
+
+// SigningKey is a shared secret a producer signs fire requests with.  It
+// applies to the alerts matching one of the Alerts globs, or to every
+// alert if there are none.
+type SigningKey struct {
+	Name   string   `yaml:"name"`
+	Secret Secret   `yaml:"secret"`
+	Alerts []string `yaml:"alerts,omitempty"`
+}
+
+// SigningSecret is a secret that can sign fire requests for an alert and
+// the name it is known by
+type SigningSecret struct {
+	Name   string
+	Secret Secret
+}
+
+// SigningSecrets returns the secrets that can sign fire requests for
+// alertId: the alert's own, if ac is not nil and has one, then the
+// matching signing keys.
+func (rc *RigConfig) SigningSecrets(alertId string, ac *AlertConfig) []SigningSecret {
+	result := make([]SigningSecret, 0)
+	if ac != nil && ac.SigningSecret != "" {
+		result = append(result, SigningSecret{Name: "alert:" + alertId, Secret: ac.SigningSecret})
+	}
+	for _, k := range rc.SigningKeys {
+		match := len(k.Alerts) == 0
+		for _, glob := range k.Alerts {
+			if ok, _ := path.Match(glob, alertId); ok {
+				match = true
+			}
+		}
+		if match {
+			result = append(result, SigningSecret{Name: k.Name, Secret: k.Secret})
+		}
+	}
+	return result
+}
+
+// SignatureWindow returns how far a signed request's timestamp may be
+// from now.  Zero selects the default.
+func (rc *RigConfig) SignatureWindow() time.Duration {
+	d, _ := time.ParseDuration(rc.SignatureWindowStr)
+	return d
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/pkg/errors"
+	"io/ioutil"
+)
+
+// TLSEnabled reports whether the API is served over HTTPS
+func (rc *RigConfig) TLSEnabled() bool {
+	return rc.TlsCertFile != ""
+}
+
+// LoadTLS reads the server certificate and the pool of client CAs, which
+// is nil without client_ca_file
+func (rc *RigConfig) LoadTLS() (*tls.Certificate, *x509.CertPool, error) {
+	cert, err := tls.LoadX509KeyPair(rc.TlsCertFile, rc.TlsKeyFile)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "tls_cert_file")
+	}
+	if rc.ClientCaFile == "" {
+		return &cert, nil, nil
+	}
+	pem, err := ioutil.ReadFile(rc.ClientCaFile)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "client_ca_file")
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, nil, errors.Errorf("client_ca_file: no certificates in %s", rc.ClientCaFile)
+	}
+	return &cert, pool, nil
+}
+
+// Check the TLS settings fit together and the files load
+func (rc *RigConfig) validateTLS() ValidationErrors {
+	var errs ValidationErrors
+	if (rc.TlsCertFile == "") != (rc.TlsKeyFile == "") {
+		errs = append(errs, errors.New("tls_cert_file and tls_key_file must be set together"))
+	}
+	if !rc.TLSEnabled() {
+		if rc.ClientCaFile != "" || rc.RequireClientCert || len(rc.ClientCerts) > 0 {
+			errs = append(errs, errors.New("client certificates need tls_cert_file"))
+		}
+		return errs
+	}
+	if rc.RequireClientCert && rc.ClientCaFile == "" {
+		errs = append(errs, errors.New("require_client_cert needs client_ca_file"))
+	}
+	if len(rc.ClientCerts) > 0 && rc.ClientCaFile == "" {
+		errs = append(errs, errors.New("client_certs needs client_ca_file"))
+	}
+	for _, c := range rc.ClientCerts {
+		if err := c.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == 0 && rc.TlsKeyFile != "" {
+		if _, _, err := rc.LoadTLS(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
@@ -0,0 +1,246 @@
+package config
+
+import (
+	"fmt"
+	"github.com/gregaland/alert-router/secrets"
+	"github.com/pkg/errors"
+	"github.com/robfig/cron"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Longest alert, router or schedule id
+const MAX_ID_LEN int = 128
+
+// Alert, router and schedule ids are lower case letters, digits, '.', '_'
+// and '-', not starting with '.'.  Alerts and routers added through the
+// api are stored under their id, so it has to make a safe file name.
+var idPattern = regexp.MustCompile(`^[a-z0-9_-][a-z0-9._-]*$`)
+
+// ValidId reports whether id is a valid alert, router or schedule id
+func ValidId(id string) bool {
+	return len(id) <= MAX_ID_LEN && idPattern.MatchString(id)
+}
+
+// ValidationErrors collects every problem found in a configuration
+type ValidationErrors []error
+
+func (ve ValidationErrors) Error() string {
+	msgs := make([]string, 0, len(ve))
+	for _, err := range ve {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Err returns nil if there are no errors
+func (ve ValidationErrors) Err() error {
+	if len(ve) == 0 {
+		return nil
+	}
+	return ve
+}
+
+// Validate checks the main config and the alert configs that will run
+// with it.  It returns ValidationErrors listing every problem found.
+func (rc *RigConfig) Validate(alertConfigs []*AlertConfig) error {
+	var errs ValidationErrors
+
+	ids := make(map[string]bool)
+	for i, r := range rc.Routers {
+		if r.Parms.Id == "" {
+			errs = append(errs, errors.Errorf("router %d: id is required", i+1))
+		} else if !ValidId(r.Parms.Id) {
+			errs = append(errs, errors.Errorf("router %s: invalid router id", r.Parms.Id))
+		} else if ids[r.Parms.Id] {
+			errs = append(errs, errors.Errorf("router %s: duplicate router id", r.Parms.Id))
+		}
+		ids[r.Parms.Id] = true
+		errs = append(errs, r.validate()...)
+	}
+
+	names := make(map[string]bool)
+	for _, k := range rc.ApiKeys {
+		if err := k.Validate(); err != nil {
+			errs = append(errs, errors.Wrap(err, "api key"))
+		} else if names[k.Name] {
+			errs = append(errs, errors.Errorf("api key %s: duplicate name", k.Name))
+		}
+		names[k.Name] = true
+	}
+
+	names = make(map[string]bool)
+	for _, k := range rc.SigningKeys {
+		if k.Name == "" {
+			errs = append(errs, errors.New("signing key: name is required"))
+		} else if names[k.Name] {
+			errs = append(errs, errors.Errorf("signing key %s: duplicate name", k.Name))
+		}
+		names[k.Name] = true
+		if k.Secret == "" {
+			errs = append(errs, errors.Errorf("signing key %s: secret is required", k.Name))
+		}
+		for _, glob := range k.Alerts {
+			if _, err := path.Match(glob, ""); err != nil {
+				errs = append(errs, errors.Errorf("signing key %s: invalid alerts glob %s", k.Name, glob))
+			}
+		}
+	}
+	errs = append(errs, rc.validateTLS()...)
+	if rc.ShutdownTimeoutStr != "" {
+		if d, err := time.ParseDuration(rc.ShutdownTimeoutStr); err != nil || d <= 0 {
+			errs = append(errs, errors.Errorf("invalid shutdown_timeout %s", rc.ShutdownTimeoutStr))
+		}
+	}
+	if rc.FireRetentionStr != "" {
+		if d, err := time.ParseDuration(rc.FireRetentionStr); err != nil || d <= 0 {
+			errs = append(errs, errors.Errorf("invalid fire_retention %s", rc.FireRetentionStr))
+		}
+	}
+	if rc.ProbeIntervalStr != "" {
+		if d, err := time.ParseDuration(rc.ProbeIntervalStr); err != nil || d <= 0 {
+			errs = append(errs, errors.Errorf("invalid probe_interval %s", rc.ProbeIntervalStr))
+		}
+	}
+	if rc.SignatureWindowStr != "" {
+		if d, err := time.ParseDuration(rc.SignatureWindowStr); err != nil || d <= 0 {
+			errs = append(errs, errors.Errorf("invalid signature_window %s", rc.SignatureWindowStr))
+		}
+	}
+
+	sources := make(map[string]string)
+	for _, ac := range alertConfigs {
+		if src, ok := sources[ac.AlertId]; ok && ac.AlertId != "" {
+			errs = append(errs, errors.Errorf("alert %s: duplicate alert id in %s and %s", ac.AlertId, src, ac.Source))
+		}
+		sources[ac.AlertId] = ac.Source
+		errs = append(errs, ac.validate(ids)...)
+	}
+
+	return errs.Err()
+}
+
+// ValidateAlert checks a single alert config against the configured routers
+func (rc *RigConfig) ValidateAlert(ac *AlertConfig) error {
+	ids := make(map[string]bool)
+	for _, r := range rc.Routers {
+		ids[r.Parms.Id] = true
+	}
+	return ac.validate(ids).Err()
+}
+
+// IdError reports an alert or schedule id that the id grammar rejects, or
+// a duplicate schedule id.  Versions before the grammar accepted these, so
+// alerts written then may have them.
+func (ac *AlertConfig) IdError() error {
+	if ac.AlertId != "" && !ValidId(ac.AlertId) {
+		return errors.New("invalid alert id")
+	}
+	scheduleIds := make(map[string]bool)
+	for _, s := range ac.Schedule {
+		if s.Id == "" {
+			continue
+		}
+		if !ValidId(s.Id) {
+			return errors.Errorf("invalid schedule id %s", s.Id)
+		}
+		if scheduleIds[s.Id] {
+			return errors.Errorf("duplicate schedule id %s", s.Id)
+		}
+		scheduleIds[s.Id] = true
+	}
+	return nil
+}
+
+// Check the required fields for the router type
+func (r *Routers) validate() ValidationErrors {
+	var errs ValidationErrors
+	missing := func(field string) {
+		errs = append(errs, errors.Errorf("router %s: %s is required for type %s", r.Parms.Id, field, r.Type))
+	}
+	for _, f := range redactedFields(&r.Parms) {
+		errs = append(errs, errors.Errorf("router %s: %s is redacted", r.Parms.Id, f))
+	}
+	switch r.Type {
+	case EMAIL_RP:
+		if r.Parms.SmtpHost == "" {
+			missing("smtphost")
+		}
+		if r.Parms.SmtpPort == 0 {
+			missing("smtpport")
+		}
+	case WEBHOOK_RP:
+		if r.Parms.Url == "" {
+			missing("url")
+		}
+	case "":
+		errs = append(errs, errors.Errorf("router %s: type is required", r.Parms.Id))
+	default:
+		errs = append(errs, errors.Errorf("router %s: unknown router type %s", r.Parms.Id, r.Type))
+	}
+	return errs
+}
+
+// Check the alert and schedule ids and that the schedules reference known
+// routers and have valid cron specs
+func (ac *AlertConfig) validate(routerIds map[string]bool) ValidationErrors {
+	var errs ValidationErrors
+	name := ac.AlertId
+	if ac.Source != "" {
+		name = strings.TrimSpace(name + " (" + ac.Source + ")")
+	}
+	if ac.AlertId == "" {
+		errs = append(errs, errors.Errorf("alert %s: alert is required", name))
+	} else if !ValidId(ac.AlertId) {
+		errs = append(errs, errors.Errorf("alert %s: invalid alert id", name))
+	}
+	if ac.SigningSecret.Redacted() {
+		errs = append(errs, errors.Errorf("alert %s: signing_secret is redacted", name))
+	} else if secrets.IsRef(ac.SigningSecret.Reveal()) {
+		// alerts aren't resolved through the secrets provider; a reference
+		// would be used as the key itself
+		errs = append(errs, errors.Errorf("alert %s: signing_secret can't be a secret reference, use signing_keys", name))
+	}
+	scheduleIds := make(map[string]bool)
+	for i, s := range ac.Schedule {
+		sname := s.Id
+		if sname == "" {
+			sname = fmt.Sprintf("#%d", i+1)
+		} else if !ValidId(s.Id) {
+			errs = append(errs, errors.Errorf("alert %s schedule %s: invalid schedule id", name, sname))
+		} else if scheduleIds[s.Id] {
+			errs = append(errs, errors.Errorf("alert %s schedule %s: duplicate schedule id", name, sname))
+		}
+		scheduleIds[s.Id] = true
+		for _, f := range redactedFields(&s) {
+			errs = append(errs, errors.Errorf("alert %s schedule %s: %s is redacted", name, sname, f))
+		}
+		if s.RouterId == "" {
+			errs = append(errs, errors.Errorf("alert %s schedule %s: router_id is required", name, sname))
+		} else if !routerIds[s.RouterId] {
+			errs = append(errs, errors.Errorf("alert %s schedule %s: unknown router_id %s", name, sname, s.RouterId))
+		}
+		if err := ValidateCron(s.ScheduleStart); err != nil {
+			errs = append(errs, errors.Errorf("alert %s schedule %s: invalid start: %v", name, sname, err))
+		}
+		if err := ValidateCron(s.ScheduleEnd); err != nil {
+			errs = append(errs, errors.Errorf("alert %s schedule %s: invalid end: %v", name, sname, err))
+		}
+	}
+	return errs
+}
+
+// ValidateCron checks a five field start/end cron spec.  An empty spec is
+// valid.
+func ValidateCron(spec string) error {
+	if spec == "" {
+		return nil
+	}
+	if len(strings.Fields(spec)) != 5 {
+		return errors.Errorf("expected 5 fields, found %d: %s", len(strings.Fields(spec)), spec)
+	}
+	_, err := cron.Parse("0 " + spec)
+	return err
+}
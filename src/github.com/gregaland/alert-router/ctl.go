@@ -0,0 +1,324 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/gregaland/alert-router/client"
+	"github.com/gregaland/alert-router/routemgr"
+	"github.com/gregaland/alert-router/wire"
+	log "github.com/sirupsen/logrus"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const ctlUsage = `usage: alert-router ctl [-config file] <command> [flags] [args]
+
+Commands:
+  fire [-wait] [-timeout 30s] [-json] <alert> <message>
+  alerts list [-router id] [-label key=value] [-enabled true|false] [-json]
+  alerts get <alert>
+  alerts apply -f <file or dir> [-prune] [-dry-run] [-json]
+  alerts delete <alert>
+  simulate [-t time] [-m message] [-json] <alert>
+  routers list [-json]
+  routers test [-m message] [-to addrs] [-timeout 30s] [-json] <router>
+
+The server url, api key and signing secret are read from the config file,
+~/.alert-router/client.yml by default, and the ALERT_ROUTER_URL,
+ALERT_ROUTER_API_KEY and ALERT_ROUTER_SIGNING_SECRET environment variables.
+`
+
+// ctl subcommand.  Calls the API of a running server.
+func ctl(args []string) int {
+	fs := flag.NewFlagSet("ctl", flag.ExitOnError)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, ctlUsage) }
+	configFile := fs.String("config", client.DefaultConfigPath(), "Path to the client config file")
+	_ = fs.Parse(args)
+	log.SetLevel(log.WarnLevel)
+	args = fs.Args()
+	if len(args) == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	c, err := client.LoadConfig(*configFile)
+	if err == nil {
+		var cl *client.Client
+		if cl, err = client.New(c); err == nil {
+			return ctlCommand(cl, args)
+		}
+	}
+	fmt.Fprintln(os.Stderr, err)
+	return 1
+}
+
+func ctlCommand(cl *client.Client, args []string) int {
+	command := args[0]
+	if (command == "alerts" || command == "routers") && len(args) > 1 {
+		command += " " + args[1]
+		args = args[1:]
+	}
+	switch command {
+	case "fire":
+		return ctlFire(cl, args[1:])
+	case "alerts list":
+		return ctlListAlerts(cl, args[1:])
+	case "alerts get":
+		return ctlGetAlert(cl, args[1:])
+	case "alerts apply":
+		return ctlApply(cl, args[1:])
+	case "alerts delete":
+		return ctlDeleteAlert(cl, args[1:])
+	case "simulate":
+		return ctlSimulate(cl, args[1:])
+	case "routers list":
+		return ctlListRouters(cl, args[1:])
+	case "routers test":
+		return ctlTestRouter(cl, args[1:])
+	}
+	fmt.Fprintf(os.Stderr, "unknown command: %s\n\n%s", command, ctlUsage)
+	return 2
+}
+
+// Print v as indented JSON
+func printJSON(v interface{}) int {
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println(string(out))
+	return 0
+}
+
+// Print an error and return the exit code for it
+func ctlError(err error) int {
+	fmt.Fprintln(os.Stderr, err)
+	return 1
+}
+
+func ctlFire(cl *client.Client, args []string) int {
+	fs := flag.NewFlagSet("fire", flag.ExitOnError)
+	wait := fs.Bool("wait", false, "Wait for the routers and report each schedule's outcome")
+	timeout := fs.Duration("timeout", 30*time.Second, "How long to wait")
+	asJson := fs.Bool("json", false, "Print the fire as JSON")
+	_ = fs.Parse(args)
+	if fs.NArg() < 2 {
+		fmt.Fprintln(os.Stderr, "usage: alert-router ctl fire [-wait] [-timeout 30s] [-json] <alert> <message>")
+		return 2
+	}
+
+	fire, err := cl.Fire(fs.Arg(0), strings.Join(fs.Args()[1:], " "), *wait, *timeout)
+	if err != nil {
+		return ctlError(err)
+	}
+	if *asJson {
+		printJSON(fire)
+	} else {
+		fmt.Printf("fire %s\n", fire.Id)
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		for _, s := range fire.Schedules {
+			detail := s.Reason + s.Error
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", s.ScheduleId, s.RouterId, s.Outcome, detail)
+		}
+		w.Flush()
+	}
+	delivered := !*wait
+	for _, s := range fire.Schedules {
+		if s.Outcome == wire.OUTCOME_FAILED || s.Outcome == wire.OUTCOME_PENDING {
+			return 1
+		}
+		delivered = delivered || s.Outcome == wire.OUTCOME_DELIVERED
+	}
+	if !delivered {
+		return 1
+	}
+	return 0
+}
+
+func ctlListAlerts(cl *client.Client, args []string) int {
+	fs := flag.NewFlagSet("alerts list", flag.ExitOnError)
+	routerId := fs.String("router", "", "Only alerts with a schedule routed to this router")
+	label := fs.String("label", "", "Only alerts with this label, key or key=value")
+	enabled := fs.String("enabled", "", "true or false: only alerts with or without a schedule enabled now")
+	asJson := fs.Bool("json", false, "Print the alerts as JSON")
+	_ = fs.Parse(args)
+
+	query := url.Values{}
+	for k, v := range map[string]string{"router_id": *routerId, "label": *label, "enabled": *enabled} {
+		if v != "" {
+			query.Set(k, v)
+		}
+	}
+	alerts, err := cl.ListAlerts(query)
+	if err != nil {
+		return ctlError(err)
+	}
+	if *asJson {
+		return printJSON(alerts)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ALERT\tSCHEDULES\tENABLED NOW\tNEXT TRANSITION")
+	for _, as := range alerts {
+		enabledNow := make([]string, 0)
+		var next *time.Time
+		for _, s := range as.Status {
+			if s.EnabledNow {
+				enabledNow = append(enabledNow, s.Id)
+			}
+			if s.NextTransition != nil && (next == nil || s.NextTransition.Before(*next)) {
+				next = s.NextTransition
+			}
+		}
+		nextStr := "-"
+		if next != nil {
+			nextStr = next.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", as.AlertId, len(as.Status), strings.Join(enabledNow, ","), nextStr)
+	}
+	w.Flush()
+	return 0
+}
+
+func ctlGetAlert(cl *client.Client, args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: alert-router ctl alerts get <alert>")
+		return 2
+	}
+	as, _, err := cl.GetAlert(args[0])
+	if err != nil {
+		return ctlError(err)
+	}
+	return printJSON(as)
+}
+
+func ctlApply(cl *client.Client, args []string) int {
+	fs := flag.NewFlagSet("alerts apply", flag.ExitOnError)
+	path := fs.String("f", "", "Alert file, or directory of alert files")
+	prune := fs.Bool("prune", false, "Delete alerts that are not in the files")
+	dryRun := fs.Bool("dry-run", false, "Only print the changes")
+	asJson := fs.Bool("json", false, "Print the changes as JSON")
+	_ = fs.Parse(args)
+	if *path == "" {
+		fmt.Fprintln(os.Stderr, "usage: alert-router ctl alerts apply -f <file or dir> [-prune] [-dry-run] [-json]")
+		return 2
+	}
+
+	alertConfigs, err := client.ReadAlertConfigs(*path)
+	if err != nil {
+		return ctlError(err)
+	}
+	result, err := cl.Apply(alertConfigs, *prune, *dryRun)
+	if *asJson {
+		printJSON(result)
+	} else {
+		for _, change := range result.Changes {
+			fmt.Printf("%s %s\n", change.Action, change.AlertId)
+		}
+	}
+	if err != nil {
+		return ctlError(err)
+	}
+	return 0
+}
+
+func ctlDeleteAlert(cl *client.Client, args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: alert-router ctl alerts delete <alert>")
+		return 2
+	}
+	if err := cl.DeleteAlert(args[0], ""); err != nil {
+		return ctlError(err)
+	}
+	return 0
+}
+
+func ctlSimulate(cl *client.Client, args []string) int {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	atStr := fs.String("t", "", "Instant to simulate, RFC 3339 (default now)")
+	msg := fs.String("m", "simulated alert", "Message to render")
+	asJson := fs.Bool("json", false, "Print the result as JSON")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: alert-router ctl simulate [-t time] [-m message] [-json] <alert>")
+		return 2
+	}
+	var at time.Time
+	if *atStr != "" {
+		var err error
+		if at, err = time.Parse(time.RFC3339, *atStr); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+
+	sim, err := cl.Simulate(fs.Arg(0), *msg, at)
+	if err != nil {
+		return ctlError(err)
+	}
+	if *asJson {
+		return printJSON(sim)
+	}
+	printSimulation(sim)
+	return 0
+}
+
+func ctlListRouters(cl *client.Client, args []string) int {
+	fs := flag.NewFlagSet("routers list", flag.ExitOnError)
+	asJson := fs.Bool("json", false, "Print the routers as JSON")
+	_ = fs.Parse(args)
+
+	routers, err := cl.ListRouters()
+	if err != nil {
+		return ctlError(err)
+	}
+	if *asJson {
+		return printJSON(routers)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ROUTER\tTYPE\tENABLED\tORIGIN\tLAST ERROR")
+	for _, r := range routers {
+		fmt.Fprintf(w, "%s\t%s\t%t\t%s\t%s\n", r.RouterId, r.Type, r.Enabled, r.Origin, r.Health.LastError)
+	}
+	w.Flush()
+	return 0
+}
+
+func ctlTestRouter(cl *client.Client, args []string) int {
+	fs := flag.NewFlagSet("routers test", flag.ExitOnError)
+	msg := fs.String("m", "", "Message to send (default \""+routemgr.TEST_MESSAGE+"\")")
+	to := fs.String("to", "", "Comma separated addresses, required for email routers")
+	timeout := fs.Duration("timeout", 30*time.Second, "How long to wait for the router")
+	asJson := fs.Bool("json", false, "Print the result as JSON")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: alert-router ctl routers test [-m message] [-to addrs] [-timeout 30s] [-json] <router>")
+		return 2
+	}
+	var addrs []string
+	if *to != "" {
+		addrs = strings.Split(*to, ",")
+	}
+
+	result, err := cl.TestRouter(fs.Arg(0), *msg, addrs, *timeout)
+	if err != nil {
+		return ctlError(err)
+	}
+	if *asJson {
+		printJSON(result)
+	} else {
+		fmt.Printf("%s: %s", result.RouterId, result.Outcome)
+		if result.Error != "" {
+			fmt.Printf(": %s", result.Error)
+		}
+		fmt.Printf(" (%dms)\n", result.LatencyMs)
+	}
+	if result.Outcome != wire.OUTCOME_DELIVERED {
+		return 1
+	}
+	return 0
+}
@@ -6,29 +6,44 @@ on Alert ID.  Alert messages must be under 140 bytes.
 */
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"github.com/gregaland/alert-router/api"
 	"github.com/gregaland/alert-router/config"
+	"github.com/gregaland/alert-router/metrics"
 	"github.com/gregaland/alert-router/routemgr"
 	log "github.com/sirupsen/logrus"
 	"os"
+	"os/signal"
+	"syscall"
 )
 
 // Version filled in by makefile from git tags
 var version = "undefined"
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "seal-secrets":
+			os.Exit(sealSecrets(os.Args[2:]))
+		case "check-config":
+			os.Exit(checkConfig(os.Args[2:]))
+		case "gen-api-key":
+			os.Exit(genApiKey(os.Args[2:]))
+		case "simulate":
+			os.Exit(simulate(os.Args[2:]))
+		case "ctl":
+			os.Exit(ctl(os.Args[2:]))
+		}
+	}
+
 	fmt.Println("Starting. Version: " + version)
 	var configFilePtr = flag.String("c", "/etc/alert-router.yml", "Path to configuration file")
 	flag.Parse()
 
 	// load configuration
-	configData, err := os.Open(*configFilePtr)
-	if err != nil {
-		log.Fatal(err)
-	}
-	rigConfig, err := config.NewRigConfig(configData)
+	rigConfig, err := config.LoadRigConfig(*configFilePtr)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -38,7 +53,43 @@ func main() {
 	log.SetOutput(os.Stdout)
 	log.SetReportCaller(true)
 
+	metrics.SetVersion(version)
 	routeMgr := routemgr.NewRouteMgr(rigConfig)
+
+	// reload the configuration on SIGHUP
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			log.Info("SIGHUP received")
+			_, _ = routeMgr.Reload()
+		}
+	}()
+
 	alert := api.NewAlertApi(rigConfig, routeMgr)
-	alert.ListenAndServe()
+
+	// on SIGTERM stop taking requests and let deliveries in progress
+	// finish, up to shutdown_timeout
+	done := make(chan struct{})
+	term := make(chan os.Signal, 1)
+	signal.Notify(term, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		sig := <-term
+		log.Infof("%s received, shutting down", sig)
+		ctx, cancel := context.WithTimeout(context.Background(), routeMgr.Config().ShutdownTimeout())
+		defer cancel()
+		if err := alert.Shutdown(ctx); err != nil {
+			log.Error(err)
+		}
+		if err := routeMgr.Shutdown(ctx); err != nil {
+			log.Errorf("shutdown: %v", err)
+		}
+		close(done)
+	}()
+
+	if err := alert.ListenAndServe(); err != nil {
+		log.Fatal(err)
+	}
+	<-done
+	log.Info("stopped")
 }
@@ -0,0 +1,108 @@
+// Package metrics holds the Prometheus metrics of the alert router,
+// served on /metrics from their own registry.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"runtime"
+)
+
+const NAMESPACE string = "alert_router"
+
+// Routing decisions.  A fire of a known alert counts one decision per
+// schedule, and also suppressed if none of them is enabled.
+const (
+	DECISION_DELIVERED       string = "delivered"
+	DECISION_DISABLED        string = "disabled_schedule"
+	DECISION_ROUTER_DISABLED string = "disabled_router"
+	DECISION_SUPPRESSED      string = "suppressed"
+	DECISION_UNKNOWN_ALERT   string = "unknown_alert"
+	DECISION_UNKNOWN_ROUTER  string = "unknown_router"
+)
+
+var (
+	Registry = prometheus.NewRegistry()
+
+	// fires of configured alerts; unknown ids only count as a routing
+	// decision so callers can't grow the label set
+	Fires = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "fires_total",
+		Help:      "Fire requests received per alert.",
+	}, []string{"alert"})
+
+	Decisions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "routing_decisions_total",
+		Help:      "Routing decisions made for fired alerts.",
+	}, []string{"decision"})
+
+	DeliveryAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "delivery_attempts_total",
+		Help:      "Alerts passed to a router.",
+	}, []string{"router_id"})
+
+	DeliveryFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "delivery_failures_total",
+		Help:      "Alerts a router failed to deliver.",
+	}, []string{"router_id"})
+
+	DeliveryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "delivery_duration_seconds",
+		Help:      "Time a router took to deliver an alert.",
+		Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"router_id"})
+
+	// set from the route manager when scraped
+	QueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: NAMESPACE,
+		Name:      "deliveries_in_progress",
+		Help:      "Alerts waiting on a router.",
+	})
+
+	EnabledSchedules = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: NAMESPACE,
+		Name:      "schedules_enabled",
+		Help:      "Schedules enabled now per router.",
+	}, []string{"router_id"})
+
+	Reloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "reloads_total",
+		Help:      "Configuration reloads by result.",
+	}, []string{"result"})
+
+	LastReloadSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: NAMESPACE,
+		Name:      "last_reload_success_timestamp_seconds",
+		Help:      "Time of the last successful configuration reload.",
+	})
+
+	BuildInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: NAMESPACE,
+		Name:      "build_info",
+		Help:      "Always 1, labeled with the version of the alert router.",
+	}, []string{"version", "goversion"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		Fires, Decisions, DeliveryAttempts, DeliveryFailures, DeliveryDuration,
+		QueueDepth, EnabledSchedules, Reloads, LastReloadSuccess, BuildInfo,
+	)
+	for _, result := range []string{"success", "failure"} {
+		Reloads.WithLabelValues(result)
+	}
+}
+
+// SetVersion records the version the binary was built with
+func SetVersion(version string) {
+	BuildInfo.Reset()
+	BuildInfo.WithLabelValues(version, runtime.Version()).Set(1)
+}
@@ -0,0 +1,141 @@
+package routemgr
+
+import (
+	"bytes"
+	"github.com/gregaland/alert-router/config"
+	"github.com/gregaland/alert-router/store"
+	"github.com/gregaland/alert-router/wire"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"sort"
+)
+
+// Apply makes the running alerts match alertConfigs: missing alerts are
+// created and changed ones replaced.  With prune set, alerts that are not
+// in alertConfigs are deleted.  With dryRun set only the changes are
+// returned.  Secrets sent back redacted keep the values of the running
+// alert.
+//
+// Every alert is validated and checked to be changeable first, so a bad
+// set changes nothing.  A store write that fails stops the apply; the
+// result lists the changes made until then.
+func (rm *RouteMgr) Apply(alertConfigs []*config.AlertConfig, prune, dryRun bool) (*wire.ApplyResult, error) {
+	rm.writeLock.Lock()
+	defer rm.writeLock.Unlock()
+
+	plan, err := rm.planApply(alertConfigs, prune)
+	if err != nil {
+		return nil, err
+	}
+	result := &wire.ApplyResult{DryRun: dryRun, Changes: make([]wire.Change, 0, len(plan))}
+	if dryRun {
+		for _, p := range plan {
+			result.Changes = append(result.Changes, p.Change)
+		}
+		return result, nil
+	}
+
+	for _, p := range plan {
+		current := rm.configs[p.AlertId]
+		switch p.Action {
+		case wire.ACTION_CREATE, wire.ACTION_UPDATE:
+			src := p.AlertId
+			if current != nil {
+				src = source(current)
+			}
+			_, err = rm.putAlert(src, p.config, "")
+		case wire.ACTION_DELETE:
+			err = rm.deleteAlert(current, "")
+		}
+		if err != nil {
+			return result, errors.Wrapf(err, "%s %s", p.Action, p.AlertId)
+		}
+		result.Changes = append(result.Changes, p.Change)
+	}
+	log.Infof("applied %d alert configs", len(alertConfigs))
+	return result, nil
+}
+
+// A change and the config it puts in place
+type plannedChange struct {
+	wire.Change
+	config *config.AlertConfig
+}
+
+// Works out the change to each alert and checks that every one can be
+// made.  Callers hold writeLock.
+func (rm *RouteMgr) planApply(alertConfigs []*config.AlertConfig, prune bool) ([]plannedChange, error) {
+	var errs config.ValidationErrors
+	plan := make([]plannedChange, 0, len(alertConfigs))
+	wanted := make(map[string]bool)
+	for _, ac := range alertConfigs {
+		if wanted[ac.AlertId] {
+			errs = append(errs, errors.Errorf("alert %s: duplicate alert id", ac.AlertId))
+			continue
+		}
+		wanted[ac.AlertId] = true
+		if ac.AlertId != "" && !config.ValidId(ac.AlertId) {
+			errs = append(errs, errors.Wrap(store.ErrInvalidId, ac.AlertId))
+			continue
+		}
+		current, ok := rm.configs[ac.AlertId]
+		if ok {
+			ac.KeepSecrets(current)
+		}
+		if err := rm.config.ValidateAlert(ac); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		action := wire.ACTION_CREATE
+		if ok {
+			same, err := sameAlert(current, ac)
+			if err != nil {
+				return nil, err
+			}
+			action = wire.ACTION_UPDATE
+			if same {
+				action = wire.ACTION_UNCHANGED
+			}
+		}
+		plan = append(plan, plannedChange{wire.Change{AlertId: ac.AlertId, Action: action}, ac})
+	}
+	if len(errs) > 0 {
+		return nil, &InvalidAlertError{err: errs}
+	}
+
+	if prune {
+		deleted := make([]string, 0)
+		for alertId := range rm.configs {
+			if !wanted[alertId] {
+				deleted = append(deleted, alertId)
+			}
+		}
+		sort.Strings(deleted)
+		for _, alertId := range deleted {
+			plan = append(plan, plannedChange{Change: wire.Change{AlertId: alertId, Action: wire.ACTION_DELETE}})
+		}
+	}
+
+	for _, p := range plan {
+		if current, ok := rm.configs[p.AlertId]; ok && p.Action != wire.ACTION_UNCHANGED {
+			if err := rm.checkChange(current, ""); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return plan, nil
+}
+
+// Reports whether two alert configs are the same, secrets included
+func sameAlert(a, b *config.AlertConfig) (bool, error) {
+	ya, err := config.MarshalPlainYAML(a)
+	if err != nil {
+		return false, err
+	}
+	yb, err := config.MarshalPlainYAML(b)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(ya, yb), nil
+}
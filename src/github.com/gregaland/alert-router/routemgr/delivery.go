@@ -0,0 +1,281 @@
+package routemgr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gregaland/alert-router/config"
+	"github.com/gregaland/alert-router/metrics"
+	"github.com/gregaland/alert-router/routers"
+	"github.com/gregaland/alert-router/store"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Delivery is an alert on its way to a router.  Deliveries that have not
+// finished when the server shuts down are saved and sent on the next
+// start.
+type Delivery struct {
+	AlertId    string    `json:"alert"`
+	ScheduleId string    `json:"schedule_id"`
+	RouterId   string    `json:"router_id"`
+	Message    string    `json:"msg"`
+	Queued     time.Time `json:"queued"`
+	FireId     string    `json:"fire_id,omitempty"`
+
+	// pending record the delivery is saved in, if it was sent from one or
+	// saved at a shutdown.  It is deleted once the delivery succeeds.
+	// Guarded by the deliveries lock.
+	record string
+}
+
+// Tracks the deliveries in progress
+type deliveries struct {
+	lock    sync.Mutex
+	lastId  int64
+	pending map[int64]*Delivery
+	// closed when pending empties, if anyone is waiting
+	idle chan struct{}
+
+	// held for reading while a finished delivery writes its outcome to
+	// the store, and for writing to close the store.  Once closed is set
+	// finished deliveries leave the store alone.
+	storeLock sync.RWMutex
+	closed    bool
+}
+
+func (ds *deliveries) add(d *Delivery) int64 {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+	if ds.pending == nil {
+		ds.pending = make(map[int64]*Delivery)
+	}
+	ds.lastId++
+	ds.pending[ds.lastId] = d
+	return ds.lastId
+}
+
+func (ds *deliveries) remove(id int64) {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+	delete(ds.pending, id)
+	if len(ds.pending) == 0 && ds.idle != nil {
+		close(ds.idle)
+		ds.idle = nil
+	}
+}
+
+// Wait until no deliveries are in progress or ctx is done
+func (ds *deliveries) wait(ctx context.Context) error {
+	ds.lock.Lock()
+	if len(ds.pending) == 0 {
+		ds.lock.Unlock()
+		return nil
+	}
+	if ds.idle == nil {
+		ds.idle = make(chan struct{})
+	}
+	idle := ds.idle
+	ds.lock.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// The deliveries in progress, oldest first.  Those without a pending
+// record are given one, so a delivery that finishes after it is saved
+// deletes it.
+func (ds *deliveries) list() []*Delivery {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+	ids := make([]int64, 0, len(ds.pending))
+	for id := range ds.pending {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	result := make([]*Delivery, 0, len(ids))
+	now := time.Now().UnixNano()
+	for i, id := range ids {
+		if ds.pending[id].record == "" {
+			ds.pending[id].record = fmt.Sprintf("%d-%d", now, i)
+		}
+		d := *ds.pending[id]
+		result = append(result, &d)
+	}
+	return result
+}
+
+// The pending record of a delivery in progress
+func (ds *deliveries) record(id int64) string {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+	return ds.pending[id].record
+}
+
+// Count of deliveries in progress
+func (ds *deliveries) count() int {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+	return len(ds.pending)
+}
+
+// The outcome of a delivery
+type deliveryResult struct {
+	err     error
+	latency time.Duration
+}
+
+// Send event through route in the background, tracking it until it is
+// done.  The result is sent on the channel returned.
+func (rm *RouteMgr) deliver(route routers.Router, event *routers.Event, parms config.RouterParms, fireId string) <-chan deliveryResult {
+	return rm.deliverPending(route, &Delivery{
+		AlertId:    event.Id,
+		ScheduleId: parms.Id,
+		RouterId:   parms.RouterId,
+		Message:    event.Message,
+		Queued:     time.Now(),
+		FireId:     fireId,
+	}, parms)
+}
+
+func (rm *RouteMgr) deliverPending(route routers.Router, d *Delivery, parms config.RouterParms) <-chan deliveryResult {
+	results := make(chan deliveryResult, 1)
+	event, fireId := &routers.Event{Id: d.AlertId, Message: d.Message}, d.FireId
+	id := rm.deliveries.add(d)
+	go func() {
+		defer rm.deliveries.remove(id)
+		metrics.DeliveryAttempts.WithLabelValues(parms.RouterId).Inc()
+		start := time.Now()
+		err := route.Route(event, parms)
+		latency := time.Since(start)
+		metrics.DeliveryDuration.WithLabelValues(parms.RouterId).Observe(latency.Seconds())
+		rm.health.record(parms.RouterId, err, false)
+		record := rm.deliveryDone(id, fireId, d, err, latency)
+		results <- deliveryResult{err, latency}
+		if err != nil {
+			metrics.DeliveryFailures.WithLabelValues(parms.RouterId).Inc()
+			log.WithFields(log.Fields{
+				"alert_id":  event.Id,
+				"router_id": parms.RouterId,
+			}).Errorf("delivery failed: %v", err)
+			if record != "" {
+				log.WithField("record", record).Warn("undelivered alert kept to be sent on the next start")
+			}
+		}
+	}()
+	return results
+}
+
+// Record the outcome of a delivery in its fire and delete its pending
+// record if it succeeded.  Once the store is closed nothing is written, so
+// a saved delivery that finishes then is sent again on the next start.
+// Returns the pending record.
+func (rm *RouteMgr) deliveryDone(id int64, fireId string, d *Delivery, err error, latency time.Duration) string {
+	rm.deliveries.storeLock.RLock()
+	defer rm.deliveries.storeLock.RUnlock()
+	record := rm.deliveries.record(id)
+	if rm.deliveries.closed {
+		if err == nil && record != "" {
+			log.WithField("record", record).Warn("alert delivered after shutdown is sent again on the next start")
+		}
+		return record
+	}
+	rm.fireDone(fireId, d, err, latency)
+	if err == nil && record != "" {
+		rm.lock.RLock()
+		st := rm.store
+		rm.lock.RUnlock()
+		if e := st.Delete(store.KIND_PENDING, record, ""); e != nil {
+			log.Error(e)
+		}
+	}
+	return record
+}
+
+// Close the store once no finished delivery is writing to it, saving the
+// deliveries still in progress first if save is set.  Callers hold
+// writeLock and lock for reading.
+func (rm *RouteMgr) closeStore(save bool) {
+	rm.deliveries.storeLock.Lock()
+	defer rm.deliveries.storeLock.Unlock()
+	if save {
+		rm.savePending()
+	}
+	rm.deliveries.closed = true
+	if err := rm.store.Close(); err != nil {
+		log.Error(err)
+	}
+}
+
+// Save the deliveries still in progress to the store.  Those that can't
+// be saved are logged so they can be sent by hand.  A delivery sent from a
+// pending record is saved over it.
+func (rm *RouteMgr) savePending() {
+	for _, d := range rm.deliveries.list() {
+		data, err := json.Marshal(d)
+		if err == nil {
+			_, err = rm.store.Put(store.KIND_PENDING, d.record, data, "")
+		}
+		log.WithFields(log.Fields{
+			"alert_id":    d.AlertId,
+			"schedule_id": d.ScheduleId,
+			"router_id":   d.RouterId,
+			"message":     d.Message,
+			"error":       err,
+		}).Error("undelivered at shutdown")
+	}
+}
+
+// Send the deliveries saved at the last shutdown.  Each record is kept
+// until its delivery succeeds, so one that fails is sent again on the next
+// start.  A delivery whose schedule or router no longer exists is dropped.
+func (rm *RouteMgr) replayPending() {
+	records, err := rm.store.List(store.KIND_PENDING)
+	if os.IsNotExist(errors.Cause(err)) {
+		return
+	}
+	if err != nil {
+		log.Warnf("alerts undelivered at shutdown can't be saved or sent on the next start, set store.path: %v", err)
+		return
+	}
+
+	rm.lock.RLock()
+	defer rm.lock.RUnlock()
+	for _, record := range records {
+		d := &Delivery{record: record.Id}
+		if err = json.Unmarshal(record.Data, d); err != nil {
+			log.Errorf("pending delivery %s: %v", record.Id, err)
+			continue
+		}
+		fields := log.Fields{
+			"alert_id":    d.AlertId,
+			"schedule_id": d.ScheduleId,
+			"router_id":   d.RouterId,
+			"queued":      d.Queued,
+		}
+		var sa *ScheduledAlert
+		for _, s := range rm.alerts[d.AlertId] {
+			if s.Config.Id == d.ScheduleId && s.Config.RouterId == d.RouterId {
+				sa = s
+			}
+		}
+		route, ok := rm.alertRouters[d.RouterId]
+		if sa == nil || !ok {
+			log.WithFields(fields).Warn("dropping undelivered alert, its schedule or router is gone")
+			if err = rm.store.Delete(store.KIND_PENDING, record.Id, ""); err != nil {
+				log.Error(err)
+			}
+			continue
+		}
+		log.WithFields(fields).Info("sending alert undelivered at the last shutdown")
+		rm.deliverPending(route, d, sa.Config)
+	}
+}
@@ -0,0 +1,196 @@
+package routemgr
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/gregaland/alert-router/routers"
+	"github.com/gregaland/alert-router/store"
+	"github.com/gregaland/alert-router/wire"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	// how often fire records older than fire_retention are deleted
+	FIRE_PRUNE_INTERVAL time.Duration = time.Hour
+)
+
+// Selects fires from the history.  Zero values match everything.
+type FireFilter struct {
+	AlertId string
+	Since   time.Time
+	Limit   int
+}
+
+// Fire ids are the hex nanosecond time of the fire and 4 random bytes, so
+// they sort by time
+func newFireId(t time.Time) string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return fmt.Sprintf("%016x%s", t.UnixNano(), hex.EncodeToString(b))
+}
+
+// The time encoded in a fire id
+func fireTime(id string) (time.Time, bool) {
+	if len(id) < 16 {
+		return time.Time{}, false
+	}
+	nanos, err := strconv.ParseInt(id[:16], 16, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, nanos), true
+}
+
+// Write a fire to the history
+func (rm *RouteMgr) putFire(st store.Store, f *wire.Fire) {
+	data, err := json.Marshal(f)
+	if err == nil {
+		_, err = st.Put(store.KIND_FIRES, f.Id, data, "")
+	}
+	if err != nil {
+		log.WithFields(log.Fields{"fire_id": f.Id}).Errorf("fire not recorded: %v", err)
+	}
+}
+
+// Record the outcome of a delivery in the fire it belongs to
+func (rm *RouteMgr) fireDone(fireId string, d *Delivery, err error, latency time.Duration) {
+	if fireId == "" {
+		return
+	}
+	rm.fireLock.Lock()
+	defer rm.fireLock.Unlock()
+
+	rm.lock.RLock()
+	st := rm.store
+	rm.lock.RUnlock()
+	f, e := getFire(st, fireId)
+	if e != nil {
+		log.WithFields(log.Fields{"fire_id": fireId}).Errorf("fire not recorded: %v", e)
+		return
+	}
+	for i := range f.Schedules {
+		s := &f.Schedules[i]
+		if s.ScheduleId == d.ScheduleId && s.RouterId == d.RouterId && s.Outcome == wire.OUTCOME_PENDING {
+			finish(s, err, latency)
+			break
+		}
+	}
+	rm.putFire(st, f)
+}
+
+// Set the outcome of a delivery
+func finish(s *wire.FireSchedule, err error, latency time.Duration) {
+	now := time.Now()
+	s.Outcome = wire.OUTCOME_DELIVERED
+	if err != nil {
+		s.Outcome = wire.OUTCOME_FAILED
+		s.Error = routers.RedactError(err).Error()
+	}
+	s.LatencyMs = int64(latency / time.Millisecond)
+	s.Finished = &now
+}
+
+func getFire(st store.Store, fireId string) (*wire.Fire, error) {
+	record, err := st.Get(store.KIND_FIRES, fireId)
+	if err != nil {
+		return nil, err
+	}
+	f := &wire.Fire{}
+	if err = json.Unmarshal(record.Data, f); err != nil {
+		return nil, errors.Wrap(err, fireId)
+	}
+	return f, nil
+}
+
+// GetFire returns a fire from the history
+func (rm *RouteMgr) GetFire(fireId string) (*wire.Fire, bool) {
+	if !store.ValidId(fireId) {
+		return nil, false
+	}
+	rm.lock.RLock()
+	st := rm.store
+	rm.lock.RUnlock()
+	f, err := getFire(st, fireId)
+	if err != nil {
+		return nil, false
+	}
+	return f, true
+}
+
+// ListFires returns the fires that pass filter, newest first
+func (rm *RouteMgr) ListFires(filter *FireFilter) ([]*wire.Fire, error) {
+	rm.lock.RLock()
+	st := rm.store
+	rm.lock.RUnlock()
+	records, err := st.List(store.KIND_FIRES)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Id > records[j].Id })
+
+	fires := make([]*wire.Fire, 0)
+	for _, record := range records {
+		if t, ok := fireTime(record.Id); ok && t.Before(filter.Since) {
+			break
+		}
+		f := &wire.Fire{}
+		if err = json.Unmarshal(record.Data, f); err != nil {
+			log.Errorf("fire %s: %v", record.Id, err)
+			continue
+		}
+		if filter.AlertId != "" && f.AlertId != filter.AlertId {
+			continue
+		}
+		fires = append(fires, f)
+		if filter.Limit > 0 && len(fires) == filter.Limit {
+			break
+		}
+	}
+	return fires, nil
+}
+
+// Delete fires older than fire_retention
+func (rm *RouteMgr) pruneFires() {
+	rm.lock.RLock()
+	st, retention := rm.store, rm.config.FireRetention()
+	rm.lock.RUnlock()
+	records, err := st.List(store.KIND_FIRES)
+	if err != nil {
+		return
+	}
+	cutoff, pruned := time.Now().Add(-retention), 0
+	for _, record := range records {
+		if t, ok := fireTime(record.Id); ok && t.Before(cutoff) {
+			if err = st.Delete(store.KIND_FIRES, record.Id, ""); err != nil {
+				log.Error(err)
+				continue
+			}
+			pruned++
+		}
+	}
+	if pruned > 0 {
+		log.Infof("pruned %d fire records", pruned)
+	}
+}
+
+// Prune the fire history now and every FIRE_PRUNE_INTERVAL until done is
+// closed
+func (rm *RouteMgr) pruneFiresEvery(done chan struct{}) {
+	rm.pruneFires()
+	ticker := time.NewTicker(FIRE_PRUNE_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			rm.pruneFires()
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package routemgr
+
+import (
+	"github.com/gregaland/alert-router/routers"
+	"github.com/gregaland/alert-router/wire"
+	"sync"
+	"time"
+)
+
+// Check is the result of one readiness check
+type Check struct {
+	Name  string `json:"name"`
+	Ok    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// A router's health and the result of its last probe
+type routerHealth struct {
+	wire.RouterHealth
+	probeErr error
+}
+
+// Readiness reports whether the server can route alerts
+type Readiness struct {
+	Ready   bool                `json:"ready"`
+	Checks  []Check             `json:"checks"`
+	Routers []wire.RouterHealth `json:"routers"`
+}
+
+// Tracks router health by router id
+type health struct {
+	lock    sync.Mutex
+	routers map[string]*routerHealth
+	// one probe round at a time, so concurrent checks share its results
+	probeLock sync.Mutex
+}
+
+func (h *health) get(routerId string) *routerHealth {
+	if h.routers == nil {
+		h.routers = make(map[string]*routerHealth)
+	}
+	rh, ok := h.routers[routerId]
+	if !ok {
+		rh = &routerHealth{RouterHealth: wire.RouterHealth{RouterId: routerId}}
+		h.routers[routerId] = rh
+	}
+	return rh
+}
+
+// Record the outcome of a delivery or, with probe set, a probe
+func (h *health) record(routerId string, err error, probe bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	rh := h.get(routerId)
+	now := time.Now()
+	if probe {
+		rh.LastProbe = &now
+		rh.probeErr = err
+	}
+	if err != nil {
+		rh.LastError = routers.RedactError(err).Error()
+		rh.LastErrorTime = &now
+	} else {
+		rh.LastSuccess = &now
+	}
+}
+
+func (h *health) status(routerId string) routerHealth {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return *h.get(routerId)
+}
+
+// Probe the routers that support it and whose last probe is older than
+// interval
+func (h *health) probe(alertRouters map[string]routers.Router, interval time.Duration) {
+	h.probeLock.Lock()
+	defer h.probeLock.Unlock()
+
+	var wg sync.WaitGroup
+	for routerId, r := range alertRouters {
+		p, ok := r.(routers.Prober)
+		if !ok {
+			continue
+		}
+		if last := h.status(routerId).LastProbe; last != nil && time.Since(*last) < interval {
+			continue
+		}
+		wg.Add(1)
+		go func(routerId string, p routers.Prober) {
+			defer wg.Done()
+			h.record(routerId, p.Probe(), true)
+		}(routerId, p)
+	}
+	wg.Wait()
+}
+
+// Ready checks that the config is loaded, the store can be written and
+// the scheduler is running.  With probe_routers set, each enabled
+// router's service must also be reachable.
+func (rm *RouteMgr) Ready() *Readiness {
+	rm.lock.RLock()
+	rigConfig, alertRouters, st, c := rm.config, rm.alertRouters, rm.store, rm.cron
+	rm.lock.RUnlock()
+
+	if rigConfig.ProbeRouters {
+		probed := make(map[string]routers.Router)
+		for _, r := range rigConfig.Routers {
+			if route, ok := alertRouters[r.Parms.Id]; ok && r.Parms.Enabled {
+				probed[r.Parms.Id] = route
+			}
+		}
+		rm.health.probe(probed, rigConfig.ProbeInterval())
+	}
+
+	checks := []Check{
+		{Name: "config", Ok: len(alertRouters) == len(rigConfig.Routers)},
+		{Name: "store", Ok: true},
+		{Name: "scheduler", Ok: c.isRunning()},
+	}
+	if err := st.Check(); err != nil {
+		checks[1] = Check{Name: "store", Error: err.Error()}
+	}
+	if !checks[2].Ok {
+		checks[2].Error = "not running"
+	}
+
+	ready := &Readiness{Ready: true, Routers: make([]wire.RouterHealth, 0, len(rigConfig.Routers))}
+	for _, r := range rigConfig.Routers {
+		rh := rm.health.status(r.Parms.Id)
+		ready.Routers = append(ready.Routers, rh.RouterHealth)
+		if rigConfig.ProbeRouters && r.Parms.Enabled && rh.LastProbe != nil {
+			check := Check{Name: "router:" + rh.RouterId, Ok: rh.probeErr == nil}
+			if !check.Ok {
+				check.Error = routers.RedactError(rh.probeErr).Error()
+			}
+			checks = append(checks, check)
+		}
+	}
+	for _, check := range checks {
+		ready.Ready = ready.Ready && check.Ok
+	}
+	ready.Checks = checks
+	return ready
+}
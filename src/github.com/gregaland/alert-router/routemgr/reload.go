@@ -0,0 +1,159 @@
+package routemgr
+
+import (
+	"github.com/gregaland/alert-router/config"
+	"github.com/gregaland/alert-router/metrics"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// ReloadStatus reports the outcome of configuration reloads
+type ReloadStatus struct {
+	Reloads     int       `json:"reloads"`
+	Failures    int       `json:"failures"`
+	LastReload  time.Time `json:"last_reload,omitempty"`
+	LastSuccess time.Time `json:"last_success,omitempty"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// Reload re-reads the main config file, if the config was loaded from one,
+// the routers added through the api and the alerts directory, then swaps
+// in the new routers and schedules.  The new configuration is validated in
+// full first; if anything fails the current configuration is kept.  Once
+// the route manager is shut down it returns ErrClosed.
+func (rm *RouteMgr) Reload() (ReloadStatus, error) {
+	rm.writeLock.Lock()
+	defer rm.writeLock.Unlock()
+	if rm.closed {
+		return rm.GetReloadStatus(), ErrClosed
+	}
+
+	err := rm.reload()
+
+	rm.lock.Lock()
+	rm.reloads.Reloads++
+	rm.reloads.LastReload = time.Now()
+	if err != nil {
+		rm.reloads.Failures++
+		rm.reloads.LastError = err.Error()
+		metrics.Reloads.WithLabelValues("failure").Inc()
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("reload failed, keeping current configuration")
+	} else {
+		rm.reloads.LastSuccess = rm.reloads.LastReload
+		rm.reloads.LastError = ""
+		metrics.Reloads.WithLabelValues("success").Inc()
+		metrics.LastReloadSuccess.Set(float64(rm.reloads.LastSuccess.Unix()))
+		log.Info("reload complete")
+	}
+	status := rm.reloads
+	rm.lock.Unlock()
+
+	return status, err
+}
+
+// GetReloadStatus returns the outcome of the reloads so far
+func (rm *RouteMgr) GetReloadStatus() ReloadStatus {
+	rm.lock.RLock()
+	defer rm.lock.RUnlock()
+	return rm.reloads
+}
+
+func (rm *RouteMgr) reload() (err error) {
+	rm.lock.RLock()
+	current := rm.config
+	rm.lock.RUnlock()
+
+	fileConfig := rm.fileConfig
+	if current.Path() != "" {
+		log.WithFields(log.Fields{
+			"file": current.Path(),
+		}).Info("reloading configuration")
+		fileConfig, err = config.LoadRigConfig(current.Path())
+		if err != nil {
+			return err
+		}
+		if fileConfig.Listen != current.Listen {
+			log.Warnf("listen changed from %s to %s, a restart is required", current.Listen, fileConfig.Listen)
+		}
+	}
+
+	// the store is only reopened if its settings changed
+	st := rm.store
+	if storeChanged(current, fileConfig) {
+		st, err = OpenStore(fileConfig)
+		if err != nil {
+			return err
+		}
+		// close the new store unless it was swapped in
+		defer func() {
+			if err != nil {
+				st.Close()
+			}
+		}()
+	}
+	newConfig, err := WithStoredRouters(fileConfig, st)
+	if err != nil {
+		return err
+	}
+	alertConfigs, err := config.LoadAlerts(st)
+	if err == nil {
+		alertConfigs = skipInvalidIds(alertConfigs)
+		err = newConfig.Validate(alertConfigs)
+	}
+	if err != nil {
+		return err
+	}
+	alertRouters, err := newRouters(newConfig)
+	if err != nil {
+		return err
+	}
+
+	rm.lock.RLock()
+	prev := rm.alerts
+	rm.lock.RUnlock()
+
+	c, now := newScheduler(), time.Now()
+	alerts := make(map[string][]*ScheduledAlert)
+	configs := make(map[string]*config.AlertConfig)
+	for _, ac := range alertConfigs {
+		var schedules []*ScheduledAlert
+		schedules, err = newSchedules(c, ac, prev[ac.AlertId], now)
+		if err != nil {
+			return err
+		}
+		alerts[ac.AlertId] = schedules
+		configs[ac.AlertId] = ac
+	}
+
+	rm.lock.Lock()
+	old, oldStore := rm.cron, rm.store
+	rm.config = newConfig
+	rm.fileConfig = fileConfig
+	rm.store = st
+	rm.alertRouters = alertRouters
+	rm.alerts = alerts
+	rm.configs = configs
+	rm.cron = c
+	rm.cron.start()
+	rm.lock.Unlock()
+	old.stop()
+	if oldStore != st {
+		if e := oldStore.Close(); e != nil {
+			log.Error(e)
+		}
+	}
+
+	if newConfig.WatchAlerts != current.WatchAlerts || storeChanged(current, newConfig) {
+		rm.stopWatching()
+		if newConfig.WatchAlerts && dirStore(newConfig) {
+			if e := rm.watchAlerts(); e != nil {
+				log.Error(e)
+			}
+		}
+	}
+
+	log.SetLevel(newConfig.LogLevel())
+	return nil
+}
@@ -1,34 +1,84 @@
 package routemgr
 
 import (
-	"encoding/json"
-	"fmt"
+	"context"
 	"github.com/gregaland/alert-router/config"
+	"github.com/gregaland/alert-router/metrics"
 	"github.com/gregaland/alert-router/routers"
+	"github.com/gregaland/alert-router/store"
+	"github.com/gregaland/alert-router/wire"
 	"github.com/pkg/errors"
-	"github.com/robfig/cron"
 	log "github.com/sirupsen/logrus"
-	"gopkg.in/yaml.v2"
-	"io/ioutil"
 	"net/http"
 	"net/smtp"
-	"os"
-	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // RouteMgr
 type RouteMgr struct {
+	// lock guards the routing state below, which is swapped as a
+	// whole on reload.  fileConfig is the main config as loaded and
+	// config adds the routers added through the api to it.
+	lock         sync.RWMutex
 	config       *config.RigConfig
+	fileConfig   *config.RigConfig
 	auth         smtp.Auth
 	alertRouters map[string]routers.Router
 	alerts       map[string][]*ScheduledAlert
-	cron         *cron.Cron
+	configs      map[string]*config.AlertConfig
+	cron         *scheduler
+	store        store.Store
+
+	// writeLock serializes changes to the alerts: reloads and api
+	// updates.  It is taken before lock.  The state above is only
+	// written holding both, so either one is enough to read it.
+	writeLock sync.Mutex
+	reloads   ReloadStatus
+	watcher   *watcher
+	// set by Shutdown and Close under writeLock
+	closed bool
+
+	deliveries deliveries
+	health     health
+
+	// serializes updates to fire records
+	fireLock  sync.Mutex
+	pruneDone chan struct{}
 }
 
-// ScheduleAlert object used for cron schedules
+// Returned when deleting or replacing an alert that is defined in a file
+// together with other alerts.  Such alerts have to be changed by editing
+// the file.
+var ErrSharedFile = errors.New("alert is defined in a file with other alerts")
+
+// Returned when adding an alert with the id of an existing one
+var ErrAlertExists = errors.New("alert already exists")
+
+// Returned by Reload once the route manager is shut down
+var ErrClosed = errors.New("route manager is shut down")
+
+// ScheduleAlert object used for cron schedules.  Config is not changed
+// once the schedule is created; enabled is flipped by the cron jobs and
+// must be accessed atomically.
 type ScheduledAlert struct {
 	Config  config.RouterParms
-	enabled bool
+	enabled int32
+	jobs    []EntryId
+}
+
+// Enabled reports whether the schedule is currently enabled
+func (sa *ScheduledAlert) Enabled() bool {
+	return atomic.LoadInt32(&sa.enabled) == 1
+}
+
+func (sa *ScheduledAlert) setEnabled(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&sa.enabled, v)
 }
 
 // Used to enable a schedule via the cron interface
@@ -45,69 +95,226 @@ type ScheduleDisabler struct {
 // flip the enabled flag to true
 func (s *ScheduleEnabler) Run() {
 	log.Infof("Enabling an alert: %s", s.s.Config.RouterId)
-	s.s.enabled = true
+	s.s.setEnabled(true)
 }
 
 // Schedule enabler.  Implements the cron interface Run function to
 // flip the enabled flag to false
 func (s *ScheduleDisabler) Run() {
 	log.Infof("Disabling an alert: %s", s.s.Config.RouterId)
-	s.s.enabled = false
+	s.s.setEnabled(false)
 }
 
-func NewRouteMgr(config *config.RigConfig) *RouteMgr {
-	rm := &RouteMgr{config: config}
-	rm.cron = cron.New()
-	err := rm.initRouters()
+func NewRouteMgr(rigConfig *config.RigConfig) *RouteMgr {
+	rm := &RouteMgr{fileConfig: rigConfig}
+	rm.cron = newScheduler()
+	st, err := OpenStore(rigConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+	rm.store = st
+	rigConfig, err = WithStoredRouters(rigConfig, st)
+	if err != nil {
+		log.Fatal(err)
+	}
+	rm.config = rigConfig
+	alertConfigs, err := config.LoadAlerts(st)
+	if err == nil {
+		alertConfigs = skipInvalidIds(alertConfigs)
+		err = rigConfig.Validate(alertConfigs)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
-	err = rm.loadAlerts()
+	alertRouters, err := newRouters(rigConfig)
 	if err != nil {
 		log.Fatal(err)
 	}
+	rm.alertRouters = alertRouters
+	rm.alerts = make(map[string][]*ScheduledAlert)
+	rm.configs = make(map[string]*config.AlertConfig)
+	for _, alertConfig := range alertConfigs {
+		rm.addAlertConfig(alertConfig)
+	}
 
-	rm.cron.Start()
+	rm.cron.start()
+	rm.replayPending()
+	rm.pruneDone = make(chan struct{})
+	go rm.pruneFiresEvery(rm.pruneDone)
+
+	if rigConfig.WatchAlerts && dirStore(rigConfig) {
+		err = rm.watchAlerts()
+		if err != nil {
+			log.Error(err)
+		}
+	}
 
 	return rm
 }
 
-func (rm *RouteMgr) Route(event *routers.Event) error {
+// Shutdown stops the scheduler and the alerts directory watcher, then
+// waits for deliveries in progress until ctx is done.  Deliveries that
+// have not finished by then are saved to be sent on the next start.
+func (rm *RouteMgr) Shutdown(ctx context.Context) error {
+	rm.writeLock.Lock()
+	defer rm.writeLock.Unlock()
+	if rm.closed {
+		return nil
+	}
+	rm.closed = true
+	rm.stopWatching()
+	rm.stopPruning()
+	rm.lock.RLock()
+	rm.cron.stop()
+	rm.lock.RUnlock()
+
+	log.Infof("waiting for %d deliveries", rm.deliveries.count())
+	err := rm.deliveries.wait(ctx)
+
+	rm.lock.RLock()
+	defer rm.lock.RUnlock()
+	rm.closeStore(err != nil)
+	return err
+}
+
+// Stop pruning the fire history.  Callers hold writeLock.
+func (rm *RouteMgr) stopPruning() {
+	if rm.pruneDone != nil {
+		close(rm.pruneDone)
+		rm.pruneDone = nil
+	}
+}
+
+// Close stops the scheduler and the alerts directory watcher
+func (rm *RouteMgr) Close() {
+	rm.writeLock.Lock()
+	defer rm.writeLock.Unlock()
+	if rm.closed {
+		return
+	}
+	rm.closed = true
+	rm.stopWatching()
+	rm.stopPruning()
+	rm.lock.RLock()
+	defer rm.lock.RUnlock()
+	rm.cron.stop()
+	rm.closeStore(false)
+}
+
+// Returned by Route for an alert id that isn't configured
+var ErrUnknownAlert = errors.New("unknown alert")
+
+// Route an event to the enabled schedules of its alert.  The fire is
+// recorded in the history, and the record returned, before the
+// deliveries start.
+func (rm *RouteMgr) Route(event *routers.Event) (*wire.Fire, error) {
+	fire, _, err := rm.route(event)
+	return fire, err
+}
+
+// RouteWait routes an event like Route, then waits until its deliveries
+// finish or ctx is done.  The fire returned has the outcome of each
+// delivery that finished; the others are still pending.
+func (rm *RouteMgr) RouteWait(ctx context.Context, event *routers.Event) (*wire.Fire, error) {
+	fire, done, err := rm.route(event)
+	for i, results := range done {
+		if results == nil {
+			continue
+		}
+		select {
+		case res := <-results:
+			finish(&fire.Schedules[i], res.err, res.latency)
+		case <-ctx.Done():
+			return fire, err
+		}
+	}
+	return fire, err
+}
+
+// Callers of route get a channel for each schedule of the fire that is
+// delivered, nil for the others
+func (rm *RouteMgr) route(event *routers.Event) (*wire.Fire, []<-chan deliveryResult, error) {
+	rm.lock.RLock()
+	st := rm.store
+
+	now := time.Now()
+	fire := &wire.Fire{
+		Id:        newFireId(now),
+		AlertId:   event.Id,
+		Message:   event.Message,
+		Received:  now,
+		Schedules: make([]wire.FireSchedule, 0),
+	}
+	type delivery struct {
+		route routers.Router
+		parms config.RouterParms
+		index int
+	}
+	deliveries := make([]delivery, 0)
 
 	var err error = nil
 	if schedule, ok := rm.alerts[event.Id]; ok {
+		metrics.Fires.WithLabelValues(event.Id).Inc()
+		routed, disabled := false, rm.disabledRouters()
 		for _, s := range schedule {
-			if s.enabled {
+			fs := wire.FireSchedule{ScheduleId: s.Config.Id, RouterId: s.Config.RouterId, Outcome: wire.OUTCOME_SKIPPED}
+			if s.Enabled() && disabled[s.Config.RouterId] {
+				log.Infof("router disabled.  id: %s", s.Config.RouterId)
+				fs.Reason = "router disabled"
+				metrics.Decisions.WithLabelValues(metrics.DECISION_ROUTER_DISABLED).Inc()
+			} else if s.Enabled() {
+				routed = true
 				log.WithFields(log.Fields{
 					"router_id": s.Config.RouterId,
 					"id":        s.Config.Id,
-					"enabled":   s.enabled,
+					"enabled":   true,
 				}).Info("found configured alert")
 
 				if route, ok := rm.alertRouters[s.Config.RouterId]; !ok {
 					err = errors.New("No schedule for router with id: " + s.Config.RouterId)
+					fs.Outcome, fs.Error = wire.OUTCOME_FAILED, "unknown router"
+					metrics.Decisions.WithLabelValues(metrics.DECISION_UNKNOWN_ROUTER).Inc()
 				} else {
 					log.Info("Firing " + event.Id + ": " + event.Message)
-					routeEvent := &routers.Event{Id: event.Id, Message: event.Message}
-					go route.Route(routeEvent, s.Config)
+					fs.Outcome = wire.OUTCOME_PENDING
+					deliveries = append(deliveries, delivery{route, s.Config, len(fire.Schedules)})
+					metrics.Decisions.WithLabelValues(metrics.DECISION_DELIVERED).Inc()
 				}
 			} else {
 				log.Infof("alert disabled.  id: %s", s.Config.Id)
+				fs.Reason = "schedule disabled"
+				metrics.Decisions.WithLabelValues(metrics.DECISION_DISABLED).Inc()
 			}
+			fire.Schedules = append(fire.Schedules, fs)
+		}
+		if !routed {
+			metrics.Decisions.WithLabelValues(metrics.DECISION_SUPPRESSED).Inc()
 		}
 	} else {
-		err = errors.New("No alerts with id: " + event.Id)
+		err = errors.Wrap(ErrUnknownAlert, event.Id)
+		fire.Error = ErrUnknownAlert.Error()
+		metrics.Decisions.WithLabelValues(metrics.DECISION_UNKNOWN_ALERT).Inc()
 	}
-	return err
+	rm.lock.RUnlock()
+
+	// the fire is written before its deliveries can finish and update it
+	rm.putFire(st, fire)
+	done := make([]<-chan deliveryResult, len(fire.Schedules))
+	for _, d := range deliveries {
+		routeEvent := &routers.Event{Id: event.Id, Message: event.Message}
+		done[d.index] = rm.deliver(d.route, routeEvent, d.parms, fire.Id)
+	}
+	return fire, done, err
 }
-// Private function that uses the main config file to initialize routers.
-func (rm *RouteMgr) initRouters() error {
+
+// Private function that uses the main config file to build the routers.
+func newRouters(rigConfig *config.RigConfig) (map[string]routers.Router, error) {
 	var err error = nil
-	var r routers.Router = nil
-	rm.alertRouters = make(map[string]routers.Router)
+	alertRouters := make(map[string]routers.Router)
 
 	log.Debug("initializing routers")
-	for _, router := range rm.config.Routers {
+	for _, router := range rigConfig.Routers {
+		var r routers.Router = nil
 
 		switch router.Type {
 		case config.EMAIL_RP:
@@ -116,8 +323,6 @@ func (rm *RouteMgr) initRouters() error {
 				"type":     router.Type,
 				"smtphost": router.Parms.SmtpHost,
 				"smtpport": router.Parms.SmtpPort,
-				"au":       router.Parms.SmtpAuthUser,
-				"ap":       router.Parms.SmtpAuthPass,
 			}).Info("loading email router")
 
 			c := &routers.EmailConfig{SmtpHost: router.Parms.SmtpHost, SmtpPort: router.Parms.SmtpPort,
@@ -133,143 +338,158 @@ func (rm *RouteMgr) initRouters() error {
 			r, err = routers.NewSlackRouter(c)
 			break
 		default:
-			log.Fatal("Unknown router type")
-			return errors.New("Unknown router type")
+			err = errors.Errorf("Unknown router type: %s", router.Type)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "router %s", router.Parms.Id)
 		}
 
 		// initialize the router
-		if r != nil {
-			err = r.Init()
-			if err != nil {
-				log.Fatal(err)
-			}
-			rm.alertRouters[router.Parms.Id] = r
+		err = r.Init()
+		if err != nil {
+			return nil, errors.Wrapf(err, "router %s", router.Parms.Id)
 		}
+		alertRouters[router.Parms.Id] = r
 	}
-	return err
+	return alertRouters, nil
 }
 
-// Private function that loads the alerts from the alerts directory
-func (rm *RouteMgr) loadAlerts() error {
-	var err error = nil
-
-	log.WithFields(log.Fields{
-		"path": rm.config.AlertsPath,
-	}).Debug("loading alerts")
+// AddAlert adds an alert with the config in the request body.  Returns the
+// new alert's revision.
+func (rm *RouteMgr) AddAlert(alertId string, r *http.Request) (string, error) {
+	rm.writeLock.Lock()
+	defer rm.writeLock.Unlock()
 
-	files, err := ioutil.ReadDir(rm.config.AlertsPath)
-	if err != nil {
-		log.Fatal(err)
+	if _, ok := rm.alerts[alertId]; ok {
+		return "", errors.Wrap(ErrAlertExists, alertId)
 	}
 
-	rm.alerts = make(map[string][]*ScheduledAlert)
-
-	for _, file := range files {
-		if filepath.Ext(file.Name()) == ".yml" {
-
-			fullpath := fmt.Sprintf("%s%c%s", rm.config.AlertsPath, filepath.Separator, file.Name())
-			log.WithFields(log.Fields{
-				"file": file.Name(),
-			}).Debug("loading alert config")
-
-			alertData, err := os.Open(fullpath)
-			if err != nil {
-				log.Fatal(err)
-			}
-			alertConfig, err := config.LoadAlertConfig(alertData)
-			if err != nil {
-				log.Fatal(err)
-			}
-			log.WithFields(log.Fields{
-				"alert_id":   alertConfig.AlertId,
-				"parameters": alertConfig.Schedule,
-			}).Info("loaded alert config")
-			rm.AddAlertConfig(alertConfig)
+	if !config.ValidId(alertId) {
+		return "", &InvalidAlertError{errors.Wrap(store.ErrInvalidId, alertId), "id"}
+	}
 
-		}
-		fmt.Println(file.Name())
+	ac, err := decodeAlert(alertId, r)
+	if err != nil {
+		return "", err
 	}
-	return err
+	return rm.putAlert(alertId, ac, "")
 }
 
-func (rm *RouteMgr) AddAlert(alertId string, r *http.Request) (bool,error) {
-
-	ac := config.AlertConfig{}
-
-	// Already have it?
-	if _, ok := rm.alerts[alertId]; ok {
-		// already have it
-		return false, nil
-	}
+// Add a alert configuration and create its schedule
+func (rm *RouteMgr) AddAlertConfig(alertConfig *config.AlertConfig) {
+	rm.writeLock.Lock()
+	defer rm.writeLock.Unlock()
+	rm.lock.Lock()
+	defer rm.lock.Unlock()
+	rm.addAlertConfig(alertConfig)
+}
 
-	// try to parse as json
-	err := json.NewDecoder(r.Body).Decode(&ac)
+func (rm *RouteMgr) addAlertConfig(alertConfig *config.AlertConfig) {
+	prev := rm.alerts[alertConfig.AlertId]
+	schedules, err := newSchedules(rm.cron, alertConfig, prev, time.Now())
 	if err != nil {
-		log.Errorf("failed to parse json: %v", err)
-	} else {
-		rm.AddAlertConfig(&ac)
-		out, err := yaml.Marshal(ac)
-		err = ioutil.WriteFile(rm.config.AlertsPath + "/" + alertId + ".yml", out, 0644)
-		if err != nil {
-			log.Error(err)
-		}
+		log.Error(err)
 	}
-	return true, err
+	removeJobs(rm.cron, prev)
+	rm.alerts[alertConfig.AlertId] = schedules
+	rm.configs[alertConfig.AlertId] = alertConfig
 }
 
-// Add a alert configuration and create its schedule
-func (rm *RouteMgr) AddAlertConfig(alertConfig *config.AlertConfig) {
+// Create the schedules of an alert config, registering their jobs with c.
+// A new schedule starts in the state stateAt gives it at now, as a
+// simulation would, and its jobs change it from there.  A schedule that
+// is unchanged from one in prev keeps its enabled state.
+func newSchedules(c *scheduler, alertConfig *config.AlertConfig, prev []*ScheduledAlert, now time.Time) ([]*ScheduledAlert, error) {
+	var err error
 	schedules := make([]*ScheduledAlert, 0)
 	for _, sap := range alertConfig.Schedule {
-		sa := ScheduledAlert{Config: sap, enabled: false}
-
-		// TODO: if both start and end are not given - then what?
-
+		sa := &ScheduledAlert{Config: sap}
+		if enabled, _, _, e := stateAt(sap.ScheduleStart, sap.ScheduleEnd, now); e == nil {
+			sa.setEnabled(enabled)
+		}
 		if sa.Config.ScheduleStart != "" {
-			s := &ScheduleEnabler{s: &sa}
-			err := rm.cron.AddJob("0 "+ sa.Config.ScheduleStart, s)
-			if err != nil {
-				log.Error(err)
+			s := &ScheduleEnabler{s: sa}
+			if id, e := c.addJob(alertConfig.AlertId, sa.Config.ScheduleStart, s); e != nil {
+				err = errors.Wrapf(e, "alert %s schedule %s start", alertConfig.AlertId, sa.Config.Id)
+			} else {
+				sa.jobs = append(sa.jobs, id)
 			}
-		} else {
-			sa.enabled = true
 		}
 		if sa.Config.ScheduleEnd != "" {
-			d := &ScheduleDisabler{s: &sa}
-			err := rm.cron.AddJob("0 "+sa.Config.ScheduleEnd, d)
-			if err != nil {
-				log.Error(err)
+			d := &ScheduleDisabler{s: sa}
+			if id, e := c.addJob(alertConfig.AlertId, sa.Config.ScheduleEnd, d); e != nil {
+				err = errors.Wrapf(e, "alert %s schedule %s end", alertConfig.AlertId, sa.Config.Id)
+			} else {
+				sa.jobs = append(sa.jobs, id)
 			}
 		}
-		schedules = append(schedules, &sa)
+		for _, p := range prev {
+			if p.Config.Id == sap.Id && p.Config.ScheduleStart == sap.ScheduleStart &&
+				p.Config.ScheduleEnd == sap.ScheduleEnd {
+				sa.setEnabled(p.Enabled())
+			}
+		}
+		schedules = append(schedules, sa)
 		log.WithFields(log.Fields{
-			"enabled": sa.enabled,
+			"enabled": sa.Enabled(),
 			"params":  sa.Config,
 		}).Info("alert scheduled")
 	}
-	rm.alerts[alertConfig.AlertId] = schedules
+	return schedules, err
 }
 
-// Private function to delete an alert
-func (rm *RouteMgr) DeleteAlert(alertId string) (bool,error) {
+// Remove the cron jobs of schedules from c
+func removeJobs(c *scheduler, schedules []*ScheduledAlert) {
+	ids := make([]EntryId, 0)
+	for _, sa := range schedules {
+		ids = append(ids, sa.jobs...)
+	}
+	c.remove(ids...)
+}
+
+// Private function to delete an alert.  A non empty revision must match
+// the alert's current one.
+func (rm *RouteMgr) DeleteAlert(alertId string, revision string) (bool, error) {
+	rm.writeLock.Lock()
+	defer rm.writeLock.Unlock()
 
 	// do we have it?
-	if _, ok := rm.alerts[alertId]; !ok {
+	current, ok := rm.configs[alertId]
+	if !ok {
 		// nope
 		return false, nil
 	}
+	if err := rm.checkChange(current, revision); err != nil {
+		return true, err
+	}
+	return true, rm.deleteAlert(current, revision)
+}
 
-	delete(rm.alerts, alertId)
+// Deletes the record of an alert and then its schedules.  Callers hold
+// writeLock.
+func (rm *RouteMgr) deleteAlert(current *config.AlertConfig, revision string) error {
+	if current.Source != "" {
+		err := rm.store.Delete(store.KIND_ALERTS, current.Source, revision)
+		if err != nil {
+			return err
+		}
+	}
 
-	// TODO use config path
-	err := os.Remove(rm.config.AlertsPath + "/" + alertId + ".yml")
-	return true, err
+	rm.lock.Lock()
+	defer rm.lock.Unlock()
+	removeJobs(rm.cron, rm.alerts[current.AlertId])
+	delete(rm.alerts, current.AlertId)
+	delete(rm.configs, current.AlertId)
+	return nil
 }
 
 func (rm *RouteMgr) GetAlerts() map[string][]*ScheduledAlert {
+	rm.lock.RLock()
+	defer rm.lock.RUnlock()
+
 	// returns a copy of the alerts
 	result := make(map[string][]*ScheduledAlert)
-	for k,v := range rm.alerts {
+	for k, v := range rm.alerts {
 		sa := make([]*ScheduledAlert, 0, len(v))
 		for _, alert := range v {
 			sa = append(sa, alert)
@@ -278,3 +498,17 @@ func (rm *RouteMgr) GetAlerts() map[string][]*ScheduledAlert {
 	}
 	return result
 }
+
+// Config returns the running configuration, which is replaced on reload
+func (rm *RouteMgr) Config() *config.RigConfig {
+	rm.lock.RLock()
+	defer rm.lock.RUnlock()
+	return rm.config
+}
+
+// GetJobs returns the jobs registered with the scheduler
+func (rm *RouteMgr) GetJobs() []Job {
+	rm.lock.RLock()
+	defer rm.lock.RUnlock()
+	return rm.cron.jobs()
+}
@@ -0,0 +1,577 @@
+package routemgr
+
+import (
+	"context"
+	"fmt"
+	"github.com/gregaland/alert-router/config"
+	"github.com/gregaland/alert-router/routers"
+	"github.com/gregaland/alert-router/store"
+	"github.com/gregaland/alert-router/wire"
+	"github.com/pkg/errors"
+	"gotest.tools/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var rigData = `
+listen: :8000
+alerts_path: %s
+routers:
+ - id: gmail
+   type: email
+   enabled: true
+   smtphost: 127.0.0.1
+   smtpport: 1
+ - id: slack-alerts
+   type: webhook
+   enabled: true
+   url: %s
+store:
+  type: dir
+  path: %s
+`
+
+var alertData = `
+alert: dbfail
+schedule:
+  - id: all_day
+    router_id: slack-alerts
+  - id: after_hours
+    start: "0 17 * * *"
+    end: "0 6 * * *"
+    router_id: gmail
+    email_addrs:
+      - john.doe@foobar.net
+`
+
+// Writes a main config file and an alerts directory to a temporary
+// directory and returns a RouteMgr loaded from it.  The slack router posts
+// to url.
+func newTestRouteMgr(t *testing.T, url string) (*RouteMgr, string) {
+	dir, err := ioutil.TempDir("", "routemgr")
+	assert.NilError(t, err)
+	alertsPath := filepath.Join(dir, "alerts.d")
+	assert.NilError(t, os.Mkdir(alertsPath, 0755))
+	writeFile(t, filepath.Join(dir, "alert-router.yml"), fmt.Sprintf(rigData, alertsPath, url, filepath.Join(dir, "state")))
+	writeFile(t, filepath.Join(alertsPath, "dbfail.yml"), alertData)
+
+	rigConfig, err := config.LoadRigConfig(filepath.Join(dir, "alert-router.yml"))
+	assert.NilError(t, err)
+	return NewRouteMgr(rigConfig), dir
+}
+
+func writeFile(t *testing.T, path, data string) {
+	assert.NilError(t, ioutil.WriteFile(path, []byte(data), 0644))
+}
+
+func TestRouteMgr_Reload(t *testing.T) {
+	rm, dir := newTestRouteMgr(t, "https://hooks.slack.com/services/T000/B000/XXXX")
+	defer os.RemoveAll(dir)
+	defer rm.Close()
+
+	// take the after hours schedule out of its initial state, reload
+	// must keep it
+	rm.GetAlerts()["dbfail"][1].setEnabled(true)
+
+	writeFile(t, filepath.Join(dir, "alerts.d", "dbfail2.yml"), "alert: dbfail2\nschedule:\n  - id: all_day\n    router_id: gmail\n")
+	status, err := rm.Reload()
+	assert.NilError(t, err)
+	assert.Equal(t, 1, status.Reloads)
+	assert.Equal(t, 0, status.Failures)
+	alerts := rm.GetAlerts()
+	assert.Equal(t, 2, len(alerts))
+	assert.Assert(t, alerts["dbfail"][1].Enabled())
+
+	// an unknown router in any alert fails the whole reload
+	writeFile(t, filepath.Join(dir, "alerts.d", "dbfail3.yml"), "alert: dbfail3\nschedule:\n  - id: all_day\n    router_id: pager\n")
+	status, err = rm.Reload()
+	assert.ErrorContains(t, err, "unknown router_id pager")
+	assert.Equal(t, 2, status.Reloads)
+	assert.Equal(t, 1, status.Failures)
+	assert.Equal(t, 2, len(rm.GetAlerts()))
+	assert.NilError(t, os.Remove(filepath.Join(dir, "alerts.d", "dbfail3.yml")))
+
+	// so does a broken main config
+	writeFile(t, filepath.Join(dir, "alert-router.yml"), fmt.Sprintf(
+		"alerts_path: %s\nrouters:\n - id: gmail\n   type: sms\n", filepath.Join(dir, "alerts.d")))
+	_, err = rm.Reload()
+	assert.ErrorContains(t, err, "unknown router type sms")
+	assert.Equal(t, 2, len(rm.GetAlerts()))
+
+	// as does removing a router that alerts use
+	writeFile(t, filepath.Join(dir, "alert-router.yml"), fmt.Sprintf(
+		"alerts_path: %s\nrouters:\n - id: slack-alerts\n   type: webhook\n   url: https://hooks.slack.com/x\n",
+		filepath.Join(dir, "alerts.d")))
+	_, err = rm.Reload()
+	assert.ErrorContains(t, err, "unknown router_id gmail")
+	assert.Equal(t, 2, len(rm.GetAlerts()))
+	assert.Equal(t, 2, len(rm.alertRouters))
+}
+
+func TestRouteMgr_ReloadAfterShutdown(t *testing.T) {
+	rm, dir := newTestRouteMgr(t, "https://hooks.slack.com/services/T000/B000/XXXX")
+	defer os.RemoveAll(dir)
+	assert.NilError(t, rm.Shutdown(context.Background()))
+
+	status, err := rm.Reload()
+	assert.Equal(t, ErrClosed, err)
+	assert.Equal(t, 0, status.Reloads)
+	assert.Assert(t, !rm.cron.isRunning())
+	rm.Close()
+}
+
+// Alerts written before the id grammar, with ids it rejects, are skipped
+// at startup and reload rather than failing the load
+func TestRouteMgr_LoadOldIds(t *testing.T) {
+	rm, dir := newTestRouteMgr(t, "https://hooks.slack.com/services/T000/B000/XXXX")
+	defer os.RemoveAll(dir)
+	alertsPath := filepath.Join(dir, "alerts.d")
+	writeFile(t, filepath.Join(alertsPath, "DbFail.yml"), "alert: DbFail\nschedule:\n  - id: all_day\n    router_id: gmail\n")
+	writeFile(t, filepath.Join(alertsPath, "diskfull.yml"), "alert: diskfull\nschedule:\n  - id: All_Day\n    router_id: gmail\n")
+
+	_, err := rm.Reload()
+	assert.NilError(t, err)
+	alerts := rm.GetAlerts()
+	assert.Equal(t, 1, len(alerts))
+	assert.Assert(t, alerts["dbfail"] != nil)
+	rm.Close()
+
+	rigConfig, err := config.LoadRigConfig(filepath.Join(dir, "alert-router.yml"))
+	assert.NilError(t, err)
+	rm = NewRouteMgr(rigConfig)
+	defer rm.Close()
+	alerts = rm.GetAlerts()
+	assert.Equal(t, 1, len(alerts))
+	assert.Assert(t, alerts["dbfail"] != nil)
+
+	// check-config still reports them
+	alertConfigs, err := config.LoadAlerts(rm.store)
+	assert.NilError(t, err)
+	err = rigConfig.Validate(alertConfigs)
+	assert.ErrorContains(t, err, "alert DbFail (DbFail): invalid alert id")
+	assert.ErrorContains(t, err, "schedule All_Day: invalid schedule id")
+}
+
+// Fires, adds, updates, deletes and reloads alerts concurrently while the
+// schedules are toggled the way cron does.  Run with -race.
+func TestRouteMgr_Concurrent(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	rm, dir := newTestRouteMgr(t, ts.URL)
+	defer os.RemoveAll(dir)
+	defer rm.Close()
+
+	const n = 50
+	var wg sync.WaitGroup
+	run := func(f func(i int)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				f(i)
+			}
+		}()
+	}
+
+	// cron
+	run(func(i int) {
+		for _, schedules := range rm.GetAlerts() {
+			for _, sa := range schedules {
+				(&ScheduleEnabler{s: sa}).Run()
+				(&ScheduleDisabler{s: sa}).Run()
+			}
+		}
+	})
+	// fire
+	for j := 0; j < 4; j++ {
+		run(func(i int) {
+			_, _ = rm.Route(&routers.Event{Id: "dbfail", Message: "db is down"})
+			_, _ = rm.Route(&routers.Event{Id: fmt.Sprintf("web%d", i%3), Message: "5xx"})
+		})
+	}
+	// add, update and delete
+	for j := 0; j < 3; j++ {
+		run(func(i int) {
+			id := fmt.Sprintf("web%d", i%3)
+			body := fmt.Sprintf(`{"alert": "%s", "schedule": [{"id": "all_day", "router_id": "slack-alerts"}]}`, id)
+			_, err := rm.AddAlert(id, httptest.NewRequest("POST", "/v1/alerts/"+id, strings.NewReader(body)))
+			if err != nil {
+				assert.Equal(t, errors.Cause(err), ErrAlertExists)
+			}
+			_, err = rm.DeleteAlert(id, "")
+			assert.NilError(t, err)
+		})
+	}
+	// apply
+	run(func(i int) {
+		if i%10 != 0 {
+			return
+		}
+		id := fmt.Sprintf("web%d", i%3)
+		alertConfigs, err := config.ParseAlertConfigs([]byte(fmt.Sprintf(
+			`{"alert": "%s", "schedule": [{"id": "all_day", "router_id": "slack-alerts"}]}`, id)))
+		assert.NilError(t, err)
+		_, err = rm.Apply(alertConfigs, false, false)
+		assert.NilError(t, err)
+	})
+	// list and reload
+	run(func(i int) {
+		for id, schedules := range rm.GetAlerts() {
+			for _, sa := range schedules {
+				_ = fmt.Sprintf("%s %s %v", id, sa.Config.RouterId, sa.Enabled())
+			}
+		}
+		if i%10 == 0 {
+			_, err := rm.Reload()
+			assert.NilError(t, err)
+		}
+	})
+	wg.Wait()
+
+	// memory and the store agree
+	records, err := rm.store.List(store.KIND_ALERTS)
+	assert.NilError(t, err)
+	alerts := rm.GetAlerts()
+	assert.Equal(t, len(records), len(alerts))
+	for _, r := range records {
+		_, ok := alerts[r.Id]
+		assert.Assert(t, ok, r.Id)
+	}
+	assert.Assert(t, alerts["dbfail"] != nil)
+
+	// and no jobs were left behind by deleted alerts
+	jobs := 0
+	for _, schedules := range alerts {
+		for _, sa := range schedules {
+			jobs += len(sa.jobs)
+		}
+	}
+	assert.Equal(t, jobs, rm.cron.count())
+}
+
+// Deliveries still in progress when shutdown times out are sent on the
+// next start
+func TestRouteMgr_Shutdown(t *testing.T) {
+	var posts int32
+	got, release := make(chan struct{}, 2), make(chan struct{})
+	// the first post hangs until the test is done
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&posts, 1)
+		got <- struct{}{}
+		if n == 1 {
+			<-release
+		}
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	rm, dir := newTestRouteMgr(t, ts.URL)
+	defer os.RemoveAll(dir)
+	fire, err := rm.Route(&routers.Event{Id: "dbfail", Message: "db is down"})
+	assert.NilError(t, err)
+	<-got
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, rm.Shutdown(ctx))
+	files, err := ioutil.ReadDir(filepath.Join(dir, "state", store.KIND_PENDING))
+	assert.NilError(t, err)
+	assert.Equal(t, 1, len(files))
+
+	rigConfig, err := config.LoadRigConfig(filepath.Join(dir, "alert-router.yml"))
+	assert.NilError(t, err)
+	rm = NewRouteMgr(rigConfig)
+	<-got
+	assert.NilError(t, rm.Shutdown(context.Background()))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&posts))
+	files, err = ioutil.ReadDir(filepath.Join(dir, "state", store.KIND_PENDING))
+	assert.NilError(t, err)
+	assert.Equal(t, 0, len(files))
+
+	// the resent delivery completes the fire
+	rigConfig, err = config.LoadRigConfig(filepath.Join(dir, "alert-router.yml"))
+	assert.NilError(t, err)
+	rm = NewRouteMgr(rigConfig)
+	defer rm.Close()
+	fire, ok := rm.GetFire(fire.Id)
+	assert.Assert(t, ok)
+	assert.Equal(t, wire.OUTCOME_DELIVERED, fire.Schedules[0].Outcome)
+}
+
+// A saved delivery that finishes before the store is closed deletes its
+// record; one that finishes after shutdown leaves the store alone
+func TestRouteMgr_ShutdownLateDelivery(t *testing.T) {
+	got, release := make(chan struct{}, 2), make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- struct{}{}
+		<-release
+	}))
+	defer ts.Close()
+
+	rm, dir := newTestRouteMgr(t, ts.URL)
+	defer os.RemoveAll(dir)
+	pending := filepath.Join(dir, "state", store.KIND_PENDING)
+	_, err := rm.Route(&routers.Event{Id: "dbfail", Message: "db is down"})
+	assert.NilError(t, err)
+	<-got
+	rm.lock.RLock()
+	rm.savePending()
+	rm.lock.RUnlock()
+	files, err := ioutil.ReadDir(pending)
+	assert.NilError(t, err)
+	assert.Equal(t, 1, len(files))
+	release <- struct{}{}
+	assert.NilError(t, rm.deliveries.wait(context.Background()))
+	files, err = ioutil.ReadDir(pending)
+	assert.NilError(t, err)
+	assert.Equal(t, 0, len(files))
+
+	fire, err := rm.Route(&routers.Event{Id: "dbfail", Message: "db is still down"})
+	assert.NilError(t, err)
+	<-got
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, rm.Shutdown(ctx))
+	release <- struct{}{}
+	assert.NilError(t, rm.deliveries.wait(context.Background()))
+	files, err = ioutil.ReadDir(pending)
+	assert.NilError(t, err)
+	assert.Equal(t, 1, len(files))
+
+	st := store.NewDirStore(map[string]string{}, filepath.Join(dir, "state"))
+	f, err := getFire(st, fire.Id)
+	assert.NilError(t, err)
+	assert.Equal(t, wire.OUTCOME_PENDING, f.Schedules[0].Outcome)
+}
+
+// A store whose records can't be listed
+type unlistableStore struct {
+	store.Store
+}
+
+func (unlistableStore) List(kind string) ([]*store.Record, error) {
+	return nil, errors.New("input/output error")
+}
+
+func TestLoadRouters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "routers")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	// a missing routers directory has no routers
+	st := store.NewDirStore(map[string]string{store.KIND_ROUTERS: filepath.Join(dir, "missing")}, dir)
+	stored, err := LoadRouters(st)
+	assert.NilError(t, err)
+	assert.Equal(t, 0, len(stored))
+
+	_, err = LoadRouters(unlistableStore{st})
+	assert.ErrorContains(t, err, "input/output error")
+}
+
+func TestRouteMgr_ShutdownTestRouter(t *testing.T) {
+	got, release := make(chan struct{}, 1), make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- struct{}{}
+		<-release
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	rm, dir := newTestRouteMgr(t, ts.URL)
+	defer os.RemoveAll(dir)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-got
+		cancel()
+	}()
+	result, ok, err := rm.TestRouter(ctx, "slack-alerts", "", config.RouterParms{})
+	assert.NilError(t, err)
+	assert.Assert(t, ok)
+	assert.Equal(t, wire.OUTCOME_PENDING, result.Outcome)
+
+	// shutdown waits for the test message and saves it when it can't
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, rm.Shutdown(ctx))
+	files, err := ioutil.ReadDir(filepath.Join(dir, "state", store.KIND_PENDING))
+	assert.NilError(t, err)
+	assert.Equal(t, 1, len(files))
+}
+
+func TestRouteMgr_ReplayFailed(t *testing.T) {
+	rm, dir := newTestRouteMgr(t, "http://127.0.0.1:1")
+	defer os.RemoveAll(dir)
+	assert.NilError(t, rm.Shutdown(context.Background()))
+
+	// the gmail router can't connect, so the delivery fails
+	pending := filepath.Join(dir, "state", store.KIND_PENDING)
+	assert.NilError(t, os.MkdirAll(pending, 0755))
+	writeFile(t, filepath.Join(pending, "1-0.json"),
+		`{"alert": "dbfail", "schedule_id": "after_hours", "router_id": "gmail", "msg": "db is down"}`)
+	rigConfig, err := config.LoadRigConfig(filepath.Join(dir, "alert-router.yml"))
+	assert.NilError(t, err)
+	rm = NewRouteMgr(rigConfig)
+	assert.NilError(t, rm.Shutdown(context.Background()))
+
+	files, err := ioutil.ReadDir(pending)
+	assert.NilError(t, err)
+	assert.Equal(t, 1, len(files))
+}
+
+func TestRouteMgr_Ready(t *testing.T) {
+	var heads int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "HEAD" {
+			atomic.AddInt32(&heads, 1)
+		}
+	}))
+	defer ts.Close()
+
+	rm, dir := newTestRouteMgr(t, ts.URL)
+	defer os.RemoveAll(dir)
+	ready := rm.Ready()
+	assert.Assert(t, ready.Ready)
+	assert.Equal(t, 3, len(ready.Checks))
+	assert.Equal(t, 2, len(ready.Routers))
+
+	// nothing listens on the smtp port of gmail
+	rm.Config().ProbeRouters = true
+	ready = rm.Ready()
+	assert.Assert(t, !ready.Ready)
+	checks := make(map[string]Check)
+	for _, c := range ready.Checks {
+		checks[c.Name] = c
+	}
+	assert.Assert(t, checks["router:slack-alerts"].Ok)
+	assert.Assert(t, !checks["router:gmail"].Ok)
+	assert.Assert(t, checks["router:gmail"].Error != "")
+	assert.Assert(t, ready.Routers[0].LastErrorTime != nil)
+	assert.Assert(t, ready.Routers[1].LastSuccess != nil)
+
+	// probes are cached, and a later delivery error doesn't replace the
+	// probe's
+	probeErr := checks["router:gmail"].Error
+	rm.health.record("gmail", errors.New("delivery failed"), false)
+	for _, c := range rm.Ready().Checks {
+		if c.Name == "router:gmail" {
+			assert.Equal(t, probeErr, c.Error)
+		}
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&heads))
+
+	rm.Close()
+	assert.Assert(t, !rm.Ready().Ready)
+}
+
+func TestRouteMgr_PruneFires(t *testing.T) {
+	rm, dir := newTestRouteMgr(t, "https://hooks.slack.com/services/T000/B000/XXXX")
+	defer os.RemoveAll(dir)
+	defer rm.Close()
+
+	old := &wire.Fire{Id: newFireId(time.Now().Add(-8 * 24 * time.Hour)), AlertId: "dbfail"}
+	recent := &wire.Fire{Id: newFireId(time.Now().Add(-time.Hour)), AlertId: "dbfail"}
+	rm.putFire(rm.store, old)
+	rm.putFire(rm.store, recent)
+
+	rm.pruneFires()
+	_, ok := rm.GetFire(old.Id)
+	assert.Assert(t, !ok)
+	_, ok = rm.GetFire(recent.Id)
+	assert.Assert(t, ok)
+}
+
+func TestScheduler_Remove(t *testing.T) {
+	s := newScheduler()
+	s.start()
+	defer s.stop()
+	sa := &ScheduledAlert{Config: config.RouterParms{Id: "after_hours"}}
+	start, err := s.addJob("dbfail", "0 17 * * *", &ScheduleEnabler{s: sa})
+	assert.NilError(t, err)
+	end, err := s.addJob("dbfail", "0 6 * * *", &ScheduleDisabler{s: sa})
+	assert.NilError(t, err)
+
+	// jobs due together run in the order they were due
+	due := s.due(time.Date(2026, 12, 25, 5, 0, 0, 0, time.Local), time.Date(2026, 12, 25, 18, 0, 0, 0, time.Local))
+	assert.Equal(t, 2, len(due))
+	assert.Equal(t, end, due[0].id)
+	assert.Equal(t, start, due[1].id)
+
+	s.remove(start)
+	assert.Equal(t, 1, s.count())
+	assert.Equal(t, end, s.jobs()[0].Id)
+
+	// a job due while the crons are swapped is run by remove
+	at := time.Date(2026, 12, 25, 6, 0, 0, 0, time.Local)
+	assert.Equal(t, 1, len(s.due(at.Add(-time.Second), at)))
+	assert.Equal(t, 0, len(s.due(at, at.Add(time.Second))))
+}
+
+// The live schedules and a simulation agree at every step of a week in
+// which the scheduler's jobs run when they are due
+func TestScheduler_MatchesSimulate(t *testing.T) {
+	ac := &config.AlertConfig{AlertId: "dbfail", Schedule: []config.RouterParms{
+		{Id: "after_hours", RouterId: "gmail", ScheduleStart: "0 17 * * *", ScheduleEnd: "0 6 * * *"},
+		{Id: "work_hours", RouterId: "gmail", ScheduleStart: "0 9 * * 1-5", ScheduleEnd: "0 17 * * 1-5"},
+		{Id: "mondays", RouterId: "gmail", ScheduleStart: "0 9 * * 1"},
+		{Id: "mornings", RouterId: "gmail", ScheduleEnd: "0 6 * * *"},
+		{Id: "all_day", RouterId: "gmail"},
+	}}
+	event := &routers.Event{Id: "dbfail", Message: "db is down"}
+	step := 30 * time.Minute
+	start := time.Date(2026, 12, 20, 12, 0, 0, 0, time.Local)
+
+	s := newScheduler()
+	schedules, err := newSchedules(s, ac, nil, start)
+	assert.NilError(t, err)
+	for at := start; at.Before(start.Add(8 * 24 * time.Hour)); at = at.Add(step) {
+		for _, e := range s.due(at.Add(-step), at) {
+			e.job.Run()
+		}
+
+		sim, err := simulate(&config.RigConfig{}, nil, ac, event, at)
+		assert.NilError(t, err)
+		for i, sa := range schedules {
+			assert.Equal(t, sim.Schedules[i].Enabled, sa.Enabled(), "%s at %s", sa.Config.Id, at)
+		}
+	}
+}
+
+func TestStateAt(t *testing.T) {
+	day := func(d, h int) time.Time { return time.Date(2026, 12, d, h, 0, 0, 0, time.Local) }
+	for _, c := range []struct {
+		start, end string
+		at         time.Time
+		enabled    bool
+		reason     string
+		next       time.Time
+	}{
+		{"0 17 * * *", "0 6 * * *", day(25, 3), true, "started " + day(24, 17).Format(time.RFC3339), day(25, 6)},
+		{"0 17 * * *", "0 6 * * *", day(25, 12), false, "ended " + day(25, 6).Format(time.RFC3339), day(25, 17)},
+		{"0 17 * * *", "0 6 * * *", day(25, 17), true, "started " + day(25, 17).Format(time.RFC3339), day(26, 6)},
+		// monday mornings
+		{"0 9 * * 1", "", day(23, 3), true, "started " + day(21, 9).Format(time.RFC3339), time.Time{}},
+		{"", "0 6 * * *", day(25, 3), false, "ended " + day(24, 6).Format(time.RFC3339), time.Time{}},
+		{"", "", day(25, 3), true, "no start or end", time.Time{}},
+	} {
+		enabled, reason, next, err := stateAt(c.start, c.end, c.at)
+		assert.NilError(t, err)
+		assert.Equal(t, c.enabled, enabled, c.at)
+		assert.Equal(t, c.reason, reason)
+		if c.next.IsZero() {
+			assert.Assert(t, next == nil)
+		} else {
+			assert.Equal(t, c.next, *next)
+		}
+	}
+	_, _, _, err := stateAt("0 25 * * *", "", time.Now())
+	assert.Assert(t, err != nil)
+}
@@ -0,0 +1,330 @@
+package routemgr
+
+import (
+	"bytes"
+	"github.com/gregaland/alert-router/config"
+	"github.com/gregaland/alert-router/routers"
+	"github.com/gregaland/alert-router/store"
+	"github.com/gregaland/alert-router/wire"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Where a router is defined
+const (
+	ORIGIN_CONFIG string = "config"
+	ORIGIN_API    string = "api"
+)
+
+var (
+	// Returned when changing a router of the main config, which has to
+	// be changed by editing the file
+	ErrConfigRouter = errors.New("router is defined in the main config")
+	// Returned when adding a router with the id of an existing one
+	ErrRouterExists = errors.New("router already exists")
+	// Returned when deleting a router that alerts are routed to
+	ErrRouterInUse = errors.New("router is used by alerts")
+)
+
+// Returned for a router entry that fails to parse or validate
+type InvalidRouterError struct {
+	err   error
+	field string
+}
+
+func (e *InvalidRouterError) Error() string {
+	return e.err.Error()
+}
+
+// Field is the field of the router entry at fault, if known
+func (e *InvalidRouterError) Field() string {
+	return e.field
+}
+
+// IsInvalidRouter reports whether err was caused by a bad router entry
+func IsInvalidRouter(err error) bool {
+	_, ok := errors.Cause(err).(*InvalidRouterError)
+	return ok
+}
+
+// LoadRouters reads the routers added through the api.  A store without
+// a routers directory has none, other errors are returned.
+func LoadRouters(st store.Store) ([]*config.Routers, error) {
+	records, err := st.List(store.KIND_ROUTERS)
+	if err != nil {
+		if cause := errors.Cause(err); cause == store.ErrNotFound || os.IsNotExist(cause) {
+			log.Debugf("no routers in the store: %v", err)
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "can't read the stored routers")
+	}
+
+	var errs config.ValidationErrors
+	stored := make([]*config.Routers, 0, len(records))
+	for _, record := range records {
+		r, err := config.DecodeRouter(bytes.NewReader(record.Data))
+		if err != nil {
+			errs = append(errs, errors.Wrap(err, record.Id))
+			continue
+		}
+		r.Source, r.Revision = record.Id, record.Revision
+		stored = append(stored, r)
+	}
+	return stored, errs.Err()
+}
+
+// WithStoredRouters returns the main config with the routers added
+// through the api, which are kept in st
+func WithStoredRouters(fileConfig *config.RigConfig, st store.Store) (*config.RigConfig, error) {
+	stored, err := LoadRouters(st)
+	if err != nil {
+		return nil, err
+	}
+	return fileConfig.WithRouters(stored)
+}
+
+// ListRouters returns the configured routers, those of the main config
+// first
+func (rm *RouteMgr) ListRouters() []*wire.RouterInfo {
+	rm.lock.RLock()
+	defer rm.lock.RUnlock()
+	result := make([]*wire.RouterInfo, 0, len(rm.config.Routers))
+	for _, r := range rm.config.Routers {
+		result = append(result, rm.routerInfo(r))
+	}
+	return result
+}
+
+// GetRouter returns a configured router
+func (rm *RouteMgr) GetRouter(routerId string) (*wire.RouterInfo, bool) {
+	rm.lock.RLock()
+	defer rm.lock.RUnlock()
+	r := rm.routerConfig(routerId)
+	if r == nil {
+		return nil, false
+	}
+	return rm.routerInfo(r), true
+}
+
+// Callers hold lock
+func (rm *RouteMgr) routerInfo(r *config.Routers) *wire.RouterInfo {
+	info := &wire.RouterInfo{
+		RouterId: r.Parms.Id,
+		Type:     r.Type,
+		Enabled:  r.Parms.Enabled,
+		Origin:   ORIGIN_CONFIG,
+		Health:   rm.health.status(r.Parms.Id).RouterHealth,
+		Revision: r.Revision,
+	}
+	if r.Source != "" {
+		info.Origin = ORIGIN_API
+	}
+	if route, ok := rm.alertRouters[r.Parms.Id]; ok {
+		info.Config = route.GetConfig()
+	}
+	return info
+}
+
+// The entry of a router, or nil.  Callers hold lock.
+func (rm *RouteMgr) routerConfig(routerId string) *config.Routers {
+	for _, r := range rm.config.Routers {
+		if r.Parms.Id == routerId {
+			return r
+		}
+	}
+	return nil
+}
+
+// Ids of the routers set enabled: false.  Callers hold lock.
+func (rm *RouteMgr) disabledRouters() map[string]bool {
+	disabled := make(map[string]bool)
+	for _, r := range rm.config.Routers {
+		if !r.Parms.Enabled {
+			disabled[r.Parms.Id] = true
+		}
+	}
+	return disabled
+}
+
+// AddRouter adds a router with the entry in the request body.  Returns the
+// new router's revision.
+func (rm *RouteMgr) AddRouter(routerId string, r *http.Request) (string, error) {
+	rm.writeLock.Lock()
+	defer rm.writeLock.Unlock()
+
+	if rm.routerConfig(routerId) != nil {
+		return "", errors.Wrap(ErrRouterExists, routerId)
+	}
+	router, err := decodeRouter(routerId, r)
+	if err != nil {
+		return "", err
+	}
+	return rm.putRouter(router, "")
+}
+
+// UpdateRouter replaces a router added through the api with the entry in
+// the request body.  A non empty revision must match the router's current
+// one.  Secrets sent back redacted keep their values.  Returns the new
+// revision.
+func (rm *RouteMgr) UpdateRouter(routerId string, r *http.Request, revision string) (bool, string, error) {
+	rm.writeLock.Lock()
+	defer rm.writeLock.Unlock()
+
+	current := rm.routerConfig(routerId)
+	if current == nil {
+		return false, "", nil
+	}
+	if err := checkRouterChange(current, revision); err != nil {
+		return true, "", err
+	}
+	router, err := decodeRouter(routerId, r)
+	if err != nil {
+		return true, "", err
+	}
+	router.KeepSecrets(current)
+	rev, err := rm.putRouter(router, revision)
+	return true, rev, err
+}
+
+// DeleteRouter deletes a router added through the api.  Routers that
+// alerts are routed to can't be deleted.  A non empty revision must match
+// the router's current one.
+func (rm *RouteMgr) DeleteRouter(routerId string, revision string) (bool, error) {
+	rm.writeLock.Lock()
+	defer rm.writeLock.Unlock()
+
+	current := rm.routerConfig(routerId)
+	if current == nil {
+		return false, nil
+	}
+	if err := checkRouterChange(current, revision); err != nil {
+		return true, err
+	}
+	users := make([]string, 0)
+	for alertId, ac := range rm.configs {
+		for _, s := range ac.Schedule {
+			if s.RouterId == routerId {
+				users = append(users, alertId)
+				break
+			}
+		}
+	}
+	if len(users) > 0 {
+		sort.Strings(users)
+		return true, errors.Wrapf(ErrRouterInUse, "router %s is used by %s", routerId, strings.Join(users, ", "))
+	}
+
+	rigConfig, alertRouters, err := rm.buildRouters(routerId, nil)
+	if err != nil {
+		return true, err
+	}
+	if err = rm.store.Delete(store.KIND_ROUTERS, current.Source, revision); err != nil {
+		return true, err
+	}
+	rm.swapRouters(rigConfig, alertRouters)
+	return true, nil
+}
+
+// Parse a router entry whose id, if given, has to be routerId
+func decodeRouter(routerId string, r *http.Request) (*config.Routers, error) {
+	if !config.ValidId(routerId) {
+		return nil, &InvalidRouterError{errors.Wrap(store.ErrInvalidId, routerId), "router_id"}
+	}
+	router, err := config.DecodeRouter(r.Body)
+	if err != nil {
+		log.Errorf("failed to parse router: %v", err)
+		return nil, &InvalidRouterError{err: err}
+	}
+	if router.Parms.Id != "" && router.Parms.Id != routerId {
+		return nil, &InvalidRouterError{errors.Errorf("router id %s does not match %s", router.Parms.Id, routerId), "id"}
+	}
+	router.Parms.Id = routerId
+	return router, nil
+}
+
+// Checks that a router can be replaced or deleted: it was added through
+// the api and revision, if given, is the current one
+func checkRouterChange(current *config.Routers, revision string) error {
+	if current.Source == "" {
+		return errors.Wrapf(ErrConfigRouter, "router %s", current.Parms.Id)
+	}
+	if revision != "" && revision != current.Revision {
+		return errors.Wrapf(store.ErrConflict, "router %s", current.Parms.Id)
+	}
+	return nil
+}
+
+// Validates router, writes it to the store and then swaps in the routers
+// with it.  Nothing changes if any step fails.  Callers hold writeLock.
+func (rm *RouteMgr) putRouter(router *config.Routers, revision string) (string, error) {
+	routerId := router.Parms.Id
+	rigConfig, alertRouters, err := rm.buildRouters(routerId, router)
+	if err != nil {
+		return "", err
+	}
+	out, err := config.MarshalPlainYAML(router)
+	if err != nil {
+		return "", err
+	}
+	rev, err := rm.store.Put(store.KIND_ROUTERS, routerId, out, revision)
+	if err != nil {
+		log.Error(err)
+		return "", err
+	}
+	for _, r := range rigConfig.Routers {
+		if r.Parms.Id == routerId {
+			r.Source, r.Revision = routerId, rev
+		}
+	}
+	rm.swapRouters(rigConfig, alertRouters)
+	return rev, nil
+}
+
+// The config and routers that result from replacing the stored router
+// routerId with router, or removing it if router is nil.  The alerts are
+// validated against them.  Callers hold writeLock.
+func (rm *RouteMgr) buildRouters(routerId string, router *config.Routers) (*config.RigConfig, map[string]routers.Router, error) {
+	stored, err := LoadRouters(rm.store)
+	if err != nil {
+		return nil, nil, err
+	}
+	kept := make([]*config.Routers, 0, len(stored)+1)
+	for _, r := range stored {
+		if r.Parms.Id != routerId {
+			kept = append(kept, r)
+		}
+	}
+	if router != nil {
+		kept = append(kept, router)
+	}
+
+	rigConfig, err := rm.fileConfig.WithRouters(kept)
+	if err == nil {
+		alertConfigs := make([]*config.AlertConfig, 0, len(rm.configs))
+		for _, ac := range rm.configs {
+			alertConfigs = append(alertConfigs, ac)
+		}
+		err = rigConfig.Validate(alertConfigs)
+	}
+	if err != nil {
+		log.Errorf("invalid router: %v", err)
+		return nil, nil, &InvalidRouterError{err: err}
+	}
+	alertRouters, err := newRouters(rigConfig)
+	if err != nil {
+		return nil, nil, &InvalidRouterError{err: err}
+	}
+	return rigConfig, alertRouters, nil
+}
+
+// Callers hold writeLock
+func (rm *RouteMgr) swapRouters(rigConfig *config.RigConfig, alertRouters map[string]routers.Router) {
+	rm.lock.Lock()
+	defer rm.lock.Unlock()
+	rm.config = rigConfig
+	rm.alertRouters = alertRouters
+}
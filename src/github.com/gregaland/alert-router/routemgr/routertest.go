@@ -0,0 +1,52 @@
+package routemgr
+
+import (
+	"context"
+	"github.com/gregaland/alert-router/config"
+	"github.com/gregaland/alert-router/routers"
+	"github.com/gregaland/alert-router/wire"
+	"github.com/pkg/errors"
+)
+
+const (
+	TEST_ALERT_ID string = "alert-router-test"
+	TEST_MESSAGE  string = "Test message from alert-router"
+)
+
+// TestRouter sends a test message through a router with the given
+// schedule parameters, such as email_addrs, and waits for the result
+// until ctx is done.  An empty message is replaced with TEST_MESSAGE.
+// Returns false if there is no such router, and an error if parms
+// can't be used with it.
+func (rm *RouteMgr) TestRouter(ctx context.Context, routerId, message string, parms config.RouterParms) (*wire.RouterTest, bool, error) {
+	rm.lock.RLock()
+	route, ok := rm.alertRouters[routerId]
+	routerType := rm.routerTypes()[routerId]
+	rm.lock.RUnlock()
+	if !ok {
+		return nil, false, nil
+	}
+	if routerType == config.EMAIL_RP && len(parms.EmailAddrs) == 0 {
+		return nil, true, errors.New("email_addrs is required")
+	}
+	if message == "" {
+		message = TEST_MESSAGE
+	}
+	parms.Id, parms.RouterId = "test", routerId
+
+	result := &wire.RouterTest{RouterId: routerId, RouterType: routerType, Outcome: wire.OUTCOME_PENDING}
+	if renderer, ok := route.(routers.Renderer); ok {
+		result.Notification, _ = renderer.Render(&routers.Event{Id: TEST_ALERT_ID, Message: message}, parms)
+	}
+
+	// delivered like a scheduled fire, so shutdown waits for it or saves it
+	done := rm.deliver(route, &routers.Event{Id: TEST_ALERT_ID, Message: message}, parms, "")
+	select {
+	case res := <-done:
+		s := &wire.FireSchedule{}
+		finish(s, res.err, res.latency)
+		result.Outcome, result.Error, result.LatencyMs = s.Outcome, s.Error, s.LatencyMs
+	case <-ctx.Done():
+	}
+	return result, true, nil
+}
@@ -0,0 +1,199 @@
+package routemgr
+
+import (
+	"github.com/robfig/cron"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Identifies a job registered with a scheduler
+type EntryId int
+
+// A job registered with a scheduler
+type schedEntry struct {
+	id       EntryId
+	alertId  string
+	spec     string
+	schedule cron.Schedule
+	job      cron.Job
+}
+
+// scheduler wraps cron.Cron, which has no way to remove a job, and keeps
+// an id for every job.  Removing jobs replaces the underlying cron with one
+// holding the remaining jobs.
+type scheduler struct {
+	lock    sync.Mutex
+	cron    *cron.Cron
+	entries map[EntryId]*schedEntry
+	lastId  EntryId
+	running bool
+}
+
+// A job as reported by the scheduler debug endpoint
+type Job struct {
+	Id         EntryId   `json:"id"`
+	AlertId    string    `json:"alert"`
+	ScheduleId string    `json:"schedule_id"`
+	RouterId   string    `json:"router_id"`
+	Action     string    `json:"action"`
+	Spec       string    `json:"spec"`
+	Next       time.Time `json:"next"`
+}
+
+func newScheduler() *scheduler {
+	return &scheduler{cron: cron.New(), entries: make(map[EntryId]*schedEntry)}
+}
+
+// Register job to run on the cron spec, which has a seconds field.  spec is
+// reported without it.
+func (s *scheduler) addJob(alertId, spec string, job cron.Job) (EntryId, error) {
+	schedule, err := cron.Parse("0 " + spec)
+	if err != nil {
+		return 0, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.lastId++
+	s.entries[s.lastId] = &schedEntry{
+		id:       s.lastId,
+		alertId:  alertId,
+		spec:     spec,
+		schedule: schedule,
+		job:      job,
+	}
+	s.cron.Schedule(schedule, job)
+	return s.lastId, nil
+}
+
+// Remove jobs from the scheduler
+func (s *scheduler) remove(ids ...EntryId) {
+	if len(ids) == 0 {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	removed := false
+	for _, id := range ids {
+		if _, ok := s.entries[id]; ok {
+			delete(s.entries, id)
+			removed = true
+		}
+	}
+	if !removed {
+		return
+	}
+
+	// cron can't remove a job; start over with the ones left
+	stopped := time.Now()
+	if s.running {
+		s.cron.Stop()
+	}
+	s.cron = cron.New()
+	for _, e := range s.entries {
+		s.cron.Schedule(e.schedule, e.job)
+	}
+	if s.running {
+		s.cron.Start()
+		// The new cron only runs jobs due after it starts.  Entries returns
+		// once it has worked out its first run times; run the jobs due
+		// since the old one stopped here, one at a time in the order they
+		// were due, so the start and end of a schedule leave it as cron
+		// would have.  A job the old cron ran just before it stopped may
+		// run twice, which enabling and disabling schedules doesn't mind.
+		s.cron.Entries()
+		due := s.due(stopped.Add(-time.Second), time.Now())
+		go func() {
+			for _, e := range due {
+				e.job.Run()
+			}
+		}()
+	}
+}
+
+// The entries due after from and up to to, in the order they are due
+func (s *scheduler) due(from, to time.Time) []*schedEntry {
+	due := make([]*schedEntry, 0)
+	for _, e := range s.entries {
+		if next := e.schedule.Next(from); !next.After(to) {
+			due = append(due, e)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		ni, nj := due[i].schedule.Next(from), due[j].schedule.Next(from)
+		if !ni.Equal(nj) {
+			return ni.Before(nj)
+		}
+		return due[i].id < due[j].id
+	})
+	return due
+}
+
+func (s *scheduler) start() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.running {
+		s.cron.Start()
+		s.running = true
+	}
+}
+
+func (s *scheduler) stop() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.running {
+		s.cron.Stop()
+		s.running = false
+	}
+}
+
+func (s *scheduler) isRunning() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.running
+}
+
+// The registered jobs ordered by their next run time
+func (s *scheduler) jobs() []Job {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := time.Now()
+	jobs := make([]Job, 0, len(s.entries))
+	for _, e := range s.entries {
+		job := Job{
+			Id:      e.id,
+			AlertId: e.alertId,
+			Spec:    e.spec,
+			Next:    e.schedule.Next(now),
+		}
+		var sa *ScheduledAlert
+		switch j := e.job.(type) {
+		case *ScheduleEnabler:
+			job.Action, sa = "enable", j.s
+		case *ScheduleDisabler:
+			job.Action, sa = "disable", j.s
+		}
+		if sa != nil {
+			job.ScheduleId = sa.Config.Id
+			job.RouterId = sa.Config.RouterId
+		}
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		if !jobs[i].Next.Equal(jobs[j].Next) {
+			return jobs[i].Next.Before(jobs[j].Next)
+		}
+		return jobs[i].Id < jobs[j].Id
+	})
+	return jobs
+}
+
+// Number of registered jobs
+func (s *scheduler) count() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.entries)
+}
@@ -0,0 +1,135 @@
+package routemgr
+
+import (
+	"github.com/gregaland/alert-router/config"
+	"github.com/gregaland/alert-router/routers"
+	"github.com/gregaland/alert-router/wire"
+	"github.com/pkg/errors"
+	"github.com/robfig/cron"
+	"time"
+)
+
+// How far back the last start or end of a schedule is looked for, in
+// widening steps so frequent schedules are found quickly
+var lookbacks = []time.Duration{time.Hour, 24 * time.Hour, 32 * 24 * time.Hour, 366 * 24 * time.Hour}
+
+// Simulate reports which schedules of an alert would be enabled at an
+// instant and what their routers would send for event.  Cron specs are
+// evaluated in the server's time zone.  Nothing is sent.
+func Simulate(rigConfig *config.RigConfig, ac *config.AlertConfig, event *routers.Event, at time.Time) (*wire.Simulation, error) {
+	alertRouters, err := newRouters(rigConfig)
+	if err != nil {
+		return nil, err
+	}
+	return simulate(rigConfig, alertRouters, ac, event, at)
+}
+
+func simulate(rigConfig *config.RigConfig, alertRouters map[string]routers.Router, ac *config.AlertConfig,
+	event *routers.Event, at time.Time) (*wire.Simulation, error) {
+	var err error
+	types, disabled := make(map[string]config.RouteProcessor), make(map[string]bool)
+	for _, r := range rigConfig.Routers {
+		types[r.Parms.Id] = r.Type
+		disabled[r.Parms.Id] = !r.Parms.Enabled
+	}
+
+	sim := &wire.Simulation{AlertId: ac.AlertId, At: at, Schedules: make([]wire.SimulatedSchedule, 0)}
+	for _, sap := range ac.Schedule {
+		s := wire.SimulatedSchedule{ScheduleId: sap.Id, RouterId: sap.RouterId, RouterType: types[sap.RouterId]}
+		s.Enabled, s.Reason, s.NextTransition, err = stateAt(sap.ScheduleStart, sap.ScheduleEnd, at.In(time.Local))
+		if err != nil {
+			return nil, errors.Wrapf(err, "schedule %s", sap.Id)
+		}
+		if s.Enabled && disabled[sap.RouterId] {
+			s.Enabled, s.Reason = false, "router disabled"
+		}
+		if s.Enabled {
+			r, ok := alertRouters[sap.RouterId]
+			if renderer, canRender := r.(routers.Renderer); ok && canRender {
+				s.Notification, err = renderer.Render(&routers.Event{Id: event.Id, Message: event.Message}, sap)
+				if err != nil {
+					s.Error = err.Error()
+				}
+			} else if !ok {
+				s.Error = "unknown router"
+			}
+		}
+		sim.Schedules = append(sim.Schedules, s)
+	}
+	return sim, nil
+}
+
+// Simulate an alert of the running configuration
+func (rm *RouteMgr) Simulate(event *routers.Event, at time.Time) (*wire.Simulation, bool, error) {
+	rm.lock.RLock()
+	rigConfig, alertRouters, ac := rm.config, rm.alertRouters, rm.configs[event.Id]
+	rm.lock.RUnlock()
+	if ac == nil {
+		return nil, false, nil
+	}
+	sim, err := simulate(rigConfig, alertRouters, ac, event, at)
+	return sim, true, err
+}
+
+// Whether a schedule with the given start and end specs is enabled at
+// at, why, and when that next changes.  A schedule is enabled by its
+// start and disabled by its end; one without a start starts enabled.
+func stateAt(start, end string, at time.Time) (bool, string, *time.Time, error) {
+	var startSched, endSched cron.Schedule
+	var err error
+	if start != "" {
+		if startSched, err = cron.Parse("0 " + start); err != nil {
+			return false, "", nil, err
+		}
+	}
+	if end != "" {
+		if endSched, err = cron.Parse("0 " + end); err != nil {
+			return false, "", nil, err
+		}
+	}
+	lastStart, started := lastRun(startSched, at)
+	lastEnd, ended := lastRun(endSched, at)
+
+	var enabled bool
+	var reason string
+	switch {
+	case start == "" && end == "":
+		enabled, reason = true, "no start or end"
+	case started && (!ended || lastStart.After(lastEnd)):
+		enabled, reason = true, "started "+lastStart.Format(time.RFC3339)
+	case ended:
+		enabled, reason = false, "ended "+lastEnd.Format(time.RFC3339)
+	case start == "":
+		enabled, reason = true, "no start"
+	default:
+		enabled, reason = false, "not started"
+	}
+
+	next := startSched
+	if enabled {
+		next = endSched
+	}
+	if next == nil {
+		return enabled, reason, nil, nil
+	}
+	t := next.Next(at)
+	return enabled, reason, &t, nil
+}
+
+// The last time schedule ran at or before at, if within the longest
+// lookback
+func lastRun(schedule cron.Schedule, at time.Time) (time.Time, bool) {
+	if schedule == nil {
+		return time.Time{}, false
+	}
+	for _, lookback := range lookbacks {
+		var last time.Time
+		for t := schedule.Next(at.Add(-lookback)); !t.IsZero() && !t.After(at); t = schedule.Next(t) {
+			last = t
+		}
+		if !last.IsZero() {
+			return last, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,134 @@
+package routemgr
+
+import (
+	"github.com/gregaland/alert-router/config"
+	"github.com/gregaland/alert-router/metrics"
+	"github.com/gregaland/alert-router/wire"
+	"github.com/robfig/cron"
+	"sort"
+	"time"
+)
+
+// Selects alerts from a listing.  Zero values match everything.
+type AlertFilter struct {
+	// has a schedule routed to RouterId
+	RouterId string
+	// has every label; an empty value only requires the key
+	Labels map[string]string
+	// has a schedule enabled now, or none when false
+	Enabled *bool
+}
+
+// Match reports whether as passes the filter
+func (f *AlertFilter) Match(as *wire.AlertStatus) bool {
+	for k, v := range f.Labels {
+		if lv, ok := as.Config.Labels[k]; !ok || (v != "" && lv != v) {
+			return false
+		}
+	}
+	routed, enabled := f.RouterId == "", false
+	for _, s := range as.Status {
+		routed = routed || s.RouterId == f.RouterId
+		enabled = enabled || s.EnabledNow
+	}
+	return routed && (f.Enabled == nil || *f.Enabled == enabled)
+}
+
+// GetAlertStatus returns an alert with the live state of its schedules
+func (rm *RouteMgr) GetAlertStatus(alertId string) (*wire.AlertStatus, bool) {
+	rm.lock.RLock()
+	defer rm.lock.RUnlock()
+	if _, ok := rm.alerts[alertId]; !ok {
+		return nil, false
+	}
+	return rm.alertStatus(alertId, rm.routerTypes(), time.Now()), true
+}
+
+// ListAlertStatus returns the alerts that pass filter ordered by id
+func (rm *RouteMgr) ListAlertStatus(filter *AlertFilter) []*wire.AlertStatus {
+	rm.lock.RLock()
+	defer rm.lock.RUnlock()
+
+	types, now := rm.routerTypes(), time.Now()
+	result := make([]*wire.AlertStatus, 0, len(rm.alerts))
+	for alertId := range rm.alerts {
+		as := rm.alertStatus(alertId, types, now)
+		if filter == nil || filter.Match(as) {
+			result = append(result, as)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].AlertId < result[j].AlertId
+	})
+	return result
+}
+
+// Callers hold lock
+func (rm *RouteMgr) alertStatus(alertId string, types map[string]config.RouteProcessor, now time.Time) *wire.AlertStatus {
+	as := &wire.AlertStatus{AlertId: alertId, Status: make([]wire.ScheduleStatus, 0)}
+	if ac, ok := rm.configs[alertId]; ok {
+		c := *ac
+		as.Config = &c
+		as.Revision = ac.Revision
+	} else {
+		as.Config = &config.AlertConfig{AlertId: alertId, Schedule: make([]config.RouterParms, 0)}
+		for _, sa := range rm.alerts[alertId] {
+			as.Config.Schedule = append(as.Config.Schedule, sa.Config)
+		}
+	}
+	for _, sa := range rm.alerts[alertId] {
+		s := wire.ScheduleStatus{
+			Id:         sa.Config.Id,
+			RouterId:   sa.Config.RouterId,
+			RouterType: types[sa.Config.RouterId],
+			EnabledNow: sa.Enabled(),
+		}
+		// an enabled schedule next changes at its end, a disabled one
+		// at its start
+		spec := sa.Config.ScheduleStart
+		if s.EnabledNow {
+			spec = sa.Config.ScheduleEnd
+		}
+		if spec != "" {
+			if schedule, err := cron.Parse("0 " + spec); err == nil {
+				next := schedule.Next(now)
+				s.NextTransition = &next
+			}
+		}
+		as.Status = append(as.Status, s)
+	}
+	return as
+}
+
+// Callers hold lock
+func (rm *RouteMgr) routerTypes() map[string]config.RouteProcessor {
+	types := make(map[string]config.RouteProcessor)
+	for _, r := range rm.config.Routers {
+		types[r.Parms.Id] = r.Type
+	}
+	return types
+}
+
+// UpdateMetrics sets the gauges that are read from the live state: the
+// deliveries in progress and the schedules enabled per router
+func (rm *RouteMgr) UpdateMetrics() {
+	metrics.QueueDepth.Set(float64(rm.deliveries.count()))
+
+	rm.lock.RLock()
+	defer rm.lock.RUnlock()
+	enabled := make(map[string]int)
+	for _, r := range rm.config.Routers {
+		enabled[r.Parms.Id] = 0
+	}
+	for _, schedules := range rm.alerts {
+		for _, sa := range schedules {
+			if sa.Enabled() {
+				enabled[sa.Config.RouterId]++
+			}
+		}
+	}
+	metrics.EnabledSchedules.Reset()
+	for routerId, n := range enabled {
+		metrics.EnabledSchedules.WithLabelValues(routerId).Set(float64(n))
+	}
+}
@@ -0,0 +1,50 @@
+package routemgr
+
+import (
+	"github.com/gregaland/alert-router/config"
+	"github.com/gregaland/alert-router/store"
+	log "github.com/sirupsen/logrus"
+)
+
+// Drops the alerts whose ids the id grammar rejects.  Older versions
+// accepted them, so such an alert is logged and skipped rather than
+// keeping every other alert from loading.  check-config reports them.
+func skipInvalidIds(alertConfigs []*config.AlertConfig) []*config.AlertConfig {
+	valid := alertConfigs[:0]
+	for _, ac := range alertConfigs {
+		if err := ac.IdError(); err != nil {
+			log.WithFields(log.Fields{
+				"alert_id": ac.AlertId,
+				"source":   ac.Source,
+			}).Warnf("skipping alert: %v, ids are lower case letters, digits, '.', '_' and '-'", err)
+			continue
+		}
+		valid = append(valid, ac)
+	}
+	return valid
+}
+
+// OpenStore opens the store configured in rigConfig
+func OpenStore(rigConfig *config.RigConfig) (store.Store, error) {
+	return store.New(rigConfig.Store, rigConfig.AlertsPath)
+}
+
+// Reports whether moving from a to b needs the store to be reopened
+func storeChanged(a, b *config.RigConfig) bool {
+	sa, sb := a.Store, b.Store
+	if sa == nil {
+		sa = &store.Config{}
+	}
+	if sb == nil {
+		sb = &store.Config{}
+	}
+	if *sa != *sb {
+		return true
+	}
+	return sa.Type != store.BOLT_STORE && a.AlertsPath != b.AlertsPath
+}
+
+// Reports whether alerts are kept as files in the alerts directory
+func dirStore(rigConfig *config.RigConfig) bool {
+	return rigConfig.Store == nil || rigConfig.Store.Type == "" || rigConfig.Store.Type == store.DIR_STORE
+}
@@ -0,0 +1,179 @@
+package routemgr
+
+import (
+	"encoding/json"
+	"github.com/gregaland/alert-router/config"
+	"github.com/gregaland/alert-router/store"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+)
+
+// Returned for an alert config that fails to parse or validate
+type InvalidAlertError struct {
+	err   error
+	field string
+}
+
+func (e *InvalidAlertError) Error() string {
+	return e.err.Error()
+}
+
+// Field is the field of the alert config at fault, if known
+func (e *InvalidAlertError) Field() string {
+	if te, ok := errors.Cause(e.err).(*json.UnmarshalTypeError); ok && e.field == "" {
+		return te.Field
+	}
+	return e.field
+}
+
+// IsInvalidAlert reports whether err was caused by a bad alert config
+func IsInvalidAlert(err error) bool {
+	_, ok := errors.Cause(err).(*InvalidAlertError)
+	return ok
+}
+
+// GetAlert returns a copy of an alert's config, which carries the revision
+// of its record
+func (rm *RouteMgr) GetAlert(alertId string) (*config.AlertConfig, bool) {
+	rm.lock.RLock()
+	defer rm.lock.RUnlock()
+	ac, ok := rm.configs[alertId]
+	if !ok {
+		return nil, false
+	}
+	c := *ac
+	return &c, true
+}
+
+// UpdateAlert replaces an alert with the config in the request body.  The
+// new config is parsed and validated before anything is changed.  Secrets
+// sent back redacted keep their values.  A non
+// empty revision must match the alert's current one.  Returns the new
+// revision.
+func (rm *RouteMgr) UpdateAlert(alertId string, r *http.Request, revision string) (bool, string, error) {
+	rm.writeLock.Lock()
+	defer rm.writeLock.Unlock()
+
+	current, ok := rm.configs[alertId]
+	if !ok {
+		return false, "", nil
+	}
+	if err := rm.checkChange(current, revision); err != nil {
+		return true, "", err
+	}
+
+	ac, err := decodeAlert(alertId, r)
+	if err != nil {
+		return true, "", err
+	}
+	ac.KeepSecrets(current)
+	rev, err := rm.putAlert(source(current), ac, revision)
+	return true, rev, err
+}
+
+// PatchSchedule applies a JSON merge patch to one schedule of an alert.
+// A non empty revision must match the alert's current one.  Returns the
+// new revision.
+func (rm *RouteMgr) PatchSchedule(alertId, scheduleId string, patch []byte, revision string) (bool, string, error) {
+	rm.writeLock.Lock()
+	defer rm.writeLock.Unlock()
+
+	current, ok := rm.configs[alertId]
+	if !ok {
+		return false, "", nil
+	}
+	i := 0
+	for i < len(current.Schedule) && current.Schedule[i].Id != scheduleId {
+		i++
+	}
+	if i == len(current.Schedule) {
+		return false, "", nil
+	}
+	if err := rm.checkChange(current, revision); err != nil {
+		return true, "", err
+	}
+
+	rp, err := config.PatchRouterParms(current.Schedule[i], patch)
+	if err == nil && rp.Id != scheduleId {
+		err = errors.Errorf("schedule id can't be changed from %s to %s", scheduleId, rp.Id)
+	}
+	if err != nil {
+		log.Errorf("failed to patch schedule: %v", err)
+		return true, "", &InvalidAlertError{err: err}
+	}
+	rp.KeepSecrets(&current.Schedule[i])
+
+	ac := *current
+	ac.Schedule = append([]config.RouterParms(nil), current.Schedule...)
+	ac.Schedule[i] = rp
+	rev, err := rm.putAlert(source(current), &ac, revision)
+	return true, rev, err
+}
+
+// Parse the alert config in a request body, as YAML or JSON depending on
+// the content type.  Its alert id, if given, has to be alertId.
+func decodeAlert(alertId string, r *http.Request) (*config.AlertConfig, error) {
+	ac, err := config.DecodeAlertConfig(r.Body, r.Header.Get("Content-Type"))
+	if err == nil && ac.AlertId != "" && ac.AlertId != alertId {
+		err = &InvalidAlertError{errors.Errorf("alert id %s does not match %s", ac.AlertId, alertId), "alert"}
+	}
+	if err != nil {
+		log.Errorf("failed to parse alert config: %v", err)
+		if !IsInvalidAlert(err) {
+			err = &InvalidAlertError{err: err}
+		}
+		return nil, err
+	}
+	ac.AlertId = alertId
+	return ac, nil
+}
+
+// Checks that an alert can be replaced or deleted: its record holds just
+// this alert, and revision, if given, is the current one.  Callers hold
+// writeLock.
+func (rm *RouteMgr) checkChange(current *config.AlertConfig, revision string) error {
+	for id, ac := range rm.configs {
+		if id != current.AlertId && ac.Source == current.Source && current.Source != "" {
+			return errors.Wrapf(ErrSharedFile, "%s is defined in %s", current.AlertId, current.Source)
+		}
+	}
+	if revision != "" && revision != current.Revision {
+		return errors.Wrapf(store.ErrConflict, "alert %s", current.AlertId)
+	}
+	return nil
+}
+
+// Validates ac, writes it to the record source and then swaps it in for
+// the running alert of the same id.  Nothing changes if any step fails.
+// Callers hold writeLock.
+func (rm *RouteMgr) putAlert(source string, ac *config.AlertConfig, revision string) (string, error) {
+	if err := rm.config.ValidateAlert(ac); err != nil {
+		log.Errorf("invalid alert config: %v", err)
+		return "", &InvalidAlertError{err: err}
+	}
+	out, err := config.MarshalPlainYAML(ac)
+	if err != nil {
+		return "", err
+	}
+	rev, err := rm.store.Put(store.KIND_ALERTS, source, out, revision)
+	if err != nil {
+		log.Error(err)
+		return "", err
+	}
+	ac.Source = source
+	ac.Revision = rev
+
+	rm.lock.Lock()
+	defer rm.lock.Unlock()
+	rm.addAlertConfig(ac)
+	return rev, nil
+}
+
+// The record an alert is kept in
+func source(ac *config.AlertConfig) string {
+	if ac.Source != "" {
+		return ac.Source
+	}
+	return ac.AlertId
+}
@@ -0,0 +1,106 @@
+package routemgr
+
+import (
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Changes in the alerts directory are collected for this long before a
+// reload, so a burst of writes causes one reload
+const WATCH_DEBOUNCE = 2 * time.Second
+
+type watcher struct {
+	fsw  *fsnotify.Watcher
+	done chan struct{}
+}
+
+// Private function that reloads when files in the alerts directory change
+func (rm *RouteMgr) watchAlerts() error {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	err = watchDirs(fsw, rm.config.AlertsPath)
+	if err != nil {
+		fsw.Close()
+		return err
+	}
+	rm.watcher = &watcher{fsw: fsw, done: make(chan struct{})}
+
+	log.WithFields(log.Fields{
+		"path": rm.config.AlertsPath,
+	}).Info("watching alerts directory")
+
+	go func(w *watcher) {
+		var pending <-chan time.Time
+		for {
+			select {
+			case event, ok := <-w.fsw.Events:
+				if !ok {
+					return
+				}
+				// hidden files aren't alerts
+				if strings.HasPrefix(filepath.Base(event.Name), ".") {
+					continue
+				}
+				log.WithFields(log.Fields{
+					"file": event.Name,
+					"op":   event.Op.String(),
+				}).Debug("alerts directory changed")
+				// watch new subdirectories too
+				if event.Op&fsnotify.Create != 0 {
+					if fi, err := os.Stat(event.Name); err == nil && fi.IsDir() {
+						if err = watchDirs(w.fsw, event.Name); err != nil {
+							log.Error(err)
+						}
+					}
+				}
+				pending = time.After(WATCH_DEBOUNCE)
+			case err, ok := <-w.fsw.Errors:
+				if !ok {
+					return
+				}
+				log.Error(err)
+			case <-pending:
+				pending = nil
+				if _, err := rm.Reload(); err == ErrClosed {
+					return
+				}
+			case <-w.done:
+				return
+			}
+		}
+	}(rm.watcher)
+
+	return nil
+}
+
+// Add path and the directories below it to the watcher.  Hidden
+// directories are skipped like they are when loading alerts.
+func watchDirs(fsw *fsnotify.Watcher, path string) error {
+	return filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if p != path && strings.HasPrefix(info.Name(), ".") {
+			return filepath.SkipDir
+		}
+		return fsw.Add(p)
+	})
+}
+
+// Private function that stops the alerts directory watcher
+func (rm *RouteMgr) stopWatching() {
+	if rm.watcher != nil {
+		close(rm.watcher.done)
+		rm.watcher.fsw.Close()
+		rm.watcher = nil
+	}
+}
@@ -4,8 +4,11 @@ import (
 	"errors"
 	"fmt"
 	"github.com/gregaland/alert-router/config"
+	"github.com/gregaland/alert-router/wire"
 	log "github.com/sirupsen/logrus"
+	"net"
 	"net/smtp"
+	"time"
 )
 
 const (
@@ -15,13 +18,13 @@ const (
 )
 
 type EmailConfig struct {
-	SmtpHost   string // required
-	SmtpPort   int    // required
-	Au         string // defaults
-	Ap         string // defaults
-	From       string // defaults
-	MsgHdr     string // defaults
-	MaxMsgSize int    // defaults
+	SmtpHost   string        // required
+	SmtpPort   int           // required
+	Au         config.Secret // defaults
+	Ap         config.Secret // defaults
+	From       string        // defaults
+	MsgHdr     string        // defaults
+	MaxMsgSize int           // defaults
 }
 
 type EmailRouter struct {
@@ -69,14 +72,48 @@ func NewEmailRouter(config *EmailConfig) (Router, error) {
 }
 
 func (e *EmailRouter) Init() error {
-	e.auth = smtp.PlainAuth("", e.Config.Au, e.Config.Ap, e.Config.SmtpHost)
+	e.auth = smtp.PlainAuth("", e.Config.Au.Reveal(), e.Config.Ap.Reveal(), e.Config.SmtpHost)
 	return nil
 }
 
+// Probe connects to the SMTP server and sends EHLO and NOOP
+func (e *EmailRouter) Probe() error {
+	conn, err := net.DialTimeout("tcp", e.smtpHostPort, PROBE_TIMEOUT)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(PROBE_TIMEOUT))
+	c, err := smtp.NewClient(conn, e.Config.SmtpHost)
+	if err != nil {
+		return err
+	}
+	if err = c.Hello("localhost"); err == nil {
+		err = c.Noop()
+	}
+	if err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
 func (e *EmailRouter) GetConfig() interface{} {
 	return *e.Config
 }
 
+// Render returns the recipients and the message, headers included
+func (e *EmailRouter) Render(event *Event, t interface{}) (*wire.Notification, error) {
+	params, ok := t.(config.RouterParms)
+	if !ok {
+		return nil, errors.New("expected RouterParms")
+	}
+	message := event.Message
+	if len(message) > e.Config.MaxMsgSize {
+		message = message[:e.Config.MaxMsgSize]
+	}
+	return &wire.Notification{Recipients: params.EmailAddrs, Message: e.Config.MsgHdr + event.Id + "\r\n" + message}, nil
+}
+
 func (e *EmailRouter) Route(event *Event, t interface{}) error {
 	log.Debug("entering email route")
 	var err error = nil
@@ -86,7 +123,8 @@ func (e *EmailRouter) Route(event *Event, t interface{}) error {
 		if len(event.Message) > e.Config.MaxMsgSize {
 			event.Message = event.Message[:e.Config.MaxMsgSize]
 		}
-		msg := []byte(e.Config.MsgHdr + event.Id + "\r\n" + event.Message)
+		n, _ := e.Render(event, params)
+		msg := []byte(n.Message)
 
 		log.WithFields(log.Fields{
 			"id":       event.Id,
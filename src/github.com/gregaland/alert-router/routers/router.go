@@ -1,7 +1,14 @@
 package routers
 
+import (
+	"github.com/gregaland/alert-router/wire"
+	"github.com/pkg/errors"
+	"net/url"
+	"time"
+)
+
 type Event struct {
-	Id string
+	Id      string
 	Message string
 }
 
@@ -9,4 +16,28 @@ type Router interface {
 	Init() error
 	GetConfig() interface{}
 	Route(*Event, interface{}) error
-}
\ No newline at end of file
+}
+
+// How long a probe waits for a router's service
+const PROBE_TIMEOUT = 5 * time.Second
+
+// Renderer is implemented by routers that can show what they would send
+// for an event without sending it
+type Renderer interface {
+	Render(*Event, interface{}) (*wire.Notification, error)
+}
+
+// Prober is implemented by routers that can check their service is
+// reachable without sending an alert
+type Prober interface {
+	Probe() error
+}
+
+// RedactError drops the url that http errors quote, which can hold a
+// webhook secret
+func RedactError(err error) error {
+	if ue, ok := errors.Cause(err).(*url.Error); ok {
+		return ue.Err
+	}
+	return err
+}
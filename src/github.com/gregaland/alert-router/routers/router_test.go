@@ -1,9 +1,15 @@
 package routers
 
 import (
+	"bytes"
+	"github.com/gregaland/alert-router/config"
+	log "github.com/sirupsen/logrus"
 	"gotest.tools/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
 	"testing"
-	"github.com/gregaland/alert-router/config"
 )
 
 func TestNewEmailRouter(t *testing.T) {
@@ -17,8 +23,8 @@ func TestNewEmailRouter(t *testing.T) {
 	// TODO need to figure out how to pass AU/AP
 	c = EmailConfig{SmtpHost: "smtp.gmail.com", SmtpPort: 587}
 	expect := EmailConfig{SmtpHost: "smtp.gmail.com", SmtpPort: 587,
-		MsgHdr: EMAIL_DEFAULT_MSG_HDR, From: EMAIL_DEFAULT_FROM, Au: EMAIL_DEFAULT_AU,
-	    Ap: EMAIL_DEFAULT_AP, MaxMsgSize: EMAIL_DEFAULT_MAX_MSG_SIZE}
+		MsgHdr: EMAIL_DEFAULT_MSG_HDR, From: EMAIL_DEFAULT_FROM,
+		MaxMsgSize: EMAIL_DEFAULT_MAX_MSG_SIZE}
 
 	e, err := NewEmailRouter(&c)
 	c = e.GetConfig().(EmailConfig)
@@ -48,3 +54,47 @@ func TestEmailRouter_Route(t *testing.T) {
 	routes["email"].Route(event, params)
 
 }
+
+func TestRouters_RedactLogs(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	log.SetLevel(log.DebugLevel)
+	defer log.SetOutput(os.Stderr)
+	defer log.SetLevel(log.InfoLevel)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	url := ts.URL + "/services/T000/B000/XXXX"
+
+	for _, f := range []log.Formatter{&log.TextFormatter{}, &log.JSONFormatter{}} {
+		log.SetFormatter(f)
+		e, err := NewEmailRouter(&EmailConfig{SmtpHost: "localhost", SmtpPort: 25,
+			Au: "svc-alerts", Ap: "hunter2"})
+		assert.NilError(t, err)
+		assert.NilError(t, e.Init())
+
+		s, err := NewSlackRouter(&SlackConfig{Url: config.Secret(url)})
+		assert.NilError(t, err)
+		assert.NilError(t, s.Route(&Event{Id: "dbfail", Message: "db is down"}, config.RouterParms{}))
+		assert.Equal(t, url, s.GetConfig().(SlackConfig).Url.Reveal())
+	}
+
+	// failures are returned without the url
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	s, err := NewSlackRouter(&SlackConfig{Url: config.Secret(url)})
+	assert.NilError(t, err)
+	assert.ErrorContains(t, s.Route(&Event{Id: "dbfail", Message: "db is down"}, config.RouterParms{}), "status code: 500")
+	s, err = NewSlackRouter(&SlackConfig{Url: config.Secret("http://127.0.0.1:1/services/T000/B000/XXXX")})
+	assert.NilError(t, err)
+	err = s.Route(&Event{Id: "dbfail", Message: "db is down"}, config.RouterParms{})
+	assert.Assert(t, err != nil)
+	assert.Assert(t, !strings.Contains(err.Error(), "XXXX"), err.Error())
+
+	out := buf.String()
+	assert.Assert(t, strings.Contains(out, config.REDACTED))
+	for _, secret := range []string{"hunter2", "svc-alerts", "XXXX"} {
+		assert.Assert(t, !strings.Contains(out, secret), "%s found in: %s", secret, out)
+	}
+}
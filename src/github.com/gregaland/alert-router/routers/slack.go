@@ -3,6 +3,9 @@ package routers
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
+	"github.com/gregaland/alert-router/config"
+	"github.com/gregaland/alert-router/wire"
 	log "github.com/sirupsen/logrus"
 	"net/http"
 )
@@ -16,7 +19,7 @@ type SlackMessage struct {
 }
 
 type SlackConfig struct {
-	Url        string
+	Url        config.Secret
 	MaxMsgSize int // defaults
 }
 
@@ -41,10 +44,39 @@ func (e *SlackRouter) Init() error {
 	return nil
 }
 
+// Probe sends a HEAD request to the webhook url.  Any response short of
+// a server error means it is reachable.  Errors leave out the url, which
+// is a secret.
+func (e *SlackRouter) Probe() error {
+	client := &http.Client{Timeout: PROBE_TIMEOUT}
+	resp, err := client.Head(e.Config.Url.Reveal())
+	if err != nil {
+		return RedactError(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("status code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
 func (e *SlackRouter) GetConfig() interface{} {
 	return *e.Config
 }
 
+// Render returns the JSON posted to the webhook
+func (e *SlackRouter) Render(event *Event, t interface{}) (*wire.Notification, error) {
+	message := event.Message
+	if len(message) > e.Config.MaxMsgSize {
+		message = message[:e.Config.MaxMsgSize]
+	}
+	msg, err := json.Marshal(&SlackMessage{Text: event.Id + ": " + message})
+	if err != nil {
+		return nil, err
+	}
+	return &wire.Notification{Message: string(msg)}, nil
+}
+
 func (e *SlackRouter) Route(event *Event, t interface{}) error {
 	log.Debug("entering slack route")
 	var err error = nil
@@ -58,23 +90,29 @@ func (e *SlackRouter) Route(event *Event, t interface{}) error {
 	if len(event.Message) > e.Config.MaxMsgSize {
 		event.Message = event.Message[:e.Config.MaxMsgSize]
 	}
-	msg, err := json.Marshal(&SlackMessage{Text: event.Id + ": " + event.Message})
+	n, err := e.Render(event, t)
 	if err != nil {
 		log.Error(err)
-	} else {
-		req, err := http.NewRequest("POST", e.Config.Url, bytes.NewBuffer(msg))
-		if err != nil {
-			log.Error(err)
-		}
-		req.Header.Set("Content-Type", "application/json")
-		client := &http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			log.Error(err)
-		} else if resp.StatusCode != http.StatusOK {
-			log.Errorf("Status Code: %d", resp.StatusCode)
-		}
+		return err
 	}
-
-	return err
+	req, err := http.NewRequest("POST", e.Config.Url.Reveal(), bytes.NewBufferString(n.Message))
+	if err != nil {
+		err = RedactError(err)
+		log.Error(err)
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		err = RedactError(err)
+		log.Error(err)
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Errorf("Status Code: %d", resp.StatusCode)
+		return fmt.Errorf("status code: %d", resp.StatusCode)
+	}
+	return nil
 }
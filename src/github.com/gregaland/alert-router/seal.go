@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"github.com/gregaland/alert-router/secrets"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+)
+
+// seal-secrets subcommand.  Encrypts a secrets file for the encfile
+// secrets provider, optionally generating the master key first.
+func sealSecrets(args []string) int {
+	fs := flag.NewFlagSet("seal-secrets", flag.ExitOnError)
+	keyFile := fs.String("k", "/etc/alert-router.key", "Path to master key file")
+	genKey := fs.Bool("genkey", false, "Generate a new master key at -k")
+	force := fs.Bool("force", false, "With -genkey, replace an existing master key")
+	in := fs.String("i", "", "Plaintext secrets file")
+	out := fs.String("o", "", "Sealed secrets file to write")
+	_ = fs.Parse(args)
+
+	if *genKey {
+		key, err := secrets.NewKey()
+		if err == nil {
+			err = writeKey(*keyFile, key[:], *force)
+		}
+		if os.IsExist(err) {
+			err = errors.Errorf("%s exists, files sealed with it can't be read with a new key; use -force to replace it", *keyFile)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	if *in == "" && *out == "" {
+		return 0
+	}
+	if *in == "" || *out == "" {
+		fmt.Fprintln(os.Stderr, "both -i and -o are required")
+		return 2
+	}
+
+	key, err := secrets.LoadKey(*keyFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	plaintext, err := ioutil.ReadFile(*in)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	sealed, err := secrets.Seal(key, plaintext)
+	if err == nil {
+		err = ioutil.WriteFile(*out, sealed, 0600)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// Write a master key to path.  An existing key is only replaced with
+// force set.
+func writeKey(path string, key []byte, force bool) error {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if force {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, flags, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(hex.EncodeToString(key) + "\n")
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	return err
+}
@@ -0,0 +1,100 @@
+package secrets
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/nacl/secretbox"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+const (
+	KEY_SIZE   int = 32
+	NONCE_SIZE int = 24
+)
+
+// EncFileProvider reads secrets from a file in the FileProvider format
+// that has been sealed with NaCl secretbox.  The file is the 24 byte nonce
+// followed by the sealed box.  The master key file holds the 32 byte key,
+// hex encoded.
+type EncFileProvider struct {
+	*FileProvider
+}
+
+func NewEncFileProvider(c *Config) (Provider, error) {
+	if c.Path == "" {
+		return nil, errors.New("encfile secrets provider requires a path")
+	}
+	if c.KeyFile == "" {
+		return nil, errors.New("encfile secrets provider requires a key_file")
+	}
+	key, err := LoadKey(c.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := ioutil.ReadFile(c.Path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := Open(key, sealed)
+	if err != nil {
+		return nil, errors.Wrap(err, c.Path)
+	}
+	fp, err := newFileProvider(data)
+	if err != nil {
+		return nil, err
+	}
+	return &EncFileProvider{FileProvider: fp}, nil
+}
+
+// NewKey returns a random master key
+func NewKey() (*[KEY_SIZE]byte, error) {
+	key := new([KEY_SIZE]byte)
+	if _, err := io.ReadFull(rand.Reader, key[:]); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// LoadKey reads a hex encoded master key file
+func LoadKey(path string) (*[KEY_SIZE]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding master key")
+	}
+	if len(raw) != KEY_SIZE {
+		return nil, errors.Errorf("master key must be %d bytes, got %d", KEY_SIZE, len(raw))
+	}
+	key := new([KEY_SIZE]byte)
+	copy(key[:], raw)
+	return key, nil
+}
+
+// Seal encrypts plaintext with key in the format read by EncFileProvider
+func Seal(key *[KEY_SIZE]byte, plaintext []byte) ([]byte, error) {
+	var nonce [NONCE_SIZE]byte
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return nil, err
+	}
+	return secretbox.Seal(nonce[:], plaintext, &nonce, key), nil
+}
+
+// Open decrypts data produced by Seal
+func Open(key *[KEY_SIZE]byte, sealed []byte) ([]byte, error) {
+	if len(sealed) < NONCE_SIZE+secretbox.Overhead {
+		return nil, errors.New("sealed secrets file is truncated")
+	}
+	var nonce [NONCE_SIZE]byte
+	copy(nonce[:], sealed[:NONCE_SIZE])
+	data, ok := secretbox.Open(nil, sealed[NONCE_SIZE:], &nonce, key)
+	if !ok {
+		return nil, errors.New("failed to decrypt secrets, wrong master key?")
+	}
+	return data, nil
+}
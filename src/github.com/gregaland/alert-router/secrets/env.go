@@ -0,0 +1,35 @@
+package secrets
+
+import (
+	"github.com/pkg/errors"
+	"os"
+	"strings"
+)
+
+// EnvProvider reads secrets from environment variables.  The variable
+// name is the path and key joined with '_', upper cased, with anything
+// other than letters and digits replaced by '_'.  secret://smtp/gmail#password
+// is read from SMTP_GMAIL_PASSWORD.
+type EnvProvider struct{}
+
+func NewEnvProvider(c *Config) (Provider, error) {
+	return &EnvProvider{}, nil
+}
+
+func EnvName(path, key string) string {
+	name := []byte(strings.ToUpper(path + "_" + key))
+	for i, c := range name {
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			name[i] = '_'
+		}
+	}
+	return string(name)
+}
+
+func (e *EnvProvider) Get(path, key string) (string, error) {
+	name := EnvName(path, key)
+	if v, ok := os.LookupEnv(name); ok {
+		return v, nil
+	}
+	return "", errors.Errorf("environment variable %s is not set", name)
+}
@@ -0,0 +1,45 @@
+package secrets
+
+import (
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+)
+
+// FileProvider reads secrets from a YAML file keyed by path and then key:
+//
+//	smtp/gmail:
+//	  username: alerts@gregland.dev
+//	  password: ...
+type FileProvider struct {
+	secrets map[string]map[string]string
+}
+
+func NewFileProvider(c *Config) (Provider, error) {
+	if c.Path == "" {
+		return nil, errors.New("file secrets provider requires a path")
+	}
+	data, err := ioutil.ReadFile(c.Path)
+	if err != nil {
+		return nil, err
+	}
+	return newFileProvider(data)
+}
+
+func newFileProvider(data []byte) (*FileProvider, error) {
+	fp := &FileProvider{}
+	err := yaml.UnmarshalStrict(data, &fp.secrets)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing secrets")
+	}
+	return fp, nil
+}
+
+func (f *FileProvider) Get(path, key string) (string, error) {
+	if values, ok := f.secrets[path]; ok {
+		if v, ok := values[key]; ok {
+			return v, nil
+		}
+	}
+	return "", errors.Errorf("secret not found: %s#%s", path, key)
+}
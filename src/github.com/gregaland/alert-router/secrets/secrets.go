@@ -0,0 +1,123 @@
+// Package secrets resolves secret references used in the alert-router
+// configuration.  A reference has the form
+//
+//	secret://<path>#<key>
+//
+// for example secret://smtp/gmail#password, and is looked up in the
+// configured Provider.
+package secrets
+
+import (
+	"github.com/pkg/errors"
+	"sort"
+	"strings"
+)
+
+const (
+	SCHEME           string = "secret://"
+	DEFAULT_PROVIDER string = "env"
+)
+
+// Provider looks up secret values
+type Provider interface {
+	Get(path, key string) (string, error)
+}
+
+// Factory builds a Provider from the secrets section of the main config
+type Factory func(*Config) (Provider, error)
+
+// Config selects and configures the secrets provider
+type Config struct {
+	Provider string `yaml:"provider"`
+	Path     string `yaml:"path,omitempty"`
+	KeyFile  string `yaml:"key_file,omitempty"`
+}
+
+// Ref is a parsed secret reference
+type Ref struct {
+	Path string
+	Key  string
+}
+
+var factories = map[string]Factory{
+	"env":     NewEnvProvider,
+	"file":    NewFileProvider,
+	"encfile": NewEncFileProvider,
+}
+
+// Register makes a provider available under name.  Registering an
+// existing name replaces it.
+func Register(name string, f Factory) {
+	factories[name] = f
+}
+
+// Providers returns the names of the registered providers
+func Providers() []string {
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewProvider returns the provider selected by c.  A nil config selects
+// the env provider.
+func NewProvider(c *Config) (Provider, error) {
+	if c == nil {
+		c = &Config{}
+	}
+	name := c.Provider
+	if name == "" {
+		name = DEFAULT_PROVIDER
+	}
+	f, ok := factories[name]
+	if !ok {
+		return nil, errors.Errorf("unknown secrets provider: %s", name)
+	}
+	return f(c)
+}
+
+// IsRef reports whether s is a secret reference
+func IsRef(s string) bool {
+	return strings.HasPrefix(s, SCHEME)
+}
+
+// ParseRef parses a secret://<path>#<key> reference
+func ParseRef(s string) (*Ref, error) {
+	if !IsRef(s) {
+		return nil, errors.New("not a secret reference")
+	}
+	rest := strings.TrimPrefix(s, SCHEME)
+	idx := strings.LastIndex(rest, "#")
+	if idx < 0 {
+		return nil, errors.Errorf("secret reference has no key: %s", s)
+	}
+	ref := &Ref{Path: strings.Trim(rest[:idx], "/"), Key: rest[idx+1:]}
+	if ref.Path == "" || ref.Key == "" {
+		return nil, errors.Errorf("malformed secret reference: %s", s)
+	}
+	return ref, nil
+}
+
+// String returns the reference in its secret://<path>#<key> form
+func (r *Ref) String() string {
+	return SCHEME + r.Path + "#" + r.Key
+}
+
+// Resolve returns the secret value for s if it is a reference, otherwise
+// s is returned unchanged.
+func Resolve(p Provider, s string) (string, error) {
+	if !IsRef(s) {
+		return s, nil
+	}
+	ref, err := ParseRef(s)
+	if err != nil {
+		return "", err
+	}
+	v, err := p.Get(ref.Path, ref.Key)
+	if err != nil {
+		return "", errors.Wrapf(err, "resolving %s", ref)
+	}
+	return v, nil
+}
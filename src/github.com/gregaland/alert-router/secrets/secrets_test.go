@@ -0,0 +1,95 @@
+package secrets
+
+import (
+	"encoding/hex"
+	"gotest.tools/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var secretsData = `
+smtp/gmail:
+  username: alerts@gregland.dev
+  password: hunter2
+slack/alerts:
+  url: https://hooks.slack.com/services/T000/B000/XXXX
+`
+
+func TestParseRef(t *testing.T) {
+	ref, err := ParseRef("secret://smtp/gmail#password")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, &Ref{Path: "smtp/gmail", Key: "password"}, ref)
+	assert.Equal(t, "secret://smtp/gmail#password", ref.String())
+
+	_, err = ParseRef("secret://smtp/gmail")
+	assert.ErrorContains(t, err, "no key")
+	_, err = ParseRef("secret://#password")
+	assert.ErrorContains(t, err, "malformed")
+	_, err = ParseRef("smtp/gmail#password")
+	assert.ErrorContains(t, err, "not a secret reference")
+}
+
+func TestEnvProvider(t *testing.T) {
+	os.Setenv("SMTP_GMAIL_PASSWORD", "hunter2")
+	defer os.Unsetenv("SMTP_GMAIL_PASSWORD")
+
+	p, err := NewProvider(nil)
+	assert.NilError(t, err)
+	v, err := Resolve(p, "secret://smtp/gmail#password")
+	assert.NilError(t, err)
+	assert.Equal(t, "hunter2", v)
+
+	_, err = Resolve(p, "secret://smtp/gmail#username")
+	assert.ErrorContains(t, err, "SMTP_GMAIL_USERNAME is not set")
+
+	// plain values are passed through
+	v, err = Resolve(p, "smtp.gmail.com")
+	assert.NilError(t, err)
+	assert.Equal(t, "smtp.gmail.com", v)
+}
+
+func TestFileProviders(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	plain := filepath.Join(dir, "secrets.yml")
+	assert.NilError(t, ioutil.WriteFile(plain, []byte(secretsData), 0600))
+
+	key, err := NewKey()
+	assert.NilError(t, err)
+	keyFile := filepath.Join(dir, "master.key")
+	assert.NilError(t, ioutil.WriteFile(keyFile, []byte(hex.EncodeToString(key[:])), 0600))
+	sealed, err := Seal(key, []byte(secretsData))
+	assert.NilError(t, err)
+	enc := filepath.Join(dir, "secrets.enc")
+	assert.NilError(t, ioutil.WriteFile(enc, sealed, 0600))
+
+	for _, c := range []*Config{
+		{Provider: "file", Path: plain},
+		{Provider: "encfile", Path: enc, KeyFile: keyFile},
+	} {
+		p, err := NewProvider(c)
+		assert.NilError(t, err)
+		v, err := Resolve(p, "secret://smtp/gmail#password")
+		assert.NilError(t, err)
+		assert.Equal(t, "hunter2", v)
+		v, err = Resolve(p, "secret://slack/alerts#url")
+		assert.NilError(t, err)
+		assert.Equal(t, "https://hooks.slack.com/services/T000/B000/XXXX", v)
+		_, err = Resolve(p, "secret://slack/alerts#token")
+		assert.ErrorContains(t, err, "secret not found")
+	}
+
+	// wrong master key
+	other, err := NewKey()
+	assert.NilError(t, err)
+	assert.NilError(t, ioutil.WriteFile(keyFile, []byte(hex.EncodeToString(other[:])), 0600))
+	_, err = NewProvider(&Config{Provider: "encfile", Path: enc, KeyFile: keyFile})
+	assert.ErrorContains(t, err, "failed to decrypt")
+
+	_, err = NewProvider(&Config{Provider: "vault"})
+	assert.ErrorContains(t, err, "unknown secrets provider")
+}
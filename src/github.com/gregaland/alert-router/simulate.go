@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/gregaland/alert-router/config"
+	"github.com/gregaland/alert-router/routemgr"
+	"github.com/gregaland/alert-router/routers"
+	"github.com/gregaland/alert-router/store"
+	"github.com/gregaland/alert-router/wire"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"strings"
+	"time"
+)
+
+// simulate subcommand.  Shows which schedules of an alert would be
+// enabled at an instant and what their routers would send, without
+// sending anything or contacting a running server.  The store is only
+// read.
+func simulate(args []string) int {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	configFile := fs.String("c", "/etc/alert-router.yml", "Path to configuration file")
+	alertId := fs.String("a", "", "Alert ID")
+	atStr := fs.String("t", "", "Instant to simulate, RFC 3339 (default now)")
+	msg := fs.String("m", "simulated alert", "Message to render")
+	asJson := fs.Bool("json", false, "Print the result as JSON")
+	_ = fs.Parse(args)
+	log.SetLevel(log.WarnLevel)
+
+	if *alertId == "" {
+		fmt.Fprintln(os.Stderr, "an alert id is required (-a)")
+		return 2
+	}
+	at := time.Now()
+	if *atStr != "" {
+		var err error
+		if at, err = time.Parse(time.RFC3339, *atStr); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+
+	rigConfig, err := config.LoadRigConfig(*configFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	st, err := store.NewReadOnly(rigConfig.Store, rigConfig.AlertsPath)
+	if errors.Cause(err) == store.ErrLocked {
+		fmt.Fprintf(os.Stderr, "%v, use alert-router ctl simulate while the server runs\n", err)
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer st.Close()
+	rigConfig, err = routemgr.WithStoredRouters(rigConfig, st)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	alertConfigs, err := config.LoadAlerts(st)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	var ac *config.AlertConfig
+	for _, c := range alertConfigs {
+		if c.AlertId == *alertId {
+			ac = c
+		}
+	}
+	if ac == nil {
+		fmt.Fprintf(os.Stderr, "unknown alert %s\n", *alertId)
+		return 1
+	}
+	sim, err := routemgr.Simulate(rigConfig, ac, &routers.Event{Id: ac.AlertId, Message: *msg}, at)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if *asJson {
+		out, _ := json.MarshalIndent(sim, "", "  ")
+		fmt.Println(string(out))
+		return 0
+	}
+	printSimulation(sim)
+	return 0
+}
+
+// Print a simulation for people
+func printSimulation(sim *wire.Simulation) {
+	fmt.Printf("%s at %s\n", sim.AlertId, sim.At.Format(time.RFC3339))
+	for _, s := range sim.Schedules {
+		state := "not notified"
+		if s.Enabled {
+			state = "notified"
+		}
+		fmt.Printf("  %s -> %s (%s): %s, %s\n", s.ScheduleId, s.RouterId, s.RouterType, state, s.Reason)
+		if s.NextTransition != nil {
+			fmt.Printf("    until %s\n", s.NextTransition.Format(time.RFC3339))
+		}
+		if s.Error != "" {
+			fmt.Printf("    error: %s\n", s.Error)
+		}
+		if n := s.Notification; n != nil {
+			if len(n.Recipients) > 0 {
+				fmt.Printf("    to: %s\n", strings.Join(n.Recipients, ", "))
+			}
+			fmt.Printf("    message: %q\n", n.Message)
+		}
+	}
+}
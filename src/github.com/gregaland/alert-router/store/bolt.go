@@ -0,0 +1,145 @@
+package store
+
+import (
+	"encoding/binary"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+	"strconv"
+	"time"
+)
+
+// BoltStore keeps records in an embedded bbolt database, one bucket per
+// kind.  Values are the 8 byte revision followed by the data.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore opens or creates the database at path.  The database is
+// locked while open; opening it a second time fails with ErrLocked after
+// a second.
+func NewBoltStore(path string) (*BoltStore, error) {
+	return openBolt(path, false)
+}
+
+func openBolt(path string, readOnly bool) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second, ReadOnly: readOnly})
+	if err == bolt.ErrTimeout {
+		err = ErrLocked
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, path)
+	}
+	return &BoltStore{db: db}, nil
+}
+
+func decodeValue(id string, v []byte) *Record {
+	data := make([]byte, len(v)-8)
+	copy(data, v[8:])
+	rev := strconv.FormatUint(binary.BigEndian.Uint64(v[:8]), 10)
+	return &Record{Id: id, Revision: rev, Data: data}
+}
+
+func (b *BoltStore) List(kind string) ([]*Record, error) {
+	records := make([]*Record, 0)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(kind))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			records = append(records, decodeValue(string(k), v))
+			return nil
+		})
+	})
+	return records, err
+}
+
+func (b *BoltStore) Get(kind, id string) (*Record, error) {
+	var record *Record
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var err error
+		record, err = get(tx, kind, id)
+		return err
+	})
+	return record, err
+}
+
+func get(tx *bolt.Tx, kind, id string) (*Record, error) {
+	if !ValidId(id) {
+		return nil, errors.Wrap(ErrInvalidId, id)
+	}
+	if bucket := tx.Bucket([]byte(kind)); bucket != nil {
+		if v := bucket.Get([]byte(id)); v != nil {
+			return decodeValue(id, v), nil
+		}
+	}
+	return nil, errors.Wrap(ErrNotFound, id)
+}
+
+func checkRevision(tx *bolt.Tx, kind, id, rev string) error {
+	if rev == "" {
+		return nil
+	}
+	current, err := get(tx, kind, id)
+	if err != nil {
+		return err
+	}
+	if current.Revision != rev {
+		return errors.Wrap(ErrConflict, id)
+	}
+	return nil
+}
+
+func (b *BoltStore) Put(kind, id string, data []byte, rev string) (string, error) {
+	var seq uint64
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		if !ValidId(id) {
+			return errors.Wrap(ErrInvalidId, id)
+		}
+		if err := checkRevision(tx, kind, id, rev); err != nil {
+			return err
+		}
+		bucket, err := tx.CreateBucketIfNotExists([]byte(kind))
+		if err != nil {
+			return err
+		}
+		seq, err = bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		v := make([]byte, 8+len(data))
+		binary.BigEndian.PutUint64(v, seq)
+		copy(v[8:], data)
+		return bucket.Put([]byte(id), v)
+	})
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatUint(seq, 10), nil
+}
+
+func (b *BoltStore) Delete(kind, id string, rev string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if _, err := get(tx, kind, id); err != nil {
+			return err
+		}
+		if err := checkRevision(tx, kind, id, rev); err != nil {
+			return err
+		}
+		return tx.Bucket([]byte(kind)).Delete([]byte(id))
+	})
+}
+
+// Check that the database is open for writing and can be read
+func (b *BoltStore) Check() error {
+	if b.db.IsReadOnly() {
+		return bolt.ErrDatabaseReadOnly
+	}
+	return b.db.View(func(tx *bolt.Tx) error {
+		return nil
+	})
+}
+
+func (b *BoltStore) Close() error {
+	return b.db.Close()
+}
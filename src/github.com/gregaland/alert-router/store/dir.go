@@ -0,0 +1,280 @@
+package store
+
+import (
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// File extensions read by the dir store.  Records are written as .yml.
+var FileExts = []string{".yml", ".yaml", ".json"}
+
+// DirStore keeps each record in a file named after its id.  Ids may
+// contain '/' to place records in subdirectories.  Hidden files and
+// directories are ignored.
+type DirStore struct {
+	lock sync.Mutex
+	dirs map[string]string
+	root string
+}
+
+// NewDirStore returns a DirStore.  dirs maps kinds to their directory;
+// kinds not in dirs are kept in a subdirectory of root, if root is set.
+func NewDirStore(dirs map[string]string, root string) *DirStore {
+	return &DirStore{dirs: dirs, root: root}
+}
+
+func (d *DirStore) dir(kind string) (string, error) {
+	if dir, ok := d.dirs[kind]; ok {
+		return dir, nil
+	}
+	if d.root == "" || !ValidId(kind) || strings.Contains(kind, "/") {
+		return "", errors.Errorf("no directory configured for %s", kind)
+	}
+	return filepath.Join(d.root, kind), nil
+}
+
+// Returns the files that may hold record id, after checking that id
+// can't escape the directory
+func (d *DirStore) paths(kind, id string) ([]string, error) {
+	if !ValidId(id) {
+		return nil, errors.Wrap(ErrInvalidId, id)
+	}
+	dir, err := d.dir(kind)
+	if err != nil {
+		return nil, err
+	}
+	base := filepath.Join(dir, filepath.FromSlash(id))
+	if rel, err := filepath.Rel(dir, base); err != nil || strings.HasPrefix(rel, "..") {
+		return nil, errors.Wrap(ErrInvalidId, id)
+	}
+	paths := make([]string, 0, len(FileExts))
+	for _, ext := range FileExts {
+		paths = append(paths, base+ext)
+	}
+	return paths, nil
+}
+
+func isRecordFile(name string) bool {
+	ext := filepath.Ext(name)
+	for _, e := range FileExts {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// List reads every record file below the kind's directory.  The
+// directories below root are made on the first write, so until then the
+// kind has no records.
+func (d *DirStore) List(kind string) ([]*Record, error) {
+	dir, err := d.dir(kind)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = os.Stat(dir); err != nil {
+		if _, configured := d.dirs[kind]; os.IsNotExist(err) && !configured {
+			return []*Record{}, nil
+		}
+		return nil, err
+	}
+
+	files := make(map[string]string)
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dir && strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() || !isRecordFile(info.Name()) {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		id := filepath.ToSlash(strings.TrimSuffix(rel, filepath.Ext(rel)))
+		if other, ok := files[id]; ok {
+			return errors.Errorf("%s and %s both hold %s", other, path, id)
+		}
+		files[id] = path
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(files))
+	for id := range files {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	records := make([]*Record, 0, len(ids))
+	for _, id := range ids {
+		data, err := ioutil.ReadFile(files[id])
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, &Record{Id: id, Revision: hashRevision(data), Data: data})
+	}
+	return records, nil
+}
+
+// Returns the file holding record id, or ErrNotFound
+func (d *DirStore) find(kind, id string) (string, error) {
+	paths, err := d.paths(kind, id)
+	if err != nil {
+		return "", err
+	}
+	for _, path := range paths {
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return paths[0], errors.Wrap(ErrNotFound, id)
+}
+
+func (d *DirStore) Get(kind, id string) (*Record, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.get(kind, id)
+}
+
+func (d *DirStore) get(kind, id string) (*Record, error) {
+	path, err := d.find(kind, id)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Record{Id: id, Revision: hashRevision(data), Data: data}, nil
+}
+
+// Check rev against the current revision of a record
+func (d *DirStore) checkRevision(kind, id, rev string) error {
+	if rev == "" {
+		return nil
+	}
+	current, err := d.get(kind, id)
+	if err != nil {
+		return err
+	}
+	if current.Revision != rev {
+		return errors.Wrap(ErrConflict, id)
+	}
+	return nil
+}
+
+// Put writes the record to <id>.yml, replacing any other file that held
+// it.  The file is written to a temporary file and renamed into place.
+func (d *DirStore) Put(kind, id string, data []byte, rev string) (string, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if err := d.checkRevision(kind, id, rev); err != nil {
+		return "", err
+	}
+	paths, err := d.paths(kind, id)
+	if err != nil {
+		return "", err
+	}
+	path := paths[0]
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return "", err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	for _, other := range paths[1:] {
+		if err = os.Remove(other); err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	return hashRevision(data), nil
+}
+
+func (d *DirStore) Delete(kind, id string, rev string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if err := d.checkRevision(kind, id, rev); err != nil {
+		return err
+	}
+	path, err := d.find(kind, id)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// W_OK is the access(2) mode for write permission
+const W_OK = 0x2
+
+// Check that each directory of the store exists and is writable
+func (d *DirStore) Check() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	dirs := make([]string, 0, len(d.dirs)+1)
+	for _, dir := range d.dirs {
+		dirs = append(dirs, dir)
+	}
+	dirs = append(dirs, d.root)
+	for _, dir := range dirs {
+		if dir == "" {
+			return errors.New("no directory configured")
+		}
+		if dir == d.root {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return err
+			}
+		}
+		info, err := os.Stat(dir)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return errors.Errorf("%s is not a directory", dir)
+		}
+		if err = syscall.Access(dir, W_OK); err != nil {
+			return &os.PathError{Op: "access", Path: dir, Err: err}
+		}
+	}
+	return nil
+}
+
+func (d *DirStore) Close() error {
+	return nil
+}
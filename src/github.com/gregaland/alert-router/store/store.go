@@ -0,0 +1,119 @@
+// Package store persists alert-router state: alert configs and anything
+// else that has to survive a restart.  Documents are grouped by kind and
+// carry a revision that changes on every write.
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"regexp"
+	"strings"
+)
+
+const (
+	KIND_ALERTS  string = "alerts"
+	KIND_PENDING string = "pending"
+	KIND_HEALTH  string = "health"
+	KIND_FIRES   string = "fires"
+	KIND_ROUTERS string = "routers"
+
+	DIR_STORE  string = "dir"
+	BOLT_STORE string = "bolt"
+
+	// directory a dir store without a path keeps the other kinds in,
+	// apart from the alerts, which are config
+	DEFAULT_STATE_DIR string = "/var/lib/alert-router"
+)
+
+var (
+	ErrLocked    = errors.New("locked by another process")
+	ErrNotFound  = errors.New("not found")
+	ErrConflict  = errors.New("revision does not match")
+	ErrInvalidId = errors.New("invalid id")
+)
+
+// Record is a stored document
+type Record struct {
+	Id       string
+	Revision string
+	Data     []byte
+}
+
+// Store is implemented by the storage backends.  A non empty rev passed
+// to Put or Delete must match the current revision of the record, or
+// ErrConflict is returned.
+type Store interface {
+	List(kind string) ([]*Record, error)
+	Get(kind, id string) (*Record, error)
+	Put(kind, id string, data []byte, rev string) (string, error)
+	Delete(kind, id string, rev string) error
+	// Check returns an error if the store can't be written.  It writes
+	// nothing, so it can run on every readiness probe.
+	Check() error
+	Close() error
+}
+
+// Config selects the storage backend
+type Config struct {
+	Type string `yaml:"type"`
+	Path string `yaml:"path,omitempty"`
+}
+
+// New opens the store selected by c.  The dir store keeps alerts in
+// alertsPath and other kinds in subdirectories of c.Path, by default
+// DEFAULT_STATE_DIR.  A nil config selects the dir store.
+func New(c *Config, alertsPath string) (Store, error) {
+	if c == nil {
+		c = &Config{}
+	}
+	switch c.Type {
+	case "", DIR_STORE:
+		dirs := map[string]string{KIND_ALERTS: alertsPath}
+		root := c.Path
+		if root == "" {
+			root = DEFAULT_STATE_DIR
+		}
+		return NewDirStore(dirs, root), nil
+	case BOLT_STORE:
+		if c.Path == "" {
+			return nil, errors.New("bolt store requires a path")
+		}
+		return NewBoltStore(c.Path)
+	}
+	return nil, errors.Errorf("unknown store type: %s", c.Type)
+}
+
+// NewReadOnly opens the store selected by c for reading, for commands
+// that run beside the server.  The server holds its bolt database locked,
+// so opening one fails with ErrLocked while it runs.
+func NewReadOnly(c *Config, alertsPath string) (Store, error) {
+	if c != nil && c.Type == BOLT_STORE && c.Path != "" {
+		return openBolt(c.Path, true)
+	}
+	return New(c, alertsPath)
+}
+
+// Record ids are one or more '/' separated segments of letters, digits,
+// '.', '_' and '-'.  A segment can't start with '.', which rules out
+// "..", hidden files and anything else that could leave the store.
+var idSegment = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*$`)
+
+// ValidId reports whether id is safe to use as a record id
+func ValidId(id string) bool {
+	if id == "" || len(id) > 255 {
+		return false
+	}
+	for _, seg := range strings.Split(id, "/") {
+		if !idSegment.MatchString(seg) {
+			return false
+		}
+	}
+	return true
+}
+
+// Content revision used by stores that don't keep a counter
+func hashRevision(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
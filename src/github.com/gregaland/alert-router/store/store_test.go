@@ -0,0 +1,183 @@
+package store
+
+import (
+	"github.com/pkg/errors"
+	"gotest.tools/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidId(t *testing.T) {
+	for _, id := range []string{"dbfail", "db.fail-2_x", "team-db/dbfail", "A"} {
+		assert.Assert(t, ValidId(id), id)
+	}
+	for _, id := range []string{"", "..", ".", "../../etc/x", "/etc/x", "team//x", ".hidden", "team/.x",
+		"db fail", `..\\x`, "x/"} {
+		assert.Assert(t, !ValidId(id), id)
+	}
+}
+
+func TestDirStore_List(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"greg.yml":              "alert: greg",
+		"team-db/db.yaml":       "alert: dbfail",
+		"team-db/nested/x.json": `{"alert": "diskfull"}`,
+		"README.md":             "not a record",
+		".git/x.yml":            "not a record",
+		".x.yml.swp":            "not a record",
+	}
+	for name, data := range files {
+		assert.NilError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755))
+		assert.NilError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644))
+	}
+
+	st := NewDirStore(map[string]string{KIND_ALERTS: dir}, "")
+	records, err := st.List(KIND_ALERTS)
+	assert.NilError(t, err)
+	actual := make(map[string]string)
+	for _, r := range records {
+		actual[r.Id] = string(r.Data)
+	}
+	assert.DeepEqual(t, map[string]string{
+		"greg":             "alert: greg",
+		"team-db/db":       "alert: dbfail",
+		"team-db/nested/x": `{"alert": "diskfull"}`,
+	}, actual)
+
+	// two files for one record
+	assert.NilError(t, ioutil.WriteFile(filepath.Join(dir, "greg.json"), []byte("{}"), 0644))
+	_, err = st.List(KIND_ALERTS)
+	assert.ErrorContains(t, err, "both hold greg")
+
+	_, err = st.List("fires")
+	assert.ErrorContains(t, err, "no directory configured for fires")
+}
+
+func TestDirStore_PathTraversal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+	alertsPath := filepath.Join(dir, "alerts.d")
+
+	st := NewDirStore(map[string]string{KIND_ALERTS: alertsPath}, "")
+	for _, id := range []string{"../x", "../../etc/x", "/tmp/x", ".."} {
+		_, err = st.Put(KIND_ALERTS, id, []byte("x"), "")
+		assert.Assert(t, errors.Cause(err) == ErrInvalidId, id)
+		err = st.Delete(KIND_ALERTS, id, "")
+		assert.Assert(t, errors.Cause(err) == ErrInvalidId, id)
+	}
+	files, err := ioutil.ReadDir(dir)
+	assert.NilError(t, err)
+	assert.Equal(t, 0, len(files))
+}
+
+// Runs the Store contract against a backend
+func testStore(t *testing.T, st Store) {
+	_, err := st.Get(KIND_ALERTS, "dbfail")
+	assert.Assert(t, errors.Cause(err) == ErrNotFound)
+
+	rev1, err := st.Put(KIND_ALERTS, "dbfail", []byte("alert: dbfail"), "")
+	assert.NilError(t, err)
+	r, err := st.Get(KIND_ALERTS, "dbfail")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, &Record{Id: "dbfail", Revision: rev1, Data: []byte("alert: dbfail")}, r)
+
+	// writes with a stale revision are refused
+	rev2, err := st.Put(KIND_ALERTS, "dbfail", []byte("alert: dbfail\nschedule: []"), rev1)
+	assert.NilError(t, err)
+	assert.Assert(t, rev1 != rev2)
+	_, err = st.Put(KIND_ALERTS, "dbfail", []byte("alert: dbfail"), rev1)
+	assert.Assert(t, errors.Cause(err) == ErrConflict)
+	err = st.Delete(KIND_ALERTS, "dbfail", rev1)
+	assert.Assert(t, errors.Cause(err) == ErrConflict)
+
+	_, err = st.Put(KIND_ALERTS, "team-db/dbslow", []byte("alert: dbslow"), "")
+	assert.NilError(t, err)
+	records, err := st.List(KIND_ALERTS)
+	assert.NilError(t, err)
+	assert.Equal(t, 2, len(records))
+	assert.Equal(t, "dbfail", records[0].Id)
+	assert.Equal(t, rev2, records[0].Revision)
+	assert.Equal(t, "team-db/dbslow", records[1].Id)
+
+	assert.NilError(t, st.Delete(KIND_ALERTS, "dbfail", rev2))
+	err = st.Delete(KIND_ALERTS, "dbfail", "")
+	assert.Assert(t, errors.Cause(err) == ErrNotFound)
+
+	// other kinds are kept apart
+	_, err = st.Put("fires", "dbslow", []byte("{}"), "")
+	assert.NilError(t, err)
+	records, err = st.List(KIND_ALERTS)
+	assert.NilError(t, err)
+	assert.Equal(t, 1, len(records))
+
+	// the check writes nothing
+	assert.NilError(t, st.Check())
+	records, err = st.List(KIND_ALERTS)
+	assert.NilError(t, err)
+	assert.Equal(t, 1, len(records))
+	records, err = st.List(KIND_HEALTH)
+	assert.NilError(t, err)
+	assert.Equal(t, 0, len(records))
+
+	assert.NilError(t, st.Close())
+}
+
+func TestStores(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	st, err := New(&Config{Type: DIR_STORE, Path: filepath.Join(dir, "state")}, filepath.Join(dir, "alerts.d"))
+	assert.NilError(t, err)
+	testStore(t, st)
+
+	boltConfig := &Config{Type: BOLT_STORE, Path: filepath.Join(dir, "alert-router.db")}
+	st, err = New(boltConfig, "")
+	assert.NilError(t, err)
+	testStore(t, st)
+
+	// commands beside the server can't open its database, and only read
+	// it once it is stopped
+	st, err = New(boltConfig, "")
+	assert.NilError(t, err)
+	_, err = NewReadOnly(boltConfig, "")
+	assert.Equal(t, ErrLocked, errors.Cause(err))
+	assert.NilError(t, st.Close())
+	st, err = NewReadOnly(boltConfig, "")
+	assert.NilError(t, err)
+	_, err = st.List(KIND_ALERTS)
+	assert.NilError(t, err)
+	_, err = st.Put(KIND_ALERTS, "a", []byte("alert: a\n"), "")
+	assert.Assert(t, err != nil)
+	assert.Assert(t, st.Check() != nil)
+	assert.NilError(t, st.Close())
+
+	// reads don't make the directories below root
+	st = NewDirStore(map[string]string{KIND_ALERTS: dir}, filepath.Join(dir, "fresh"))
+	records, err := st.List(KIND_FIRES)
+	assert.NilError(t, err)
+	assert.Equal(t, 0, len(records))
+	_, err = os.Stat(filepath.Join(dir, "fresh"))
+	assert.Assert(t, os.IsNotExist(err))
+
+	st = NewDirStore(map[string]string{KIND_ALERTS: filepath.Join(dir, "missing")}, "")
+	assert.Assert(t, st.Check() != nil)
+	st = NewDirStore(map[string]string{KIND_ALERTS: dir}, "")
+	assert.ErrorContains(t, st.Check(), "no directory configured")
+
+	// without a path, state is kept apart from the alerts
+	st, err = New(nil, dir)
+	assert.NilError(t, err)
+	assert.Equal(t, DEFAULT_STATE_DIR, st.(*DirStore).root)
+	assert.Equal(t, dir, st.(*DirStore).dirs[KIND_ALERTS])
+
+	_, err = New(&Config{Type: "etcd"}, "")
+	assert.ErrorContains(t, err, "unknown store type")
+}
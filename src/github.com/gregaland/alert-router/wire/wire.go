@@ -0,0 +1,142 @@
+// Package wire holds the bodies the api sends back, shared by the server
+// and its client.  It depends on nothing of the server but the config.
+package wire
+
+import (
+	"github.com/gregaland/alert-router/config"
+	"time"
+)
+
+// What applying a set of alerts does to one alert
+const (
+	ACTION_CREATE    string = "create"
+	ACTION_UPDATE    string = "update"
+	ACTION_UNCHANGED string = "unchanged"
+	ACTION_DELETE    string = "delete"
+)
+
+// Outcomes of a fire for one schedule
+const (
+	OUTCOME_PENDING   string = "pending"
+	OUTCOME_DELIVERED string = "delivered"
+	OUTCOME_FAILED    string = "failed"
+	OUTCOME_SKIPPED   string = "skipped"
+)
+
+// AlertStatus is an alert's config and the live state of its schedules.
+// The config is kept apart so it can be sent back as is to update the
+// alert.
+type AlertStatus struct {
+	AlertId string              `json:"alert"`
+	Config  *config.AlertConfig `json:"config"`
+	Status  []ScheduleStatus    `json:"status"`
+
+	// revision of the alert's config
+	Revision string `json:"-"`
+}
+
+// ScheduleStatus is whether a schedule is enabled now.  NextTransition is
+// when it is next enabled or disabled, if ever.
+type ScheduleStatus struct {
+	Id             string                `json:"id"`
+	RouterId       string                `json:"router_id"`
+	RouterType     config.RouteProcessor `json:"router_type,omitempty"`
+	EnabledNow     bool                  `json:"enabled_now"`
+	NextTransition *time.Time            `json:"next_transition,omitempty"`
+}
+
+// Change is what applying a set of alerts does to one alert
+type Change struct {
+	AlertId string `json:"alert"`
+	Action  string `json:"action"`
+}
+
+// ApplyResult lists the change to each alert, in the order of the set
+// applied and then the deleted alerts by id.  With DryRun set nothing was
+// changed.
+type ApplyResult struct {
+	DryRun  bool     `json:"dry_run"`
+	Changes []Change `json:"changes"`
+}
+
+// Fire is the record of one fire request and what became of it
+type Fire struct {
+	Id        string         `json:"fire_id"`
+	AlertId   string         `json:"alert"`
+	Message   string         `json:"msg"`
+	Received  time.Time      `json:"received"`
+	Error     string         `json:"error,omitempty"`
+	Schedules []FireSchedule `json:"schedules"`
+}
+
+// FireSchedule is the outcome of a fire for one schedule of the alert.
+// Skipped schedules give a reason; the others the router's latency once
+// it finished.
+type FireSchedule struct {
+	ScheduleId string     `json:"schedule_id"`
+	RouterId   string     `json:"router_id"`
+	Outcome    string     `json:"outcome"`
+	Reason     string     `json:"reason,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	LatencyMs  int64      `json:"latency_ms,omitempty"`
+	Finished   *time.Time `json:"finished,omitempty"`
+}
+
+// Notification is what a router sends for an event
+type Notification struct {
+	Recipients []string `json:"recipients,omitempty"`
+	Message    string   `json:"message"`
+}
+
+// Simulation is what firing an alert at an instant would do
+type Simulation struct {
+	AlertId   string              `json:"alert"`
+	At        time.Time           `json:"at"`
+	Schedules []SimulatedSchedule `json:"schedules"`
+}
+
+// SimulatedSchedule is the state of a schedule at the simulated instant
+// and, if it is enabled, what its router would send
+type SimulatedSchedule struct {
+	ScheduleId     string                `json:"schedule_id"`
+	RouterId       string                `json:"router_id"`
+	RouterType     config.RouteProcessor `json:"router_type,omitempty"`
+	Enabled        bool                  `json:"enabled"`
+	Reason         string                `json:"reason"`
+	NextTransition *time.Time            `json:"next_transition,omitempty"`
+	Notification   *Notification         `json:"notification,omitempty"`
+	Error          string                `json:"error,omitempty"`
+}
+
+// RouterHealth is the outcome of a router's last deliveries and probes
+type RouterHealth struct {
+	RouterId      string     `json:"router_id"`
+	LastSuccess   *time.Time `json:"last_success,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastErrorTime *time.Time `json:"last_error_time,omitempty"`
+	LastProbe     *time.Time `json:"last_probe,omitempty"`
+}
+
+// RouterInfo describes a configured router.  Config is what the router
+// was built with, secrets redacted.
+type RouterInfo struct {
+	RouterId string                `json:"router_id"`
+	Type     config.RouteProcessor `json:"type"`
+	Enabled  bool                  `json:"enabled"`
+	Origin   string                `json:"origin"`
+	Config   interface{}           `json:"config,omitempty"`
+	Health   RouterHealth          `json:"health"`
+
+	// revision of the router's record, for routers added through the api
+	Revision string `json:"-"`
+}
+
+// RouterTest is the result of sending a test message through a router
+type RouterTest struct {
+	RouterId     string                `json:"router_id"`
+	RouterType   config.RouteProcessor `json:"router_type"`
+	Outcome      string                `json:"outcome"`
+	Error        string                `json:"error,omitempty"`
+	LatencyMs    int64                 `json:"latency_ms,omitempty"`
+	Notification *Notification         `json:"notification,omitempty"`
+}